@@ -0,0 +1,379 @@
+// Package alerting fires alerts from the message stream. Rules match single
+// messages, count messages over a window or notice a device going quiet, and
+// their alerts, including resolve notifications, are sent to a Notifier such
+// as Alertmanager.
+package alerting
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/metajar/metalogger/internal/logger"
+	"github.com/metajar/metalogger/internal/metrics/prometheus"
+	"github.com/metajar/metalogger/internal/rules"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// maxPending bounds the notifications kept for retry while the notifier is
+// failing.
+const maxPending = 10000
+
+// group is the state of a rule for one GroupBy key.
+type group struct {
+	id          string
+	rule        *compiledRule
+	hits        []time.Time
+	lastSeen    time.Time
+	labels      map[string]string
+	annotations map[string]string
+	alert       *Alert
+	elem        *list.Element
+}
+
+// Engine is a Processor. Messages pass through unchanged.
+type Engine struct {
+	mu         sync.Mutex
+	rules      []*compiledRule
+	groups     map[string]*group
+	lru        *list.List
+	pending    []Alert
+	lastResend time.Time
+	notifier   Notifier
+	now        func() time.Time
+	done       chan struct{}
+	stopOnce   sync.Once
+
+	interval     time.Duration
+	resend       time.Duration
+	maxGroups    int
+	generatorURL string
+	// absent counts the groups of each absence rule, they are kept out of
+	// the LRU
+	absent map[string]int
+}
+
+type Option func(*Engine)
+
+// WithInterval sets how often rules are evaluated and notifications sent.
+// Defaults to 10 seconds.
+func WithInterval(d time.Duration) Option {
+	return func(e *Engine) {
+		e.interval = d
+	}
+}
+
+// WithResendInterval sets how often firing alerts are sent again so
+// Alertmanager keeps them active. Defaults to 1 minute.
+func WithResendInterval(d time.Duration) Option {
+	return func(e *Engine) {
+		e.resend = d
+	}
+}
+
+// WithMaxGroups bounds the number of tracked rule groups, the least recently
+// seen is forgotten when full. Absence rules are bounded separately, each
+// tracks up to this many groups and ignores new ones once full, since the
+// group seen least recently is the one about to fire. Defaults to 100000.
+func WithMaxGroups(i int) Option {
+	return func(e *Engine) {
+		e.maxGroups = i
+	}
+}
+
+// WithGeneratorURL is set on every alert as a link back to the source.
+func WithGeneratorURL(u string) Option {
+	return func(e *Engine) {
+		e.generatorURL = u
+	}
+}
+
+// WithClock replaces time.Now for the rule windows, alert timestamps and
+// resends. The evaluation loop started by New still ticks every interval of
+// wall time, it evaluates at the clock's time.
+func WithClock(now func() time.Time) Option {
+	return func(e *Engine) {
+		e.now = now
+	}
+}
+
+// New compiles the rules, returning an error if any of them is invalid, and
+// starts evaluating them in the background.
+func New(rules []Rule, notifier Notifier, opts ...Option) (*Engine, error) {
+	e := &Engine{
+		groups:    make(map[string]*group),
+		lru:       list.New(),
+		absent:    make(map[string]int),
+		notifier:  notifier,
+		now:       time.Now,
+		done:      make(chan struct{}),
+		interval:  10 * time.Second,
+		resend:    time.Minute,
+		maxGroups: 100000,
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	e.lastResend = e.now()
+	for _, r := range rules {
+		cr, err := compile(r)
+		if err != nil {
+			return nil, err
+		}
+		e.rules = append(e.rules, cr)
+	}
+	go e.loop()
+	return e, nil
+}
+
+// Stop stops evaluating and sends the notifications still pending.
+func (e *Engine) Stop() {
+	e.stopOnce.Do(func() {
+		close(e.done)
+		e.flush()
+	})
+}
+
+func (e *Engine) Process(parts format.LogParts) format.LogParts {
+	now := e.now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, r := range e.rules {
+		captures, ok := r.matches(parts)
+		if !ok {
+			continue
+		}
+		key, ok := rules.GroupKey(r.GroupBy, parts, captures)
+		if !ok {
+			continue
+		}
+		g := e.group(r, key)
+		if g == nil {
+			continue
+		}
+		g.lastSeen = now
+		g.labels, g.annotations = render(r, parts, captures)
+		if g.elem != nil {
+			e.lru.MoveToBack(g.elem)
+		}
+
+		switch r.Type {
+		case Match:
+			if g.alert == nil {
+				e.fire(g, now)
+			}
+		case Threshold:
+			g.hits = append(trim(g.hits, now, time.Duration(r.Window)), now)
+			if g.alert == nil && len(g.hits) >= r.Count {
+				e.fire(g, now)
+			}
+		case Absence:
+			if g.alert != nil {
+				e.resolve(g, now)
+			}
+		}
+	}
+	return parts
+}
+
+// group returns the state of a rule for key, or nil if it is an absence
+// rule that is already tracking as many groups as it may.
+func (e *Engine) group(r *compiledRule, key string) *group {
+	id := r.Name + "\x00" + key
+	if g, ok := e.groups[id]; ok {
+		return g
+	}
+	g := &group{id: id, rule: r}
+	if r.Type == Absence {
+		// Evicting the group seen least recently would forget exactly the
+		// device that went quiet.
+		if e.maxGroups > 0 && e.absent[r.Name] >= e.maxGroups {
+			return nil
+		}
+		e.absent[r.Name]++
+		e.groups[id] = g
+		return g
+	}
+	if e.maxGroups > 0 && e.lru.Len() >= e.maxGroups {
+		old := e.lru.Front().Value.(*group)
+		e.lru.Remove(old.elem)
+		delete(e.groups, old.id)
+	}
+	g.elem = e.lru.PushBack(g)
+	e.groups[id] = g
+	return g
+}
+
+// fire starts an alert for the group. Must be called with the lock held.
+func (e *Engine) fire(g *group, now time.Time) {
+	g.alert = &Alert{
+		Labels:       g.labels,
+		Annotations:  g.annotations,
+		StartsAt:     now,
+		GeneratorURL: e.generatorURL,
+	}
+	a := *g.alert
+	a.EndsAt = now.Add(4 * e.resend)
+	e.queue(a)
+	prometheus.AlertsFired.WithLabelValues(g.rule.Name).Inc()
+}
+
+// resolve ends the alert of the group. Must be called with the lock held.
+func (e *Engine) resolve(g *group, now time.Time) {
+	a := *g.alert
+	a.EndsAt = now
+	g.alert = nil
+	e.queue(a)
+}
+
+func (e *Engine) queue(a Alert) {
+	if len(e.pending) >= maxPending {
+		e.pending = e.pending[1:]
+	}
+	e.pending = append(e.pending, a)
+}
+
+// evaluate resolves and fires the alerts that depend on time passing rather
+// than on a message, and queues firing alerts again when they are due.
+func (e *Engine) evaluate(now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, g := range e.groups {
+		r := g.rule
+		switch r.Type {
+		case Match:
+			if g.alert != nil && now.Sub(g.lastSeen) >= time.Duration(r.ResolveAfter) {
+				e.resolve(g, now)
+			}
+		case Threshold:
+			g.hits = trim(g.hits, now, time.Duration(r.Window))
+			if g.alert != nil && len(g.hits) < r.Count {
+				e.resolve(g, now)
+			}
+		case Absence:
+			if g.alert == nil && now.Sub(g.lastSeen) >= time.Duration(r.For) {
+				e.fire(g, now)
+			}
+		}
+	}
+	if now.Sub(e.lastResend) < e.resend {
+		return
+	}
+	e.lastResend = now
+	for _, g := range e.groups {
+		if g.alert == nil || g.alert.StartsAt.Equal(now) {
+			continue
+		}
+		a := *g.alert
+		a.EndsAt = now.Add(4 * e.resend)
+		e.queue(a)
+	}
+}
+
+// flush sends the pending notifications, keeping them for the next attempt
+// if the notifier fails.
+func (e *Engine) flush() {
+	e.mu.Lock()
+	pending := e.pending
+	e.pending = nil
+	e.mu.Unlock()
+	if len(pending) == 0 || e.notifier == nil {
+		return
+	}
+	if err := e.notifier.Notify(pending); err != nil {
+		logger.SugarLogger.Errorw("sending alerts", "error", err, "alerts", len(pending))
+		prometheus.AlertNotifications.WithLabelValues("failed").Inc()
+		e.mu.Lock()
+		e.pending = append(pending, e.pending...)
+		if len(e.pending) > maxPending {
+			e.pending = e.pending[len(e.pending)-maxPending:]
+		}
+		e.mu.Unlock()
+		return
+	}
+	prometheus.AlertNotifications.WithLabelValues("sent").Inc()
+}
+
+func (e *Engine) loop() {
+	tick := time.NewTicker(e.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-tick.C:
+			e.evaluate(e.now())
+			e.flush()
+		}
+	}
+}
+
+// Active returns the firing alerts.
+func (e *Engine) Active() []Alert {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var alerts []Alert
+	for _, g := range e.groups {
+		if g.alert != nil {
+			alerts = append(alerts, *g.alert)
+		}
+	}
+	return alerts
+}
+
+func trim(hits []time.Time, now time.Time, window time.Duration) []time.Time {
+	i := 0
+	for i < len(hits) && now.Sub(hits[i]) >= window {
+		i++
+	}
+	return hits[i:]
+}
+
+// matches reports whether the regexes and conditions hold, returning the
+// named captures.
+func (r *compiledRule) matches(parts format.LogParts) (map[string]string, bool) {
+	captures := map[string]string{}
+	for field, re := range r.match {
+		v, ok := rules.Lookup(field, parts, nil)
+		if !ok {
+			return nil, false
+		}
+		m := re.FindStringSubmatch(v)
+		if m == nil {
+			return nil, false
+		}
+		for i, name := range re.SubexpNames() {
+			if name != "" {
+				captures[name] = m[i]
+			}
+		}
+	}
+	for _, c := range r.conds {
+		v, ok := rules.Lookup(c.field, parts, captures)
+		if !ok || !c.holds(v) {
+			return nil, false
+		}
+	}
+	return captures, true
+}
+
+func render(r *compiledRule, parts format.LogParts, captures map[string]string) (map[string]string, map[string]string) {
+	expand := func(s string) string {
+		return rules.Expand(s, parts, captures)
+	}
+	labels := map[string]string{"alertname": r.Name}
+	for _, f := range r.GroupBy {
+		labels[labelName.ReplaceAllString(f, "_")], _ = rules.Lookup(f, parts, captures)
+	}
+	for k, v := range r.Labels {
+		labels[k] = expand(v)
+	}
+	var annotations map[string]string
+	if len(r.Annotations) > 0 {
+		annotations = make(map[string]string, len(r.Annotations))
+		for k, v := range r.Annotations {
+			annotations[k] = expand(v)
+		}
+	}
+	return labels, annotations
+}
@@ -0,0 +1,241 @@
+package alerting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/metajar/metalogger/internal/fakeclock"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type AlertingSuite struct {
+	cleanup []func()
+}
+
+func (s *AlertingSuite) TearDownTest(c *C) {
+	for _, f := range s.cleanup {
+		f()
+	}
+	s.cleanup = nil
+}
+
+var _ = Suite(&AlertingSuite{})
+
+var rulesJSON = `[
+  {
+    "name": "BGPDown",
+    "match": {"content": "neighbor (?P<peer>\\S+) Down"},
+    "expr": ["severity <= 5"],
+    "group_by": ["hostname", "peer"],
+    "resolve_after": "1m",
+    "labels": {"severity": "critical"},
+    "annotations": {"summary": "BGP neighbor %{peer} down on %{hostname}"}
+  },
+  {
+    "name": "AuthFailures",
+    "type": "threshold",
+    "match": {"content": "authentication failure"},
+    "group_by": ["host.hostname"],
+    "count": 3,
+    "window": "1m"
+  },
+  {
+    "name": "DeviceSilent",
+    "type": "absence",
+    "expr": ["hostname =~ ^core"],
+    "group_by": ["hostname"],
+    "for": "10m"
+  }
+]`
+
+// alertmanager is a stand-in for the Alertmanager v2 API.
+type alertmanager struct {
+	mu     sync.Mutex
+	posts  [][]Alert
+	status int
+}
+
+func (a *alertmanager) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if r.URL.Path != "/api/v2/alerts" || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if a.status != 0 {
+		w.WriteHeader(a.status)
+		return
+	}
+	var alerts []Alert
+	if err := json.NewDecoder(r.Body).Decode(&alerts); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	a.posts = append(a.posts, alerts)
+}
+
+func (a *alertmanager) last() []Alert {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.posts) == 0 {
+		return nil
+	}
+	return a.posts[len(a.posts)-1]
+}
+
+func (s *AlertingSuite) newTest(c *C, opts ...Option) (*Engine, *fakeclock.Clock, *alertmanager) {
+	path := filepath.Join(c.MkDir(), "rules.json")
+	c.Assert(os.WriteFile(path, []byte(rulesJSON), 0644), IsNil)
+	rules, err := LoadRules(path)
+	c.Assert(err, IsNil)
+
+	am := &alertmanager{}
+	srv := httptest.NewServer(am)
+	clk := fakeclock.New()
+	e, err := New(rules, NewAlertmanager(srv.URL+"/"), append([]Option{WithInterval(time.Hour), WithClock(clk.Now)}, opts...)...)
+	c.Assert(err, IsNil)
+	s.cleanup = append(s.cleanup, e.Stop, srv.Close)
+	return e, clk, am
+}
+
+func (s *AlertingSuite) TestMatchFiresAndResolves(c *C) {
+	e, clk, am := s.newTest(c)
+	msg := format.LogParts{"hostname": "r1", "severity": 5, "content": "%BGP-5-ADJCHANGE: neighbor 10.0.0.1 Down"}
+	c.Check(e.Process(msg), DeepEquals, msg)
+	// Too low a severity.
+	e.Process(format.LogParts{"hostname": "r2", "severity": 6, "content": "neighbor 10.0.0.2 Down"})
+	e.flush()
+
+	alerts := am.last()
+	c.Assert(alerts, HasLen, 1)
+	c.Check(alerts[0].Labels, DeepEquals, map[string]string{
+		"alertname": "BGPDown",
+		"hostname":  "r1",
+		"peer":      "10.0.0.1",
+		"severity":  "critical",
+	})
+	c.Check(alerts[0].Annotations["summary"], Equals, "BGP neighbor 10.0.0.1 down on r1")
+	c.Check(alerts[0].StartsAt.Equal(clk.Now()), Equals, true)
+	c.Check(alerts[0].EndsAt.After(clk.Now()), Equals, true)
+
+	// Still matching, no new notification.
+	clk.Advance(30 * time.Second)
+	e.Process(msg)
+	e.evaluate(clk.Now())
+	c.Check(e.pending, HasLen, 0)
+
+	clk.Advance(time.Minute)
+	e.evaluate(clk.Now())
+	e.flush()
+	alerts = am.last()
+	c.Assert(alerts, HasLen, 1)
+	c.Check(alerts[0].EndsAt.Equal(clk.Now()), Equals, true)
+	c.Check(e.Active(), HasLen, 0)
+}
+
+func (s *AlertingSuite) TestThreshold(c *C) {
+	e, clk, am := s.newTest(c)
+	msg := format.LogParts{"host.hostname": "fw1", "content": "sshd: authentication failure for root"}
+	e.Process(msg)
+	e.Process(msg)
+	c.Check(e.Active(), HasLen, 0)
+	clk.Advance(10 * time.Second)
+	e.Process(msg)
+	active := e.Active()
+	c.Assert(active, HasLen, 1)
+	c.Check(active[0].Labels["host_hostname"], Equals, "fw1")
+
+	clk.Advance(55 * time.Second)
+	e.evaluate(clk.Now())
+	c.Check(e.Active(), HasLen, 0)
+	e.flush()
+	c.Assert(am.posts, HasLen, 1)
+	c.Check(am.posts[0], HasLen, 2)
+}
+
+func (s *AlertingSuite) TestAbsence(c *C) {
+	e, clk, am := s.newTest(c)
+	e.Process(format.LogParts{"hostname": "core1", "content": "hello"})
+	e.Process(format.LogParts{"hostname": "edge1", "content": "hello"})
+	clk.Advance(9 * time.Minute)
+	e.evaluate(clk.Now())
+	c.Check(e.Active(), HasLen, 0)
+
+	clk.Advance(time.Minute)
+	e.evaluate(clk.Now())
+	e.flush()
+	alerts := am.last()
+	c.Assert(alerts, HasLen, 1)
+	c.Check(alerts[0].Labels, DeepEquals, map[string]string{"alertname": "DeviceSilent", "hostname": "core1"})
+
+	// Firing alerts are sent again once the resend interval passed.
+	clk.Advance(time.Minute)
+	e.evaluate(clk.Now())
+	c.Check(e.pending, HasLen, 1)
+
+	e.Process(format.LogParts{"hostname": "core1", "content": "back"})
+	e.flush()
+	alerts = am.last()
+	c.Assert(alerts, HasLen, 2)
+	c.Check(alerts[1].EndsAt.Equal(clk.Now()), Equals, true)
+}
+
+func (s *AlertingSuite) TestAbsenceNotEvicted(c *C) {
+	e, clk, am := s.newTest(c, WithMaxGroups(2))
+	e.Process(format.LogParts{"hostname": "core1", "content": "hello"})
+	// A busy rule filling the LRU does not push out the quiet device.
+	for _, peer := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"} {
+		e.Process(format.LogParts{"hostname": "r1", "severity": 3, "content": "neighbor " + peer + " Down"})
+	}
+	// The absence rule tracks up to two groups itself.
+	e.Process(format.LogParts{"hostname": "core2", "content": "hello"})
+	e.Process(format.LogParts{"hostname": "core3", "content": "hello"})
+	c.Check(e.groups, HasLen, 4)
+
+	clk.Advance(10 * time.Minute)
+	e.evaluate(clk.Now())
+	e.flush()
+	var silent []string
+	for _, a := range am.last() {
+		if a.Labels["alertname"] == "DeviceSilent" {
+			silent = append(silent, a.Labels["hostname"])
+		}
+	}
+	sort.Strings(silent)
+	c.Check(silent, DeepEquals, []string{"core1", "core2"})
+}
+
+func (s *AlertingSuite) TestNotifyFailureRetries(c *C) {
+	e, _, am := s.newTest(c)
+	am.status = http.StatusServiceUnavailable
+	e.Process(format.LogParts{"hostname": "r1", "severity": 3, "content": "neighbor 10.0.0.1 Down"})
+	e.flush()
+	c.Check(am.posts, HasLen, 0)
+	c.Check(e.pending, HasLen, 1)
+
+	am.status = 0
+	e.flush()
+	c.Check(am.posts, HasLen, 1)
+	c.Check(e.pending, HasLen, 0)
+}
+
+func (s *AlertingSuite) TestInvalidRules(c *C) {
+	_, err := New([]Rule{{Name: "x", Type: Threshold}}, nil)
+	c.Check(err, ErrorMatches, "rule x: threshold needs a positive count and window")
+	_, err = New([]Rule{{Name: "x", Expr: []string{"severity ~ 3"}}}, nil)
+	c.Check(err, ErrorMatches, `rule x expr "severity ~ 3": unknown operator ~`)
+	_, err = New([]Rule{{Name: "x", Expr: []string{"severity < high"}}}, nil)
+	c.Check(err, ErrorMatches, `rule x expr "severity < high": .*invalid syntax`)
+	_, err = New([]Rule{{Name: "x", Type: "sometimes"}}, nil)
+	c.Check(err, ErrorMatches, `rule x: unknown type "sometimes"`)
+}
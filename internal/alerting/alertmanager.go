@@ -0,0 +1,57 @@
+package alerting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Alert is an alert as posted to the Alertmanager v2 API.
+type Alert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       time.Time         `json:"endsAt"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// Notifier delivers alerts.
+type Notifier interface {
+	Notify(alerts []Alert) error
+}
+
+// Alertmanager posts alerts to the Alertmanager v2 API.
+type Alertmanager struct {
+	URL    string
+	Client *http.Client
+}
+
+// NewAlertmanager returns a Notifier for the Alertmanager at base, e.g.
+// http://alertmanager:9093.
+func NewAlertmanager(base string) *Alertmanager {
+	return &Alertmanager{
+		URL:    strings.TrimRight(base, "/") + "/api/v2/alerts",
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (a *Alertmanager) Notify(alerts []Alert) error {
+	b, err := json.Marshal(alerts)
+	if err != nil {
+		return err
+	}
+	resp, err := a.Client.Post(a.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("alertmanager returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package alerting
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metajar/metalogger/internal/rules"
+)
+
+// Rule types.
+const (
+	// Match fires as soon as a message matches and resolves once no message
+	// matched for ResolveAfter.
+	Match = "match"
+	// Threshold fires once Count messages matched within Window and resolves
+	// when the count drops below it again.
+	Threshold = "threshold"
+	// Absence fires when a group that has been seen before sent no matching
+	// message for For, and resolves on the next one.
+	Absence = "absence"
+)
+
+// Duration is a time.Duration that reads as "5s" or "2m" in JSON.
+type Duration = rules.Duration
+
+// Rule describes when to fire an alert.
+type Rule struct {
+	Name string `json:"name"`
+	// Type is one of match, threshold or absence, defaults to match.
+	Type string `json:"type"`
+	// Match is a field to regex map, every regex must match. Named capture
+	// groups can be used in GroupBy, labels and annotations like fields.
+	Match map[string]string `json:"match"`
+	// Expr are conditions of the form "field op value" which must all hold.
+	// Supported operators are ==, !=, =~, !~, <, <=, > and >=, the ordering
+	// operators compare numerically.
+	Expr []string `json:"expr"`
+	// GroupBy fields split the rule into one alert per distinct value, e.g.
+	// hostname. They are added to the alert labels.
+	GroupBy []string `json:"group_by"`
+	// Count and Window configure threshold rules.
+	Count  int      `json:"count"`
+	Window Duration `json:"window"`
+	// For is how long an absence rule waits for a message.
+	For Duration `json:"for"`
+	// ResolveAfter is how long a match rule stays firing without new
+	// matches, defaults to 5m.
+	ResolveAfter Duration `json:"resolve_after"`
+	// Labels and Annotations of the alert, %{field} is replaced from the
+	// fields and captures of the last matching message.
+	Labels      map[string]string `json:"labels"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// LoadRules reads a JSON array of rules from path.
+func LoadRules(path string) ([]Rule, error) {
+	var r []Rule
+	if err := rules.Load(path, &r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+type condition struct {
+	field string
+	op    string
+	value string
+	num   float64
+	re    *regexp.Regexp
+}
+
+type compiledRule struct {
+	Rule
+	match map[string]*regexp.Regexp
+	conds []condition
+}
+
+var labelName = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+func compile(r Rule) (*compiledRule, error) {
+	if r.Name == "" {
+		return nil, fmt.Errorf("rule without a name")
+	}
+	switch r.Type {
+	case "":
+		r.Type = Match
+	case Match:
+	case Threshold:
+		if r.Count <= 0 || r.Window <= 0 {
+			return nil, fmt.Errorf("rule %s: threshold needs a positive count and window", r.Name)
+		}
+	case Absence:
+		if r.For <= 0 {
+			return nil, fmt.Errorf("rule %s: absence needs a positive for", r.Name)
+		}
+	default:
+		return nil, fmt.Errorf("rule %s: unknown type %q", r.Name, r.Type)
+	}
+	if r.ResolveAfter <= 0 {
+		r.ResolveAfter = Duration(5 * time.Minute)
+	}
+	cr := &compiledRule{Rule: r, match: map[string]*regexp.Regexp{}}
+	for field, expr := range r.Match {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s field %s: %w", r.Name, field, err)
+		}
+		cr.match[field] = re
+	}
+	for _, e := range r.Expr {
+		c, err := parseCondition(e)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s expr %q: %w", r.Name, e, err)
+		}
+		cr.conds = append(cr.conds, c)
+	}
+	return cr, nil
+}
+
+func parseCondition(e string) (condition, error) {
+	f := strings.Fields(e)
+	if len(f) < 3 {
+		return condition{}, fmt.Errorf("expected field op value")
+	}
+	c := condition{field: f[0], op: f[1], value: strings.Join(f[2:], " ")}
+	var err error
+	switch c.op {
+	case "==", "!=":
+	case "=~", "!~":
+		c.re, err = regexp.Compile(c.value)
+	case "<", "<=", ">", ">=":
+		c.num, err = strconv.ParseFloat(c.value, 64)
+	default:
+		err = fmt.Errorf("unknown operator %s", c.op)
+	}
+	return c, err
+}
+
+func (c condition) holds(v string) bool {
+	switch c.op {
+	case "==":
+		return v == c.value
+	case "!=":
+		return v != c.value
+	case "=~":
+		return c.re.MatchString(v)
+	case "!~":
+		return !c.re.MatchString(v)
+	}
+	n, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return false
+	}
+	switch c.op {
+	case "<":
+		return n < c.num
+	case "<=":
+		return n <= c.num
+	case ">":
+		return n > c.num
+	default:
+		return n >= c.num
+	}
+}
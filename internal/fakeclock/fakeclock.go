@@ -0,0 +1,33 @@
+// Package fakeclock is a settable clock for the tests of the processors and
+// limiters that take a clock option.
+package fakeclock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock starts at midnight on 2022-12-12 UTC and only moves when told to. It
+// is safe to read from background goroutines while a test moves it.
+type Clock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func New() *Clock {
+	return &Clock{t: time.Date(2022, 12, 12, 0, 0, 0, 0, time.UTC)}
+}
+
+// Now is passed to the clock options, e.g. dedup.WithClock(clk.Now)
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Advance moves the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
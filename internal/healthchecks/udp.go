@@ -0,0 +1,69 @@
+package healthchecks
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/metajar/metalogger/internal/logger"
+	"github.com/metajar/metalogger/internal/syslogger"
+)
+
+// UDPSockets fails when the kernel dropped datagrams since the last check or
+// granted a UDP socket a smaller receive buffer than requested.
+type UDPSockets struct {
+	stats func() []syslog.SocketStats
+
+	mu       sync.Mutex
+	last     []syslog.SocketStats
+	drops    map[string]uint64
+	problems []string
+}
+
+// NewUDPSockets checks the sockets of a server, pass it Server.SocketStats.
+func NewUDPSockets(stats func() []syslog.SocketStats) *UDPSockets {
+	return &UDPSockets{stats: stats, drops: map[string]uint64{}}
+}
+
+func (u *UDPSockets) Init() {
+	u.Check()
+}
+
+func (u *UDPSockets) Check() bool {
+	stats := u.stats()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.problems = nil
+	for _, st := range stats {
+		key := fmt.Sprintf("%s/%d", st.Listener, st.Socket)
+		if st.Clamped() {
+			u.problems = append(u.problems, fmt.Sprintf("%s: receive buffer %d, requested %d", key, st.ReceiveBuffer, st.RequestedBuffer))
+		}
+		drops := st.Drops
+		if st.Overflows > drops {
+			drops = st.Overflows
+		}
+		if last, ok := u.drops[key]; ok && drops > last {
+			u.problems = append(u.problems, fmt.Sprintf("%s: %d datagrams dropped", key, drops-last))
+		}
+		u.drops[key] = drops
+	}
+	u.last = stats
+	return len(u.problems) == 0
+}
+
+// State returns the statistics and problems found by the last check.
+func (u *UDPSockets) State() ([]syslog.SocketStats, []string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.last, u.problems
+}
+
+func (u *UDPSockets) Success() {
+	stats, _ := u.State()
+	logger.SugarLogger.Infow("udp sockets good", "sockets", len(stats))
+}
+
+func (u *UDPSockets) Failure() {
+	stats, problems := u.State()
+	logger.SugarLogger.Warnw("udp sockets dropping datagrams", "problems", problems, "stats", stats)
+}
@@ -1,31 +1,56 @@
 package metalogger
 
 import (
+	"github.com/metajar/metalogger/internal/healthchecks"
 	"github.com/metajar/metalogger/internal/logger"
 	"github.com/metajar/metalogger/internal/metrics/prometheus"
+	"github.com/metajar/metalogger/internal/ratelimit"
 	"github.com/metajar/metalogger/internal/syslogger"
 	"github.com/metajar/metalogger/internal/syslogger/format"
+	"net/http"
 	"time"
 )
 
 // MetaLogger is simply the main application that handles
 // all the coordination in the system.
 type MetaLogger struct {
-	Server             *syslog.Server
-	Handler            *syslog.ChannelHandler
-	Channel            chan format.LogParts
-	Processors         []Processor
-	writers            []Writer
-	HealthChecks       []HealthCheck
-	healthCheckCadence time.Duration
-	format             format.Format
-	socketSize         int
-	address            string
+	Server               *syslog.Server
+	Handler              *syslog.ChannelHandler
+	Channel              chan format.LogParts
+	Processors           []Processor
+	writers              []Writer
+	HealthChecks         []HealthCheck
+	healthCheckCadence   time.Duration
+	format               format.Format
+	socketSize           int
+	address              string
+	sourceLimiter        *ratelimit.Limiter
+	locationResolver     syslog.LocationResolver
+	formatSelector       syslog.FormatSelector
+	timestampPolicy      syslog.TimestampPolicy
+	keepRaw              bool
+	parseErrorAction     syslog.ParseErrorAction
+	listenerErrorActions map[string]syslog.ParseErrorAction
+	quarantine           Writer
+	failuresPattern      string
+	listeners            []syslog.ListenerConfig
+	statsInterval        time.Duration
+	udpHealthCheck       bool
+	acl                  *syslog.ACL
 }
 
+// Processor takes in a message and returns the processed message. Returning
+// nil drops the message, no further processors or writers will see it.
 type Processor interface {
 	Process(parts format.LogParts) format.LogParts
 }
+
+// Emitter is implemented by processors that produce events of their own, such
+// as summaries or synthesized events. Emitted events continue down the
+// pipeline from the processor after the one that emitted them.
+type Emitter interface {
+	SetEmit(emit func(parts format.LogParts))
+}
 type Writer interface {
 	Write(parts format.LogParts)
 }
@@ -57,32 +82,64 @@ func (s *MetaLogger) HealthCheckRoutine() {
 // Run will take
 func (s *MetaLogger) Run() {
 	s.Server.SetHandler(s.Handler)
-	if err := s.Server.ListenUDP(s.address); err != nil {
-		logger.SugarLogger.Fatalln(err)
+	listeners := s.listeners
+	if len(listeners) == 0 {
+		listeners = []syslog.ListenerConfig{{Protocol: "udp", Address: s.address}}
 	}
-	logger.SugarLogger.Infow("metalogger started up", "address", s.address)
+	for _, l := range listeners {
+		if l.ACL == nil {
+			l.ACL = s.acl
+		}
+		if a, ok := s.listenerErrorActions[l.Name]; ok && l.ParseErrorAction == syslog.ParseErrorDefault {
+			l.ParseErrorAction = a
+		}
+		if err := s.Server.Listen(l); err != nil {
+			logger.SugarLogger.Fatalln(err)
+		}
+		logger.SugarLogger.Infow("metalogger listening", "name", l.Name, "protocol", l.Protocol, "address", l.Address)
+	}
+	logger.SugarLogger.Infow("metalogger started up")
 	if err := s.Server.Boot(); err != nil {
 		logger.SugarLogger.Fatalln(err)
 	}
 	go s.HealthCheckRoutine()
+	if s.sourceLimiter != nil {
+		s.sourceLimiter.SetEmit(func(parts format.LogParts) {
+			s.process(parts, 0)
+		})
+	}
+	for i, p := range s.Processors {
+		if e, ok := p.(Emitter); ok {
+			next := i + 1
+			e.SetEmit(func(parts format.LogParts) {
+				s.process(parts, next)
+			})
+		}
+	}
 	go func(channel syslog.LogPartsChannel) {
 		for logParts := range channel {
 			logParts := logParts
 			// Takes each message off the channel and throws it into its own goroutine.
 			// This helps speed up the processing vs channel etc.
-			go func() {
-				for _, p := range s.Processors {
-					logParts = p.Process(logParts)
-				}
-				for _, w := range s.writers {
-					w.Write(logParts)
-				}
-			}()
+			go s.process(logParts, 0)
 		}
 	}(s.Channel)
 	s.Server.Wait()
 }
 
+// process runs the message through the processors starting at from and then
+// hands it to the writers, unless a processor dropped it.
+func (s *MetaLogger) process(logParts format.LogParts, from int) {
+	for _, p := range s.Processors[from:] {
+		if logParts = p.Process(logParts); logParts == nil {
+			return
+		}
+	}
+	for _, w := range s.writers {
+		w.Write(logParts)
+	}
+}
+
 type Option func(*MetaLogger)
 
 func WithSocketSize(i int) Option {
@@ -97,6 +154,39 @@ func WithAddress(a string) Option {
 	}
 }
 
+// WithListeners replaces the single UDP listener on WithAddress with any
+// number of UDP, TCP, TLS and Unix listeners, each optionally with its own
+// format, TLS config, socket buffer size and read timeout.
+func WithListeners(l ...syslog.ListenerConfig) Option {
+	return func(s *MetaLogger) {
+		s.listeners = append(s.listeners, l...)
+	}
+}
+
+// WithSocketStatsInterval sets how often the UDP socket statistics, drops and
+// receive buffer sizes, are exported to Prometheus.
+func WithSocketStatsInterval(t time.Duration) Option {
+	return func(s *MetaLogger) {
+		s.statsInterval = t
+	}
+}
+
+// WithUDPHealthCheck adds a health check that fails when the kernel drops
+// datagrams or clamps the receive buffer of a UDP listener.
+func WithUDPHealthCheck() Option {
+	return func(s *MetaLogger) {
+		s.udpHealthCheck = true
+	}
+}
+
+// WithACL restricts the sources of the listeners that have no ACL of their
+// own, including the default UDP listener.
+func WithACL(acl *syslog.ACL) Option {
+	return func(s *MetaLogger) {
+		s.acl = acl
+	}
+}
+
 func WithHealthCheckCadence(t time.Duration) Option {
 	return func(s *MetaLogger) {
 		s.healthCheckCadence = t
@@ -125,6 +215,90 @@ func WithFormat(f format.Format) Option {
 	}
 }
 
+// WithSourceRateLimit limits messages per source IP before they are parsed.
+// Summaries of suppressed messages go through the whole pipeline.
+func WithSourceRateLimit(l *ratelimit.Limiter) Option {
+	return func(s *MetaLogger) {
+		s.sourceLimiter = l
+	}
+}
+
+// WithFormatSelector picks the format by source network or hostname, ahead of
+// the listener and global formats.
+func WithFormatSelector(sel syslog.FormatSelector) Option {
+	return func(s *MetaLogger) {
+		s.formatSelector = sel
+	}
+}
+
+// WithLocationResolver sets the time zone of each device for timestamps that
+// carry no offset, see the timezone package.
+func WithLocationResolver(r syslog.LocationResolver) Option {
+	return func(s *MetaLogger) {
+		s.locationResolver = r
+	}
+}
+
+// WithTimestampPolicy flags or replaces device timestamps too far from the
+// receive time.
+func WithTimestampPolicy(p syslog.TimestampPolicy) Option {
+	return func(s *MetaLogger) {
+		s.timestampPolicy = p
+	}
+}
+
+// WithKeepRaw keeps the message as received in the raw field, handy for
+// debugging devices that send malformed messages.
+func WithKeepRaw() Option {
+	return func(s *MetaLogger) {
+		s.keepRaw = true
+	}
+}
+
+// WithParseErrorPolicy decides what happens to messages that fail to parse,
+// they are passed on with parse_error set by default.
+func WithParseErrorPolicy(a syslog.ParseErrorAction) Option {
+	return func(s *MetaLogger) {
+		s.parseErrorAction = a
+	}
+}
+
+// WithListenerParseErrorPolicy overrides WithParseErrorPolicy for the
+// listener with the given name, unless its ListenerConfig sets a policy.
+func WithListenerParseErrorPolicy(listener string, a syslog.ParseErrorAction) Option {
+	return func(s *MetaLogger) {
+		if s.listenerErrorActions == nil {
+			s.listenerErrorActions = map[string]syslog.ParseErrorAction{}
+		}
+		s.listenerErrorActions[listener] = a
+	}
+}
+
+// WithQuarantineWriter receives the messages diverted by
+// syslog.ParseErrorQuarantine, including their raw bytes. It is required by
+// that policy.
+func WithQuarantineWriter(w Writer) Option {
+	return func(s *MetaLogger) {
+		s.quarantine = w
+	}
+}
+
+// WithParseFailuresEndpoint serves the most recent parse failures as JSON on
+// the Prometheus metrics port.
+func WithParseFailuresEndpoint(pattern string) Option {
+	return func(s *MetaLogger) {
+		s.failuresPattern = pattern
+	}
+}
+
+// WithHTTPHandler registers an API or admin handler, such as a processor that
+// exposes its state. Handlers are served on the Prometheus metrics port.
+func WithHTTPHandler(pattern string, h http.Handler) Option {
+	return func(s *MetaLogger) {
+		http.Handle(pattern, h)
+	}
+}
+
 func WithPrometehusMetrics(port int) Option {
 	return func(s *MetaLogger) {
 		prometheus.PromServer(port)
@@ -141,10 +315,39 @@ func NewMetalogger(opts ...Option) *MetaLogger {
 	handler := syslog.NewChannelHandler(channel)
 	server := syslog.NewServer()
 	if mlogger.format == nil {
-		logger.SugarLogger.Fatalln("a formatter will need to be set")
+		if len(mlogger.listeners) == 0 {
+			logger.SugarLogger.Fatalln("a formatter will need to be set")
+		}
+		for _, l := range mlogger.listeners {
+			if l.Format == nil {
+				logger.SugarLogger.Fatalw("a formatter will need to be set", "listener", l.Name)
+			}
+		}
 	}
 	server.SetFormat(mlogger.format)
 	server.SetSocketSize(mlogger.socketSize)
+	if mlogger.sourceLimiter != nil {
+		server.SetRateLimiter(mlogger.sourceLimiter)
+	}
+	server.SetTimestampPolicy(mlogger.timestampPolicy)
+	server.SetKeepRaw(mlogger.keepRaw)
+	server.SetParseErrorPolicy(mlogger.parseErrorAction)
+	if mlogger.quarantine != nil {
+		server.SetQuarantineWriter(mlogger.quarantine)
+	}
+	if mlogger.failuresPattern != "" {
+		http.Handle(mlogger.failuresPattern, server.FailuresHandler())
+	}
+	if mlogger.formatSelector != nil {
+		server.SetFormatSelector(mlogger.formatSelector)
+	}
+	if mlogger.locationResolver != nil {
+		server.SetLocationResolver(mlogger.locationResolver)
+	}
+	server.SetSocketStatsInterval(mlogger.statsInterval)
+	if mlogger.udpHealthCheck {
+		mlogger.HealthChecks = append(mlogger.HealthChecks, healthchecks.NewUDPSockets(server.SocketStats))
+	}
 	mlogger.Server = server
 	mlogger.Handler = handler
 	mlogger.Channel = channel
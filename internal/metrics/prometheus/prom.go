@@ -14,6 +14,94 @@ var (
 		Name: "metalogger_messages_recieved",
 		Help: "The total number of processed messages",
 	})
+	MessagesDeduplicated = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "metalogger_messages_deduplicated",
+		Help: "The total number of duplicate messages suppressed",
+	})
+	MessagesSampled = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "metalogger_messages_sampled",
+		Help: "The total number of messages dropped by sampling",
+	})
+	ParseErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metalogger_parse_errors",
+		Help: "The total number of messages that failed to parse by format and listener",
+	}, []string{"format", "listener"})
+	QuarantineDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metalogger_quarantine_dropped",
+		Help: "The total number of quarantined messages dropped because the quarantine queue was full by listener",
+	}, []string{"listener"})
+	FramingErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metalogger_framing_errors",
+		Help: "The total number of stream connections closed for invalid framing by format and listener",
+	}, []string{"format", "listener"})
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metalogger_messages_rate_limited",
+		Help: "The total number of messages suppressed by rate limiting",
+	}, []string{"limiter", "key"})
+	ObjectUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "metalogger_object_up",
+		Help: "Last known state of a tracked interface or BGP neighbor, 1 for up",
+	}, []string{"device", "kind", "name"})
+	ObjectFlaps = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "metalogger_object_flaps",
+		Help: "State changes of a tracked interface or BGP neighbor within the flap window",
+	}, []string{"device", "kind", "name"})
+	ObjectFlapping = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "metalogger_object_flapping",
+		Help: "Whether a tracked interface or BGP neighbor is considered flapping",
+	}, []string{"device", "kind", "name"})
+	AlertsFired = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metalogger_alerts_fired",
+		Help: "The total number of alerts fired by rule",
+	}, []string{"rule"})
+	AlertNotifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metalogger_alert_notifications",
+		Help: "The total number of alert notification batches by result",
+	}, []string{"result"})
+	Redactions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metalogger_redactions",
+		Help: "The total number of values redacted by detector and field",
+	}, []string{"detector", "field"})
+	ProxyHeaderErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metalogger_proxy_header_errors",
+		Help: "The total number of connections and datagrams from trusted proxies with an invalid PROXY header by listener",
+	}, []string{"listener"})
+	TLSClients = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metalogger_tls_clients",
+		Help: "The total number of TLS client connections authorized by listener and result",
+	}, []string{"listener", "result"})
+	TLSReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metalogger_tls_reloads",
+		Help: "The total number of TLS certificate reloads by result",
+	}, []string{"result"})
+	ACLRejected = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metalogger_acl_rejected",
+		Help: "The total number of datagrams and connections rejected by listener ACLs by listener, rule and kind",
+	}, []string{"listener", "rule", "kind"})
+	ACLReloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "metalogger_acl_reloads",
+		Help: "The total number of ACL file reloads by result",
+	}, []string{"result"})
+	UDPReceiveBufferRequested = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "metalogger_udp_receive_buffer_requested_bytes",
+		Help: "The receive buffer size requested per UDP listener and socket",
+	}, []string{"listener", "socket"})
+	UDPReceiveBuffer = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "metalogger_udp_receive_buffer_bytes",
+		Help: "The receive buffer size granted by the kernel per UDP listener and socket, Linux doubles the requested size",
+	}, []string{"listener", "socket"})
+	UDPDrops = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "metalogger_udp_drops",
+		Help: "The total number of datagrams dropped by the kernel per UDP listener and socket, from /proc/net/udp",
+	}, []string{"listener", "socket"})
+	UDPOverflows = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "metalogger_udp_rxq_overflows",
+		Help: "The total number of datagrams dropped by the kernel per UDP listener and socket as of the last datagram read, from SO_RXQ_OVFL",
+	}, []string{"listener", "socket"})
+	UDPQueued = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "metalogger_udp_receive_queue_bytes",
+		Help: "The bytes waiting to be read per UDP listener and socket",
+	}, []string{"listener", "socket"})
 )
 
 func PromServer(port int) {
@@ -0,0 +1,271 @@
+// Package correlate watches the message stream for configured sequences of
+// messages, such as an interface going down followed by a BGP neighbor going
+// down on the same box, and emits a synthesized event when a sequence
+// completes. Rules are plain structs so they can be declared in a JSON file,
+// see LoadRules.
+package correlate
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/metajar/metalogger/internal/rules"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// Fields set on the correlated event.
+const (
+	RuleName = "correlation_rule"
+	GroupKey = "correlation_key"
+	Events   = "correlated_events"
+)
+
+// partial is a sequence that has matched its first steps.
+type partial struct {
+	id       string
+	rule     *compiledRule
+	key      string
+	start    time.Time
+	events   []format.LogParts
+	captures []map[string]string
+	elem     *list.Element
+}
+
+// Correlator is a Processor and Emitter. Messages pass through unchanged,
+// correlated events are emitted once a rule completes.
+type Correlator struct {
+	mu          sync.Mutex
+	rules       []*compiledRule
+	partials    map[string]*partial
+	lru         *list.List
+	emit        func(format.LogParts)
+	now         func() time.Time
+	lastSweep   time.Time
+	maxPartials int
+}
+
+type Option func(*Correlator)
+
+// WithMaxPartials bounds the number of in progress sequences across all rules.
+// The oldest is dropped when full. Defaults to 10000.
+func WithMaxPartials(i int) Option {
+	return func(c *Correlator) {
+		c.maxPartials = i
+	}
+}
+
+// WithClock replaces time.Now for the sequence windows and the timestamp of
+// correlated events.
+func WithClock(now func() time.Time) Option {
+	return func(c *Correlator) {
+		c.now = now
+	}
+}
+
+// New compiles the rules, returning an error if any of them is invalid.
+func New(rules []Rule, opts ...Option) (*Correlator, error) {
+	c := &Correlator{
+		partials:    make(map[string]*partial),
+		lru:         list.New(),
+		now:         time.Now,
+		maxPartials: 10000,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	for _, r := range rules {
+		cr, err := compile(r)
+		if err != nil {
+			return nil, err
+		}
+		c.rules = append(c.rules, cr)
+	}
+	return c, nil
+}
+
+func (c *Correlator) SetEmit(emit func(format.LogParts)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.emit = emit
+}
+
+func (c *Correlator) Process(parts format.LogParts) format.LogParts {
+	now := c.now()
+	var out []format.LogParts
+
+	c.mu.Lock()
+	if now.Sub(c.lastSweep) >= time.Second {
+		c.sweep(now)
+		c.lastSweep = now
+	}
+	for _, r := range c.rules {
+		if e := c.apply(r, parts, now); e != nil {
+			out = append(out, e)
+		}
+	}
+	emit := c.emit
+	c.mu.Unlock()
+
+	if emit != nil {
+		for _, e := range out {
+			emit(e)
+		}
+	}
+	return parts
+}
+
+// apply runs a message against a rule, returning the correlated event if the
+// message completed the sequence. Must be called with the lock held.
+func (c *Correlator) apply(r *compiledRule, parts format.LogParts, now time.Time) format.LogParts {
+	// Try to advance an in progress sequence first.
+	for i := 1; i < len(r.steps); i++ {
+		captures, ok := r.steps[i].matches(parts)
+		if !ok {
+			continue
+		}
+		key, ok := rules.GroupKey(r.GroupBy, parts, captures)
+		if !ok {
+			continue
+		}
+		p, ok := c.partials[partialID(r, key)]
+		if !ok || len(p.events) != i || now.Sub(p.start) > time.Duration(r.Within) {
+			continue
+		}
+		if !distinct(r.Distinct, p, parts, captures) {
+			continue
+		}
+		p.events = append(p.events, copyParts(parts))
+		p.captures = append(p.captures, captures)
+		if len(p.events) == len(r.steps) {
+			c.remove(p)
+			return c.event(p, now)
+		}
+		c.lru.MoveToBack(p.elem)
+		return nil
+	}
+
+	captures, ok := r.steps[0].matches(parts)
+	if !ok {
+		return nil
+	}
+	key, ok := rules.GroupKey(r.GroupBy, parts, captures)
+	if !ok {
+		return nil
+	}
+	p := &partial{
+		id:       partialID(r, key),
+		rule:     r,
+		key:      key,
+		start:    now,
+		events:   []format.LogParts{copyParts(parts)},
+		captures: []map[string]string{captures},
+	}
+	if len(r.steps) == 1 {
+		return c.event(p, now)
+	}
+	if old, ok := c.partials[p.id]; ok {
+		// A repeated first step must not restart the window, or a chatty
+		// first step would keep the sequence open forever.
+		if now.Sub(old.start) <= time.Duration(r.Within) {
+			return nil
+		}
+		c.remove(old)
+	}
+	if c.maxPartials > 0 && len(c.partials) >= c.maxPartials {
+		c.remove(c.lru.Front().Value.(*partial))
+	}
+	p.elem = c.lru.PushBack(p)
+	c.partials[p.id] = p
+	return nil
+}
+
+func (c *Correlator) remove(p *partial) {
+	c.lru.Remove(p.elem)
+	delete(c.partials, p.id)
+}
+
+// sweep drops sequences that can no longer complete.
+func (c *Correlator) sweep(now time.Time) {
+	for _, p := range c.partials {
+		if now.Sub(p.start) > time.Duration(p.rule.Within) {
+			c.remove(p)
+		}
+	}
+}
+
+func (c *Correlator) event(p *partial, now time.Time) format.LogParts {
+	first := p.events[0]
+	msg := p.rule.Message
+	if msg == "" {
+		msg = fmt.Sprintf("%s correlated %d events for %s", p.rule.Name, len(p.events), p.key)
+	}
+	msg = rules.Expand(msg, first, p.captures[0])
+	severity := 4
+	if p.rule.Severity != nil {
+		severity = *p.rule.Severity
+	}
+	e := format.LogParts{
+		"timestamp": now,
+		"content":   msg,
+		"severity":  severity,
+		RuleName:    p.rule.Name,
+		GroupKey:    p.key,
+		Events:      p.events,
+	}
+	if h, ok := first["hostname"]; ok {
+		e["hostname"] = h
+	}
+	return e
+}
+
+// matches reports whether every field regex matches, returning the named
+// captures.
+func (s compiledStep) matches(parts format.LogParts) (map[string]string, bool) {
+	captures := map[string]string{}
+	for field, re := range s.match {
+		v, ok := parts[field]
+		if !ok || v == nil {
+			return nil, false
+		}
+		str, ok := v.(string)
+		if !ok {
+			str = fmt.Sprint(v)
+		}
+		m := re.FindStringSubmatch(str)
+		if m == nil {
+			return nil, false
+		}
+		for i, name := range re.SubexpNames() {
+			if name != "" {
+				captures[name] = m[i]
+			}
+		}
+	}
+	return captures, true
+}
+
+func distinct(fields []string, p *partial, parts format.LogParts, captures map[string]string) bool {
+	for _, f := range fields {
+		v, _ := rules.Lookup(f, parts, captures)
+		for i, e := range p.events {
+			if prev, _ := rules.Lookup(f, e, p.captures[i]); prev == v {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func partialID(r *compiledRule, key string) string {
+	return r.Name + "\x00" + key
+}
+
+func copyParts(parts format.LogParts) format.LogParts {
+	c := make(format.LogParts, len(parts))
+	for k, v := range parts {
+		c[k] = v
+	}
+	return c
+}
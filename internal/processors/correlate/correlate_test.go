@@ -0,0 +1,142 @@
+package correlate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/metajar/metalogger/internal/fakeclock"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type CorrelateSuite struct{}
+
+var _ = Suite(&CorrelateSuite{})
+
+var rulesJSON = `[
+  {
+    "name": "link-then-bgp",
+    "steps": [
+      {"match": {"content": "Interface (?P<ifname>\\S+), changed state to Down"}},
+      {"match": {"content": "BGP neighbor \\S+ Down"}}
+    ],
+    "within": "5s",
+    "group_by": ["hostname"],
+    "message": "%{ifname} down took BGP down on %{hostname}",
+    "severity": 2
+  },
+  {
+    "name": "circuit-down",
+    "steps": [
+      {"match": {"mnemonic": "^UPDOWN$", "message": "circuit (?P<circuit>\\w+).*Down"}},
+      {"match": {"mnemonic": "^UPDOWN$", "message": "circuit (?P<circuit>\\w+).*Down"}}
+    ],
+    "within": "10s",
+    "group_by": ["circuit"],
+    "distinct": ["hostname"]
+  }
+]`
+
+func newTest(c *C, opts ...Option) (*Correlator, *fakeclock.Clock, *[]format.LogParts) {
+	path := filepath.Join(c.MkDir(), "rules.json")
+	c.Assert(os.WriteFile(path, []byte(rulesJSON), 0644), IsNil)
+	rules, err := LoadRules(path)
+	c.Assert(err, IsNil)
+	clk := fakeclock.New()
+	cr, err := New(rules, append(opts, WithClock(clk.Now))...)
+	c.Assert(err, IsNil)
+	var emitted []format.LogParts
+	cr.SetEmit(func(parts format.LogParts) {
+		emitted = append(emitted, parts)
+	})
+	return cr, clk, &emitted
+}
+
+func (s *CorrelateSuite) TestSequenceSameHost(c *C) {
+	cr, clk, emitted := newTest(c)
+
+	down := format.LogParts{"hostname": "r1", "content": "Interface Gi0/1, changed state to Down"}
+	c.Check(cr.Process(down), DeepEquals, down)
+	// Another box does not complete the sequence.
+	cr.Process(format.LogParts{"hostname": "r2", "content": "BGP neighbor 10.0.0.1 Down"})
+	c.Check(*emitted, HasLen, 0)
+
+	clk.Advance(3 * time.Second)
+	cr.Process(format.LogParts{"hostname": "r1", "content": "BGP neighbor 10.0.0.1 Down"})
+	c.Assert(*emitted, HasLen, 1)
+	e := (*emitted)[0]
+	c.Check(e[RuleName], Equals, "link-then-bgp")
+	c.Check(e[GroupKey], Equals, "r1")
+	c.Check(e["content"], Equals, "Gi0/1 down took BGP down on r1")
+	c.Check(e["severity"], Equals, 2)
+	c.Check(e["hostname"], Equals, "r1")
+	c.Assert(e[Events], HasLen, 2)
+	c.Check(e[Events].([]format.LogParts)[0]["content"], Equals, "Interface Gi0/1, changed state to Down")
+	c.Check(cr.partials, HasLen, 0)
+}
+
+func (s *CorrelateSuite) TestSequenceExpires(c *C) {
+	cr, clk, emitted := newTest(c)
+	cr.Process(format.LogParts{"hostname": "r1", "content": "Interface Gi0/1, changed state to Down"})
+	clk.Advance(6 * time.Second)
+	cr.Process(format.LogParts{"hostname": "r1", "content": "BGP neighbor 10.0.0.1 Down"})
+	c.Check(*emitted, HasLen, 0)
+	c.Check(cr.partials, HasLen, 0)
+}
+
+func (s *CorrelateSuite) TestRepeatedFirstStep(c *C) {
+	cr, clk, emitted := newTest(c)
+	down := format.LogParts{"hostname": "r1", "content": "Interface Gi0/1, changed state to Down"}
+	cr.Process(down)
+	// Repeats of the first step do not restart the window.
+	for i := 0; i < 2; i++ {
+		clk.Advance(2 * time.Second)
+		cr.Process(down)
+	}
+	clk.Advance(2 * time.Second)
+	cr.Process(format.LogParts{"hostname": "r1", "content": "BGP neighbor 10.0.0.1 Down"})
+	c.Check(*emitted, HasLen, 0)
+
+	// Once expired, the first step starts a new sequence.
+	cr.Process(down)
+	cr.Process(format.LogParts{"hostname": "r1", "content": "BGP neighbor 10.0.0.1 Down"})
+	c.Check(*emitted, HasLen, 1)
+}
+
+func (s *CorrelateSuite) TestBothEnds(c *C) {
+	cr, _, emitted := newTest(c)
+	msg := func(host string) format.LogParts {
+		return format.LogParts{"hostname": host, "mnemonic": "UPDOWN", "message": "Interface Gi0/1 circuit CKT42 changed state to Down"}
+	}
+	cr.Process(msg("r1"))
+	// The same end again is not the other end.
+	cr.Process(msg("r1"))
+	c.Check(*emitted, HasLen, 0)
+	cr.Process(msg("r2"))
+	c.Assert(*emitted, HasLen, 1)
+	c.Check((*emitted)[0][GroupKey], Equals, "CKT42")
+	c.Check((*emitted)[0]["content"], Equals, "circuit-down correlated 2 events for CKT42")
+	c.Check((*emitted)[0]["severity"], Equals, 4)
+}
+
+func (s *CorrelateSuite) TestMaxPartials(c *C) {
+	cr, _, _ := newTest(c, WithMaxPartials(2))
+	for _, h := range []string{"r1", "r2", "r3"} {
+		cr.Process(format.LogParts{"hostname": h, "content": "Interface Gi0/1, changed state to Down"})
+	}
+	c.Check(cr.partials, HasLen, 2)
+	c.Check(cr.lru.Front().Value.(*partial).key, Equals, "r2")
+}
+
+func (s *CorrelateSuite) TestInvalidRules(c *C) {
+	_, err := New([]Rule{{Name: "x", Steps: []Step{{Match: map[string]string{"content": "("}}}, Within: Duration(time.Second)}})
+	c.Check(err, ErrorMatches, "rule x step 0 field content: .*")
+	_, err = New([]Rule{{Name: "x", Steps: []Step{{}}}})
+	c.Check(err, ErrorMatches, "rule x: within must be positive")
+	_, err = New([]Rule{{Name: "x", Steps: []Step{{Match: map[string]string{"content": "a"}}, {}}, Within: Duration(time.Second)}})
+	c.Check(err, ErrorMatches, "rule x step 1: no match, it would match every message")
+}
@@ -0,0 +1,85 @@
+package correlate
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/metajar/metalogger/internal/rules"
+)
+
+// Duration is a time.Duration that reads as "5s" or "2m" in JSON.
+type Duration = rules.Duration
+
+// Step matches a single message. Every field regex must match. Named capture
+// groups are made available to GroupBy, Distinct and the event message as if
+// they were fields of the message.
+type Step struct {
+	Match map[string]string `json:"match"`
+}
+
+// Rule describes a sequence of messages to correlate.
+type Rule struct {
+	Name string `json:"name"`
+	// Steps must be seen in order.
+	Steps []Step `json:"steps"`
+	// Within is how long the whole sequence may take.
+	Within Duration `json:"within"`
+	// GroupBy fields must be equal across all steps, e.g. hostname for
+	// events on the same box or a captured circuit ID for both ends of a
+	// circuit.
+	GroupBy []string `json:"group_by"`
+	// Distinct fields must differ between steps, e.g. hostname when the
+	// steps come from different devices.
+	Distinct []string `json:"distinct"`
+	// Message of the correlated event, %{field} is replaced from the fields
+	// and captures of the first step.
+	Message string `json:"message"`
+	// Severity of the correlated event, defaults to 4 (warning).
+	Severity *int `json:"severity"`
+}
+
+// LoadRules reads a JSON array of rules from path.
+func LoadRules(path string) ([]Rule, error) {
+	var r []Rule
+	if err := rules.Load(path, &r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+type compiledStep struct {
+	match map[string]*regexp.Regexp
+}
+
+type compiledRule struct {
+	Rule
+	steps []compiledStep
+}
+
+func compile(r Rule) (*compiledRule, error) {
+	if r.Name == "" {
+		return nil, fmt.Errorf("rule without a name")
+	}
+	if len(r.Steps) == 0 {
+		return nil, fmt.Errorf("rule %s: no steps", r.Name)
+	}
+	if r.Within <= 0 {
+		return nil, fmt.Errorf("rule %s: within must be positive", r.Name)
+	}
+	cr := &compiledRule{Rule: r}
+	for i, s := range r.Steps {
+		if len(s.Match) == 0 {
+			return nil, fmt.Errorf("rule %s step %d: no match, it would match every message", r.Name, i)
+		}
+		cs := compiledStep{match: map[string]*regexp.Regexp{}}
+		for field, expr := range s.Match {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s step %d field %s: %w", r.Name, i, field, err)
+			}
+			cs.match[field] = re
+		}
+		cr.steps = append(cr.steps, cs)
+	}
+	return cr, nil
+}
@@ -0,0 +1,257 @@
+// Package dedup collapses repeated messages. The first occurrence of a message
+// is passed on straight away, duplicates within the window are dropped and a
+// summary carrying the repeat count is emitted once the window closes, much
+// like the classic "last message repeated N times".
+package dedup
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metajar/metalogger/internal/metrics/prometheus"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// Fields added to the summary event.
+const (
+	RepeatCount = "repeat_count"
+	FirstSeen   = "first_seen"
+	LastSeen    = "last_seen"
+)
+
+type entry struct {
+	key   string
+	parts format.LogParts
+	first time.Time
+	last  time.Time
+	count int
+	elem  *list.Element
+}
+
+// Dedup is a Processor and Emitter. Summaries are only produced when it is
+// wired up to an emitter, which MetaLogger does automatically.
+type Dedup struct {
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lru       *list.List
+	emit      func(format.LogParts)
+	now       func() time.Time
+	done      chan struct{}
+	stopOnce  sync.Once
+	fields    []string
+	messages  []string
+	window    time.Duration
+	maxWindow time.Duration
+	maxKeys   int
+	interval  time.Duration
+}
+
+type Option func(*Dedup)
+
+// WithWindow sets how long after the last duplicate the window stays open.
+// Defaults to 30 seconds.
+func WithWindow(w time.Duration) Option {
+	return func(d *Dedup) {
+		d.window = w
+	}
+}
+
+// WithMaxWindow caps how long a window can be kept open by a steady stream of
+// duplicates so a summary still goes out periodically. Defaults to 5 minutes.
+func WithMaxWindow(w time.Duration) Option {
+	return func(d *Dedup) {
+		d.maxWindow = w
+	}
+}
+
+// WithMaxKeys bounds the number of messages tracked. When full the least
+// recently seen message is evicted and its summary emitted early. Defaults
+// to 10000.
+func WithMaxKeys(i int) Option {
+	return func(d *Dedup) {
+		d.maxKeys = i
+	}
+}
+
+// WithKeyFields sets the fields besides the message that identify a
+// duplicate. Defaults to hostname.
+func WithKeyFields(fields ...string) Option {
+	return func(d *Dedup) {
+		d.fields = fields
+	}
+}
+
+// WithMessageFields sets the fields the message is read from, the first one
+// present is used. Defaults to content and message.
+func WithMessageFields(fields ...string) Option {
+	return func(d *Dedup) {
+		d.messages = fields
+	}
+}
+
+// WithClock replaces time.Now for the dedup windows. The sweep started by New
+// still ticks on wall time, it reads the clock to decide what expired.
+func WithClock(now func() time.Time) Option {
+	return func(d *Dedup) {
+		d.now = now
+	}
+}
+
+func New(opts ...Option) *Dedup {
+	d := &Dedup{
+		entries:   make(map[string]*entry),
+		lru:       list.New(),
+		now:       time.Now,
+		done:      make(chan struct{}),
+		fields:    []string{"hostname"},
+		messages:  []string{"content", "message"},
+		window:    30 * time.Second,
+		maxWindow: 5 * time.Minute,
+		maxKeys:   10000,
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	d.interval = d.window / 4
+	if d.interval < 10*time.Millisecond {
+		d.interval = 10 * time.Millisecond
+	}
+	go d.sweep()
+	return d
+}
+
+func (d *Dedup) SetEmit(emit func(format.LogParts)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.emit = emit
+}
+
+// Stop stops the background sweeper and emits all pending summaries.
+func (d *Dedup) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.done)
+		d.mu.Lock()
+		var out []format.LogParts
+		for _, e := range d.entries {
+			out = d.close(e, out)
+		}
+		emit := d.emit
+		d.mu.Unlock()
+		d.send(emit, out)
+	})
+}
+
+func (d *Dedup) Process(parts format.LogParts) format.LogParts {
+	key, ok := d.key(parts)
+	if !ok {
+		return parts
+	}
+	now := d.now()
+
+	d.mu.Lock()
+	if e, ok := d.entries[key]; ok {
+		e.count++
+		e.last = now
+		d.lru.MoveToBack(e.elem)
+		d.mu.Unlock()
+		prometheus.MessagesDeduplicated.Inc()
+		return nil
+	}
+
+	var out []format.LogParts
+	if d.maxKeys > 0 && len(d.entries) >= d.maxKeys {
+		out = d.close(d.lru.Front().Value.(*entry), out)
+	}
+	e := &entry{key: key, parts: copyParts(parts), first: now, last: now}
+	e.elem = d.lru.PushBack(e)
+	d.entries[key] = e
+	emit := d.emit
+	d.mu.Unlock()
+
+	d.send(emit, out)
+	return parts
+}
+
+func (d *Dedup) key(parts format.LogParts) (string, bool) {
+	var msg string
+	found := false
+	for _, f := range d.messages {
+		if s, ok := parts[f].(string); ok {
+			msg, found = s, true
+			break
+		}
+	}
+	if !found {
+		return "", false
+	}
+	var b strings.Builder
+	for _, f := range d.fields {
+		fmt.Fprint(&b, parts[f])
+		b.WriteByte(0)
+	}
+	b.WriteString(strings.Join(strings.Fields(msg), " "))
+	return b.String(), true
+}
+
+func (d *Dedup) sweep() {
+	t := time.NewTicker(d.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-t.C:
+			d.expire()
+		}
+	}
+}
+
+// expire closes every window that has run its course.
+func (d *Dedup) expire() {
+	now := d.now()
+	d.mu.Lock()
+	var out []format.LogParts
+	for _, e := range d.entries {
+		if now.Sub(e.last) >= d.window || now.Sub(e.first) >= d.maxWindow {
+			out = d.close(e, out)
+		}
+	}
+	emit := d.emit
+	d.mu.Unlock()
+	d.send(emit, out)
+}
+
+// close forgets the entry and appends its summary to out if there were any
+// duplicates. Must be called with the lock held.
+func (d *Dedup) close(e *entry, out []format.LogParts) []format.LogParts {
+	delete(d.entries, e.key)
+	d.lru.Remove(e.elem)
+	if e.count == 0 {
+		return out
+	}
+	summary := copyParts(e.parts)
+	summary[RepeatCount] = e.count
+	summary[FirstSeen] = e.first
+	summary[LastSeen] = e.last
+	return append(out, summary)
+}
+
+func (d *Dedup) send(emit func(format.LogParts), out []format.LogParts) {
+	if emit == nil {
+		return
+	}
+	for _, parts := range out {
+		emit(parts)
+	}
+}
+
+func copyParts(parts format.LogParts) format.LogParts {
+	c := make(format.LogParts, len(parts)+3)
+	for k, v := range parts {
+		c[k] = v
+	}
+	return c
+}
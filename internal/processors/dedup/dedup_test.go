@@ -0,0 +1,99 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/metajar/metalogger/internal/fakeclock"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type DedupSuite struct{}
+
+var _ = Suite(&DedupSuite{})
+
+func newTest(opts ...Option) (*Dedup, *fakeclock.Clock, *[]format.LogParts) {
+	// A long window keeps the background sweeper out of the way, the tests
+	// call expire themselves.
+	clk := fakeclock.New()
+	d := New(append([]Option{WithWindow(time.Hour), WithMaxWindow(4 * time.Hour), WithClock(clk.Now)}, opts...)...)
+	var emitted []format.LogParts
+	d.SetEmit(func(parts format.LogParts) {
+		emitted = append(emitted, parts)
+	})
+	return d, clk, &emitted
+}
+
+func msg(host, content string) format.LogParts {
+	return format.LogParts{"hostname": host, "content": content}
+}
+
+func (s *DedupSuite) TestCollapse(c *C) {
+	d, clk, emitted := newTest()
+	defer d.Stop()
+
+	c.Check(d.Process(msg("r1", "Interface Gi0/1 down")), NotNil)
+	clk.Advance(time.Second)
+	c.Check(d.Process(msg("r1", "Interface  Gi0/1 down ")), IsNil)
+	clk.Advance(time.Second)
+	c.Check(d.Process(msg("r1", "Interface Gi0/1 down")), IsNil)
+	// Other hosts and messages are not duplicates.
+	c.Check(d.Process(msg("r2", "Interface Gi0/1 down")), NotNil)
+	c.Check(d.Process(msg("r1", "Interface Gi0/2 down")), NotNil)
+
+	d.expire()
+	c.Check(*emitted, HasLen, 0)
+
+	clk.Advance(time.Hour)
+	d.expire()
+	c.Assert(*emitted, HasLen, 1)
+	summary := (*emitted)[0]
+	c.Check(summary["hostname"], Equals, "r1")
+	c.Check(summary["content"], Equals, "Interface Gi0/1 down")
+	c.Check(summary[RepeatCount], Equals, 2)
+	c.Check(summary[FirstSeen], Equals, time.Date(2022, 12, 12, 0, 0, 0, 0, time.UTC))
+	c.Check(summary[LastSeen], Equals, time.Date(2022, 12, 12, 0, 0, 2, 0, time.UTC))
+
+	// The window is closed so the message goes through again.
+	c.Check(d.Process(msg("r1", "Interface Gi0/1 down")), NotNil)
+}
+
+func (s *DedupSuite) TestMaxWindow(c *C) {
+	d, clk, emitted := newTest()
+	defer d.Stop()
+
+	d.Process(msg("r1", "flap"))
+	for i := 0; i < 5; i++ {
+		clk.Advance(59 * time.Minute)
+		c.Check(d.Process(msg("r1", "flap")), IsNil)
+		d.expire()
+	}
+	c.Assert(*emitted, HasLen, 1)
+	c.Check((*emitted)[0][RepeatCount], Equals, 5)
+}
+
+func (s *DedupSuite) TestEviction(c *C) {
+	d, _, emitted := newTest(WithMaxKeys(2))
+	defer d.Stop()
+
+	d.Process(msg("r1", "a"))
+	d.Process(msg("r1", "a"))
+	d.Process(msg("r1", "b"))
+	d.Process(msg("r1", "c"))
+	c.Assert(*emitted, HasLen, 1)
+	c.Check((*emitted)[0]["content"], Equals, "a")
+	c.Check(d.entries, HasLen, 2)
+}
+
+func (s *DedupSuite) TestStopFlushes(c *C) {
+	d, _, emitted := newTest(WithKeyFields(), WithMessageFields("message"))
+	d.Process(format.LogParts{"message": "x", "hostname": "r1"})
+	d.Process(format.LogParts{"message": "x", "hostname": "r2"})
+	c.Check(d.Process(format.LogParts{"content": "no message field"}), NotNil)
+	d.Stop()
+	c.Assert(*emitted, HasLen, 1)
+	c.Check((*emitted)[0][RepeatCount], Equals, 1)
+}
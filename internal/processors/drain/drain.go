@@ -0,0 +1,390 @@
+// Package drain learns message templates online using the Drain algorithm
+// (He et al., "Drain: An Online Log Parsing Approach with Fixed Depth Tree").
+// Variable parts such as numbers, addresses and interface names are masked,
+// messages are grouped by length and their leading tokens, and each message
+// joins the most similar template in its group or starts a new one.
+//
+// Every message is annotated with the ID and text of its template, and the
+// learned catalog can be served as JSON since Miner is an http.Handler.
+package drain
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// Fields added to every message.
+const (
+	TemplateID = "template_id"
+	Template   = "template"
+)
+
+const wildcard = "<*>"
+
+var (
+	interfaceName = regexp.MustCompile(`^(?:[A-Za-z][A-Za-z-]*\d+(?:/\d+)+(?:[.:]\d+)?|(?:Bundle-Ether|Loopback|Vlan|Port-channel|Tunnel|BVI|ae|irb|lo)\d+(?:\.\d+)?)$`)
+	number        = regexp.MustCompile(`^(?:[+-]?\d+(?:\.\d+)?|0x[0-9a-fA-F]+)$`)
+)
+
+// Entry is a learned template as exposed by the catalog.
+type Entry struct {
+	ID        string    `json:"id"`
+	Template  string    `json:"template"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+type cluster struct {
+	id     string
+	tokens []string
+	count  int
+	first  time.Time
+	last   time.Time
+	leaf   *node
+	elem   *list.Element
+}
+
+type node struct {
+	children map[string]*node
+	clusters []*cluster
+}
+
+type mask struct {
+	re   *regexp.Regexp
+	with string
+}
+
+// Miner is a Processor assigning a template to every message.
+type Miner struct {
+	mu          sync.Mutex
+	root        *node
+	lru         *list.List
+	count       int
+	now         func() time.Time
+	sources     []string
+	masks       []mask
+	depth       int
+	similarity  float64
+	maxChildren int
+	maxClusters int
+}
+
+type Option func(*Miner)
+
+// WithDepth sets the depth of the parse tree. Messages are grouped on their
+// first depth-2 tokens. Defaults to 4.
+func WithDepth(i int) Option {
+	return func(m *Miner) {
+		m.depth = i
+	}
+}
+
+// WithSimilarity sets the fraction of tokens that must match for a message to
+// join a template. Defaults to 0.4.
+func WithSimilarity(f float64) Option {
+	return func(m *Miner) {
+		m.similarity = f
+	}
+}
+
+// WithMaxChildren bounds the number of children of a tree node, tokens over
+// the limit share a wildcard branch. Defaults to 100.
+func WithMaxChildren(i int) Option {
+	return func(m *Miner) {
+		m.maxChildren = i
+	}
+}
+
+// WithMaxTemplates bounds the number of templates kept, the least recently
+// seen template is forgotten when full. Defaults to 10000.
+func WithMaxTemplates(i int) Option {
+	return func(m *Miner) {
+		m.maxClusters = i
+	}
+}
+
+// WithSource sets the fields the message is read from, the first one holding
+// a string is used. Defaults to content and message.
+func WithSource(fields ...string) Option {
+	return func(m *Miner) {
+		m.sources = fields
+	}
+}
+
+// WithMask replaces everything matching re with the given placeholder before
+// templates are mined, for site specific identifiers.
+func WithMask(re *regexp.Regexp, placeholder string) Option {
+	return func(m *Miner) {
+		m.masks = append(m.masks, mask{re: re, with: placeholder})
+	}
+}
+
+func New(opts ...Option) *Miner {
+	m := &Miner{
+		root:        &node{children: map[string]*node{}},
+		lru:         list.New(),
+		now:         time.Now,
+		sources:     []string{"content", "message"},
+		depth:       4,
+		similarity:  0.4,
+		maxChildren: 100,
+		maxClusters: 10000,
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	if m.depth < 3 {
+		m.depth = 3
+	}
+	return m
+}
+
+func (m *Miner) Process(parts format.LogParts) format.LogParts {
+	var msg string
+	for _, f := range m.sources {
+		if s, ok := parts[f].(string); ok {
+			msg = s
+			break
+		}
+	}
+	tokens := m.tokenize(msg)
+	if len(tokens) == 0 {
+		return parts
+	}
+
+	m.mu.Lock()
+	c := m.match(tokens)
+	id, tmpl := c.id, strings.Join(c.tokens, " ")
+	m.mu.Unlock()
+
+	parts[TemplateID] = id
+	parts[Template] = tmpl
+	return parts
+}
+
+// Templates returns the catalog sorted by count, most frequent first.
+func (m *Miner) Templates() []Entry {
+	m.mu.Lock()
+	entries := make([]Entry, 0, m.count)
+	for e := m.lru.Front(); e != nil; e = e.Next() {
+		c := e.Value.(*cluster)
+		entries = append(entries, Entry{
+			ID:        c.id,
+			Template:  strings.Join(c.tokens, " "),
+			Count:     c.count,
+			FirstSeen: c.first,
+			LastSeen:  c.last,
+		})
+	}
+	m.mu.Unlock()
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+// ServeHTTP serves the catalog as JSON.
+func (m *Miner) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(m.Templates()); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (m *Miner) tokenize(msg string) []string {
+	for _, mk := range m.masks {
+		msg = mk.re.ReplaceAllString(msg, mk.with)
+	}
+	tokens := strings.Fields(msg)
+	for i, t := range tokens {
+		tokens[i] = maskToken(t)
+	}
+	return tokens
+}
+
+// maskToken replaces a token holding a variable with a placeholder, keeping
+// surrounding punctuation so "10.1.1.1," becomes "<IP>,".
+func maskToken(t string) string {
+	core := strings.TrimLeft(t, "([{<'\"")
+	lead := t[:len(t)-len(core)]
+	trimmed := strings.TrimRight(core, ",;.)]}>'\"")
+	trail := core[len(trimmed):]
+	// Keep a trailing colon unless it belongs to an IPv6 address.
+	if strings.HasSuffix(trimmed, ":") && !strings.HasSuffix(trimmed, "::") {
+		trail = ":" + trail
+		trimmed = trimmed[:len(trimmed)-1]
+	}
+	if trimmed == "" {
+		return t
+	}
+
+	var placeholder string
+	switch {
+	case isIP(trimmed):
+		placeholder = "<IP>"
+	case isMAC(trimmed):
+		placeholder = "<MAC>"
+	case interfaceName.MatchString(trimmed):
+		placeholder = "<IF>"
+	case number.MatchString(trimmed):
+		placeholder = "<NUM>"
+	default:
+		return t
+	}
+	return lead + placeholder + trail
+}
+
+func isIP(s string) bool {
+	if net.ParseIP(s) != nil {
+		return true
+	}
+	if _, _, err := net.ParseCIDR(s); err == nil {
+		return true
+	}
+	if host, port, err := net.SplitHostPort(s); err == nil {
+		_, perr := strconv.Atoi(port)
+		return net.ParseIP(host) != nil && perr == nil
+	}
+	return false
+}
+
+func isMAC(s string) bool {
+	if len(s) < 14 {
+		return false
+	}
+	_, err := net.ParseMAC(s)
+	return err == nil
+}
+
+func hasDigit(s string) bool {
+	return strings.IndexAny(s, "0123456789") >= 0
+}
+
+// match finds or creates the cluster for tokens. Must be called with the lock
+// held.
+func (m *Miner) match(tokens []string) *cluster {
+	now := m.now()
+	leaf := m.leaf(tokens)
+
+	var best *cluster
+	bestSim, bestParams := -1.0, -1
+	for _, c := range leaf.clusters {
+		sim, params := similarity(c.tokens, tokens)
+		if sim > bestSim || (sim == bestSim && params > bestParams) {
+			best, bestSim, bestParams = c, sim, params
+		}
+	}
+
+	if best != nil && bestSim >= m.similarity {
+		for i, t := range tokens {
+			if best.tokens[i] != t {
+				best.tokens[i] = wildcard
+			}
+		}
+		best.count++
+		best.last = now
+		m.lru.MoveToBack(best.elem)
+		return best
+	}
+
+	if m.maxClusters > 0 && m.count >= m.maxClusters {
+		m.evict(m.lru.Front().Value.(*cluster))
+	}
+	c := &cluster{
+		id:     templateID(tokens),
+		tokens: append([]string(nil), tokens...),
+		count:  1,
+		first:  now,
+		last:   now,
+		leaf:   leaf,
+	}
+	c.elem = m.lru.PushBack(c)
+	leaf.clusters = append(leaf.clusters, c)
+	m.count++
+	return c
+}
+
+// leaf walks down the tree by length and leading tokens, creating nodes as
+// required.
+func (m *Miner) leaf(tokens []string) *node {
+	n := m.child(m.root, strconv.Itoa(len(tokens)), false)
+	for i := 0; i < m.depth-2 && i < len(tokens); i++ {
+		key := tokens[i]
+		if hasDigit(key) || strings.HasPrefix(key, "<") {
+			key = wildcard
+		}
+		n = m.child(n, key, true)
+	}
+	return n
+}
+
+func (m *Miner) child(n *node, key string, bounded bool) *node {
+	if c, ok := n.children[key]; ok {
+		return c
+	}
+	if bounded && len(n.children) >= m.maxChildren {
+		key = wildcard
+		if c, ok := n.children[key]; ok {
+			return c
+		}
+	}
+	c := &node{children: map[string]*node{}}
+	n.children[key] = c
+	return c
+}
+
+func (m *Miner) evict(c *cluster) {
+	m.lru.Remove(c.elem)
+	for i, o := range c.leaf.clusters {
+		if o == c {
+			c.leaf.clusters = append(c.leaf.clusters[:i], c.leaf.clusters[i+1:]...)
+			break
+		}
+	}
+	m.count--
+}
+
+// similarity is the fraction of positions where the template and the tokens
+// agree, wildcards not counting as a match, along with the number of
+// wildcards to break ties.
+func similarity(template, tokens []string) (float64, int) {
+	same, params := 0, 0
+	for i, t := range template {
+		if t == wildcard {
+			params++
+			continue
+		}
+		if t == tokens[i] {
+			same++
+		}
+	}
+	return float64(same) / float64(len(template)), params
+}
+
+// templateID derives the ID from the tokens the template was created with so
+// it stays the same as the template is generalised, and so that servers that
+// see the same first message agree on it.
+func templateID(tokens []string) string {
+	h := fnv.New64a()
+	for _, t := range tokens {
+		h.Write([]byte(t))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
@@ -0,0 +1,99 @@
+package drain
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type DrainSuite struct{}
+
+var _ = Suite(&DrainSuite{})
+
+func (s *DrainSuite) TestMaskToken(c *C) {
+	fixtures := map[string]string{
+		"10.1.1.1":                 "<IP>",
+		"(10.1.1.1:179),":          "(<IP>),",
+		"10.0.0.0/8":               "<IP>",
+		"2001:db8::1":              "<IP>",
+		"aa:bb:cc:dd:ee:ff":        "<MAC>",
+		"0011.2233.4455":           "<MAC>",
+		"GigabitEthernet0/0/0/1,":  "<IF>,",
+		"xe-0/0/1.100":             "<IF>",
+		"Bundle-Ether10":           "<IF>",
+		"1234:":                    "<NUM>:",
+		"0x1f":                     "<NUM>",
+		"-3.5":                     "<NUM>",
+		"state":                    "state",
+		"%PKT_INFRA-LINK-3-UPDOWN": "%PKT_INFRA-LINK-3-UPDOWN",
+	}
+	for in, out := range fixtures {
+		c.Check(maskToken(in), Equals, out, Commentf("token %s", in))
+	}
+}
+
+func (s *DrainSuite) TestMine(c *C) {
+	m := New()
+	msgs := []string{
+		"Interface GigabitEthernet0/0/0/1, changed state to Down",
+		"Interface GigabitEthernet0/0/0/2, changed state to Down",
+		"Interface TenGigE0/1/0/3, changed state to Up",
+		"Accepted password for alice from 10.0.0.1",
+		"Accepted password for bob from 10.0.0.2",
+	}
+	var got []format.LogParts
+	for _, msg := range msgs {
+		got = append(got, m.Process(format.LogParts{"content": msg}))
+	}
+
+	c.Check(got[0][Template], Equals, "Interface <IF>, changed state to Down")
+	c.Check(got[1][TemplateID], Equals, got[0][TemplateID])
+	c.Check(got[2][Template], Equals, "Interface <IF>, changed state to <*>")
+	c.Check(got[2][TemplateID], Equals, got[0][TemplateID])
+	c.Check(got[3][Template], Equals, "Accepted password for alice from <IP>")
+	c.Check(got[4][Template], Equals, "Accepted password for <*> from <IP>")
+	c.Check(got[4][TemplateID], Equals, got[3][TemplateID])
+	c.Check(got[4][TemplateID], Not(Equals), got[0][TemplateID])
+
+	entries := m.Templates()
+	c.Assert(entries, HasLen, 2)
+	c.Check(entries[0].Count, Equals, 3)
+	c.Check(entries[0].Template, Equals, "Interface <IF>, changed state to <*>")
+	c.Check(entries[1].Count, Equals, 2)
+}
+
+func (s *DrainSuite) TestDissimilar(c *C) {
+	m := New()
+	a := m.Process(format.LogParts{"message": "BGP neighbor 10.0.0.1 Down"})
+	b := m.Process(format.LogParts{"message": "OSPF adjacency lost on Gi0/1"})
+	c.Check(a[TemplateID], Not(Equals), b[TemplateID])
+	c.Check(m.Process(format.LogParts{"message": ""})[TemplateID], IsNil)
+}
+
+func (s *DrainSuite) TestCustomMaskAndMaxTemplates(c *C) {
+	m := New(WithMask(regexp.MustCompile(`ticket-[a-z]+`), "<TICKET>"), WithMaxTemplates(1))
+	c.Check(m.Process(format.LogParts{"content": "opened ticket-abc"})[Template], Equals, "opened <TICKET>")
+	m.Process(format.LogParts{"content": "something completely different here"})
+	entries := m.Templates()
+	c.Assert(entries, HasLen, 1)
+	c.Check(entries[0].Template, Equals, "something completely different here")
+}
+
+func (s *DrainSuite) TestServeHTTP(c *C) {
+	m := New()
+	m.Process(format.LogParts{"content": "hello world"})
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/templates", nil))
+	c.Check(rec.Header().Get("Content-Type"), Equals, "application/json")
+	var entries []Entry
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &entries), IsNil)
+	c.Assert(entries, HasLen, 1)
+	c.Check(entries[0].Template, Equals, "hello world")
+	c.Check(entries[0].Count, Equals, 1)
+}
@@ -0,0 +1,167 @@
+// Package extract contains processors that pull structured fields out of the
+// free form message of a syslog line. Plenty of devices (Fortinet, Palo Alto,
+// most firewalls really) send key=value pairs or a whole JSON document as the
+// message body, which is not very useful to a writer until it has been split
+// up into LogParts.
+package extract
+
+import (
+	"fmt"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// ConflictPolicy decides what happens when an extracted key already exists
+// in the LogParts.
+type ConflictPolicy int
+
+const (
+	// ConflictSkip keeps the existing value and drops the extracted one. This
+	// is the default so a message can never clobber what the parser produced.
+	ConflictSkip ConflictPolicy = iota
+	// ConflictOverwrite replaces the existing value with the extracted one.
+	ConflictOverwrite
+	// ConflictRename stores the extracted value under key_1, key_2, ... until
+	// a free key is found.
+	ConflictRename
+)
+
+// defaultSources are the fields the message body lives in for the formats we
+// ship. rfc3164 uses content, rfc5424 and CiscoXR use message.
+var defaultSources = []string{"content", "message"}
+
+type config struct {
+	sources  []string
+	prefix   string
+	maxKeys  int
+	conflict ConflictPolicy
+
+	// key=value specific settings.
+	pairSeparator  string
+	valueSeparator string
+	quotes         string
+	escape         bool
+
+	// JSON specific settings.
+	flatten   bool
+	flattenBy string
+}
+
+func newConfig(opts []Option) config {
+	c := config{
+		sources:        defaultSources,
+		pairSeparator:  " ",
+		valueSeparator: "=",
+		quotes:         `"'`,
+		flattenBy:      ".",
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+type Option func(*config)
+
+// WithSource sets the fields that are searched for the payload. The first one
+// holding a non empty string is used.
+func WithSource(fields ...string) Option {
+	return func(c *config) {
+		c.sources = fields
+	}
+}
+
+// WithPrefix is prepended to every extracted key, e.g. "fw." gives "fw.srcip".
+func WithPrefix(p string) Option {
+	return func(c *config) {
+		c.prefix = p
+	}
+}
+
+// WithMaxKeys caps the number of keys extracted from a single message. Zero
+// means no limit.
+func WithMaxKeys(i int) Option {
+	return func(c *config) {
+		c.maxKeys = i
+	}
+}
+
+// WithConflictPolicy sets what to do when an extracted key already exists.
+func WithConflictPolicy(p ConflictPolicy) Option {
+	return func(c *config) {
+		c.conflict = p
+	}
+}
+
+// WithPairSeparator sets what separates one pair from the next. Defaults to a
+// single space; runs of the separator are treated as one.
+func WithPairSeparator(s string) Option {
+	return func(c *config) {
+		c.pairSeparator = s
+	}
+}
+
+// WithValueSeparator sets what separates a key from its value. Defaults to "=".
+func WithValueSeparator(s string) Option {
+	return func(c *config) {
+		c.valueSeparator = s
+	}
+}
+
+// WithQuotes sets the characters that may be used to quote a value. Quoted
+// values may contain the pair separator. Pass "" to disable quoting.
+func WithQuotes(q string) Option {
+	return func(c *config) {
+		c.quotes = q
+	}
+}
+
+// WithBackslashEscape allows a backslash to escape the next character inside
+// a quoted value.
+func WithBackslashEscape() Option {
+	return func(c *config) {
+		c.escape = true
+	}
+}
+
+// WithFlatten turns nested JSON objects into dotted keys instead of nested
+// maps, e.g. {"src":{"ip":"1.1.1.1"}} becomes src.ip.
+func WithFlatten(separator string) Option {
+	return func(c *config) {
+		c.flatten = true
+		if separator != "" {
+			c.flattenBy = separator
+		}
+	}
+}
+
+// source returns the payload that should be extracted from.
+func (c *config) source(parts format.LogParts) (string, bool) {
+	for _, f := range c.sources {
+		if v, ok := parts[f].(string); ok && v != "" {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// set stores an extracted value honoring the prefix and conflict policy.
+func (c *config) set(parts format.LogParts, key string, value interface{}) {
+	key = c.prefix + key
+	if _, ok := parts[key]; !ok {
+		parts[key] = value
+		return
+	}
+	switch c.conflict {
+	case ConflictOverwrite:
+		parts[key] = value
+	case ConflictRename:
+		for i := 1; ; i++ {
+			k := fmt.Sprintf("%s_%d", key, i)
+			if _, ok := parts[k]; !ok {
+				parts[k] = value
+				return
+			}
+		}
+	}
+}
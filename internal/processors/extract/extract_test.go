@@ -0,0 +1,91 @@
+package extract
+
+import (
+	"testing"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type ExtractSuite struct{}
+
+var _ = Suite(&ExtractSuite{})
+
+var fortinet = `date=2022-12-12 time=00:19:57 devname="FG 100E" logid="0000000013" srcip=10.1.1.1 action=deny`
+
+func (s *ExtractSuite) TestKeyValue(c *C) {
+	parts := format.LogParts{"content": fortinet, "hostname": "fw1"}
+	NewKeyValue().Process(parts)
+	c.Check(parts["date"], Equals, "2022-12-12")
+	c.Check(parts["time"], Equals, "00:19:57")
+	c.Check(parts["devname"], Equals, "FG 100E")
+	c.Check(parts["logid"], Equals, "0000000013")
+	c.Check(parts["srcip"], Equals, "10.1.1.1")
+	c.Check(parts["action"], Equals, "deny")
+}
+
+func (s *ExtractSuite) TestKeyValueSeparatorsAndPrefix(c *C) {
+	parts := format.LogParts{"message": `src:10.0.0.1, dst:10.0.0.2, note:'a, b', junk`}
+	NewKeyValue(
+		WithPairSeparator(", "),
+		WithValueSeparator(":"),
+		WithPrefix("pa."),
+	).Process(parts)
+	c.Check(parts["pa.src"], Equals, "10.0.0.1")
+	c.Check(parts["pa.dst"], Equals, "10.0.0.2")
+	c.Check(parts["pa.note"], Equals, "a, b")
+	c.Check(parts["pa.junk"], IsNil)
+}
+
+func (s *ExtractSuite) TestKeyValueEscape(c *C) {
+	parts := format.LogParts{"content": `msg="say \"hi\"" user=bob`}
+	NewKeyValue(WithBackslashEscape()).Process(parts)
+	c.Check(parts["msg"], Equals, `say "hi"`)
+	c.Check(parts["user"], Equals, "bob")
+}
+
+func (s *ExtractSuite) TestKeyValueMaxKeys(c *C) {
+	parts := format.LogParts{"content": "a=1 b=2 c=3"}
+	NewKeyValue(WithMaxKeys(2)).Process(parts)
+	c.Check(parts["a"], Equals, "1")
+	c.Check(parts["b"], Equals, "2")
+	c.Check(parts["c"], IsNil)
+}
+
+func (s *ExtractSuite) TestConflictPolicies(c *C) {
+	parts := format.LogParts{"content": "hostname=evil", "hostname": "fw1"}
+	NewKeyValue().Process(parts)
+	c.Check(parts["hostname"], Equals, "fw1")
+
+	NewKeyValue(WithConflictPolicy(ConflictRename)).Process(parts)
+	c.Check(parts["hostname"], Equals, "fw1")
+	c.Check(parts["hostname_1"], Equals, "evil")
+
+	NewKeyValue(WithConflictPolicy(ConflictOverwrite)).Process(parts)
+	c.Check(parts["hostname"], Equals, "evil")
+}
+
+func (s *ExtractSuite) TestJSON(c *C) {
+	parts := format.LogParts{"message": `@cee: {"user":"bob","count":3,"ratio":0.5,"src":{"ip":"10.1.1.1","port":22}}`}
+	NewJSON().Process(parts)
+	c.Check(parts["user"], Equals, "bob")
+	c.Check(parts["count"], Equals, 3)
+	c.Check(parts["ratio"], Equals, 0.5)
+	c.Check(parts["src"], DeepEquals, map[string]interface{}{"ip": "10.1.1.1", "port": 22})
+}
+
+func (s *ExtractSuite) TestJSONFlatten(c *C) {
+	parts := format.LogParts{"content": `{"src":{"ip":"10.1.1.1","port":22},"action":"allow"}`}
+	NewJSON(WithFlatten(""), WithPrefix("j."), WithMaxKeys(2)).Process(parts)
+	c.Check(parts["j.action"], Equals, "allow")
+	c.Check(parts["j.src.ip"], Equals, "10.1.1.1")
+	c.Check(parts["j.src.port"], IsNil)
+}
+
+func (s *ExtractSuite) TestJSONInvalid(c *C) {
+	parts := format.LogParts{"content": `{"broken":`}
+	NewJSON().Process(parts)
+	c.Check(parts, HasLen, 1)
+}
@@ -0,0 +1,111 @@
+package extract
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// JSON extracts a JSON object embedded in the message. The object does not
+// have to make up the whole message, anything before the first '{' (such as
+// the "@cee:" cookie) is skipped.
+type JSON struct {
+	config
+}
+
+func NewJSON(opts ...Option) *JSON {
+	return &JSON{config: newConfig(opts)}
+}
+
+func (j *JSON) Process(parts format.LogParts) format.LogParts {
+	payload, ok := j.source(parts)
+	if !ok {
+		return parts
+	}
+	start := strings.IndexByte(payload, '{')
+	if start < 0 {
+		return parts
+	}
+	dec := json.NewDecoder(strings.NewReader(payload[start:]))
+	dec.UseNumber()
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		return parts
+	}
+
+	count := 0
+	add := func(key string, value interface{}) bool {
+		if j.maxKeys > 0 && count >= j.maxKeys {
+			return false
+		}
+		j.set(parts, key, value)
+		count++
+		return true
+	}
+	for _, key := range sortedKeys(m) {
+		value := normalizeJSON(m[key])
+		if nested, ok := value.(map[string]interface{}); ok && j.flatten {
+			if !j.flattenInto("", key, nested, add) {
+				break
+			}
+			continue
+		}
+		if !add(key, value) {
+			break
+		}
+	}
+	return parts
+}
+
+func (j *JSON) flattenInto(prefix, key string, m map[string]interface{}, add func(string, interface{}) bool) bool {
+	prefix = prefix + key + j.flattenBy
+	for _, k := range sortedKeys(m) {
+		v := m[k]
+		if nested, ok := v.(map[string]interface{}); ok {
+			if !j.flattenInto(prefix, k, nested, add) {
+				return false
+			}
+			continue
+		}
+		if !add(prefix+k, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortedKeys keeps extraction deterministic when WithMaxKeys cuts it short.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// normalizeJSON turns json.Number into int or float64 so extracted numbers
+// look like the numbers produced by the parsers.
+func normalizeJSON(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return int(i)
+		}
+		if f, err := t.Float64(); err == nil {
+			return f
+		}
+		return t.String()
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = normalizeJSON(e)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = normalizeJSON(e)
+		}
+	}
+	return v
+}
@@ -0,0 +1,103 @@
+package extract
+
+import (
+	"strings"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// KeyValue extracts key=value pairs from the message, for example
+//
+//	date=2022-12-12 devname="FG 100" srcip=10.1.1.1 action=deny
+//
+// Tokens that do not contain the value separator are ignored.
+type KeyValue struct {
+	config
+}
+
+func NewKeyValue(opts ...Option) *KeyValue {
+	return &KeyValue{config: newConfig(opts)}
+}
+
+func (k *KeyValue) Process(parts format.LogParts) format.LogParts {
+	payload, ok := k.source(parts)
+	if !ok {
+		return parts
+	}
+	count := 0
+	k.scan(payload, func(key, value string) bool {
+		k.set(parts, key, value)
+		count++
+		return k.maxKeys == 0 || count < k.maxKeys
+	})
+	return parts
+}
+
+// scan walks the payload calling fn for every pair found. Scanning stops when
+// fn returns false.
+func (k *KeyValue) scan(s string, fn func(key, value string) bool) {
+	ps, vs := k.pairSeparator, k.valueSeparator
+	i := 0
+	for i < len(s) {
+		// Skip any run of pair separators.
+		for ps != "" && strings.HasPrefix(s[i:], ps) {
+			i += len(ps)
+		}
+		if i >= len(s) {
+			return
+		}
+
+		// Find the end of the key. Hitting a pair separator first means the
+		// token was not a pair so we move on to the next one.
+		end := strings.Index(s[i:], vs)
+		if end < 0 {
+			return
+		}
+		if next := strings.Index(s[i:], ps); ps != "" && next >= 0 && next < end {
+			i += next
+			continue
+		}
+		key := strings.TrimSpace(s[i : i+end])
+		i += end + len(vs)
+
+		var value string
+		value, i = k.readValue(s, i)
+		if key == "" {
+			continue
+		}
+		if !fn(key, value) {
+			return
+		}
+	}
+}
+
+// readValue reads a possibly quoted value starting at i and returns it along
+// with the position just after it.
+func (k *KeyValue) readValue(s string, i int) (string, int) {
+	if i < len(s) && k.quotes != "" && strings.IndexByte(k.quotes, s[i]) >= 0 {
+		quote := s[i]
+		var b strings.Builder
+		for j := i + 1; j < len(s); j++ {
+			c := s[j]
+			if k.escape && c == '\\' && j+1 < len(s) {
+				j++
+				b.WriteByte(s[j])
+				continue
+			}
+			if c == quote {
+				return b.String(), j + 1
+			}
+			b.WriteByte(c)
+		}
+		// Unterminated quote, take the rest of the line as the value.
+		return b.String(), len(s)
+	}
+	if k.pairSeparator == "" {
+		return s[i:], len(s)
+	}
+	end := strings.Index(s[i:], k.pairSeparator)
+	if end < 0 {
+		return s[i:], len(s)
+	}
+	return s[i : i+end], i + end
+}
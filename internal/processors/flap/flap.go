@@ -0,0 +1,384 @@
+// Package flap tracks the up/down state of interfaces and BGP neighbors per
+// device from their state change messages. Objects changing state too often
+// within a window are declared flapping, and stabilized again once they calm
+// down, with separate thresholds so they do not bounce between the two.
+package flap
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/metajar/metalogger/internal/metrics/prometheus"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// Kinds of tracked objects.
+const (
+	Interface = "interface"
+	BGP       = "bgp"
+)
+
+// Fields set on flapping and stabilized events.
+const (
+	Kind   = "flap_kind"
+	Object = "flap_object"
+	State  = "flap_state"
+	Count  = "flap_count"
+)
+
+// Event states.
+const (
+	Flapping   = "flapping"
+	Stabilized = "stabilized"
+)
+
+// Pattern recognises a state change message. The regexp must have the named
+// groups name, the interface or neighbor, and state. States of up or
+// established count as up, anything else as down.
+type Pattern struct {
+	Kind   string
+	Regexp *regexp.Regexp
+}
+
+// DefaultPatterns cover IOS, IOS-XR and Junos.
+var DefaultPatterns = []Pattern{
+	{Interface, regexp.MustCompile(`Interface (?P<name>\S+?), changed state to (?P<state>\w+)`)},
+	{Interface, regexp.MustCompile(`SNMP_TRAP_LINK_(?P<state>UP|DOWN):.*ifName (?P<name>\S+)`)},
+	{BGP, regexp.MustCompile(`[Nn]eighbor (?P<name>[0-9a-fA-F.:]+)(?: vpn vrf \S+)? (?P<state>Up|Down)`)},
+	{BGP, regexp.MustCompile(`BGP_STATE_CHANGED:.*peer (?P<name>[0-9a-fA-F.:]+).*changed state from \w+ to (?P<state>\w+)`)},
+}
+
+type object struct {
+	device      string
+	kind        string
+	name        string
+	up          bool
+	since       time.Time
+	transitions []time.Time
+	flapping    bool
+	elem        *list.Element
+}
+
+// Status is the state of a tracked object as served over HTTP.
+type Status struct {
+	Device   string    `json:"device"`
+	Kind     string    `json:"kind"`
+	Name     string    `json:"name"`
+	Up       bool      `json:"up"`
+	Since    time.Time `json:"since"`
+	Flaps    int       `json:"flaps"`
+	Flapping bool      `json:"flapping"`
+}
+
+// Tracker is a Processor, Emitter and http.Handler.
+type Tracker struct {
+	mu         sync.Mutex
+	objects    map[string]*object
+	lru        *list.List
+	emit       func(format.LogParts)
+	now        func() time.Time
+	done       chan struct{}
+	stopOnce   sync.Once
+	patterns   []Pattern
+	sources    []string
+	window     time.Duration
+	flapAt     int
+	stableAt   int
+	maxObjects int
+}
+
+type Option func(*Tracker)
+
+// WithPatterns replaces the default patterns.
+func WithPatterns(p ...Pattern) Option {
+	return func(t *Tracker) {
+		t.patterns = p
+	}
+}
+
+// WithWindow sets the window state changes are counted over. Defaults to 5
+// minutes.
+func WithWindow(w time.Duration) Option {
+	return func(t *Tracker) {
+		t.window = w
+	}
+}
+
+// WithThresholds sets the number of state changes within the window at which
+// an object becomes flapping, and at or below which it is stabilized again.
+// Defaults to 5 and 1.
+func WithThresholds(flapping, stabilized int) Option {
+	return func(t *Tracker) {
+		t.flapAt = flapping
+		t.stableAt = stabilized
+	}
+}
+
+// WithMaxObjects bounds the number of tracked objects, the least recently
+// changed one is forgotten when full. Defaults to 100000.
+func WithMaxObjects(i int) Option {
+	return func(t *Tracker) {
+		t.maxObjects = i
+	}
+}
+
+// WithClock replaces time.Now for the transition windows, hold downs and
+// States. The sweep started by New still ticks on wall time, it reads the
+// clock to decide what went quiet.
+func WithClock(now func() time.Time) Option {
+	return func(t *Tracker) {
+		t.now = now
+	}
+}
+
+func New(opts ...Option) *Tracker {
+	t := &Tracker{
+		objects:    make(map[string]*object),
+		lru:        list.New(),
+		now:        time.Now,
+		done:       make(chan struct{}),
+		patterns:   DefaultPatterns,
+		sources:    []string{"content", "message"},
+		window:     5 * time.Minute,
+		flapAt:     5,
+		stableAt:   1,
+		maxObjects: 100000,
+	}
+	for _, opt := range opts {
+		opt(t)
+	}
+	interval := t.window / 10
+	if interval < time.Second {
+		interval = time.Second
+	}
+	go t.sweep(interval)
+	return t
+}
+
+func (t *Tracker) SetEmit(emit func(format.LogParts)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.emit = emit
+}
+
+// Stop stops the background check for stabilized objects.
+func (t *Tracker) Stop() {
+	t.stopOnce.Do(func() {
+		close(t.done)
+	})
+}
+
+func (t *Tracker) Process(parts format.LogParts) format.LogParts {
+	var msg string
+	for _, f := range t.sources {
+		if s, ok := parts[f].(string); ok {
+			msg = s
+			break
+		}
+	}
+	device, _ := parts["hostname"].(string)
+	if msg == "" || device == "" {
+		return parts
+	}
+	for _, p := range t.patterns {
+		m := p.Regexp.FindStringSubmatch(msg)
+		if m == nil {
+			continue
+		}
+		var name, state string
+		for i, n := range p.Regexp.SubexpNames() {
+			switch n {
+			case "name":
+				name = m[i]
+			case "state":
+				state = strings.ToLower(m[i])
+			}
+		}
+		up := state == "up" || state == "established"
+		t.record(device, p.Kind, name, up)
+		break
+	}
+	return parts
+}
+
+func (t *Tracker) record(device, kind, name string, up bool) {
+	now := t.now()
+	key := device + "\x00" + kind + "\x00" + name
+
+	t.mu.Lock()
+	o, ok := t.objects[key]
+	if !ok {
+		if t.maxObjects > 0 && len(t.objects) >= t.maxObjects {
+			t.forget(t.lru.Front().Value.(*object))
+		}
+		o = &object{device: device, kind: kind, name: name, up: up, since: now}
+		o.elem = t.lru.PushBack(o)
+		t.objects[key] = o
+		// exported from the start, not only once it first flaps
+		prometheus.ObjectFlapping.WithLabelValues(device, kind, name).Set(0)
+	} else if o.up != up {
+		o.up = up
+		o.since = now
+		o.transitions = append(o.transitions, now)
+		t.lru.MoveToBack(o.elem)
+	}
+	e := t.evaluate(o, now)
+	emit := t.emit
+	t.mu.Unlock()
+
+	if e != nil && emit != nil {
+		emit(e)
+	}
+}
+
+// evaluate trims old transitions, updates the metrics and returns an event if
+// the object started or stopped flapping. Must be called with the lock held.
+func (t *Tracker) evaluate(o *object, now time.Time) format.LogParts {
+	i := 0
+	for i < len(o.transitions) && now.Sub(o.transitions[i]) > t.window {
+		i++
+	}
+	o.transitions = o.transitions[i:]
+	flaps := len(o.transitions)
+
+	labels := []string{o.device, o.kind, o.name}
+	up := 0.0
+	if o.up {
+		up = 1
+	}
+	prometheus.ObjectUp.WithLabelValues(labels...).Set(up)
+	prometheus.ObjectFlaps.WithLabelValues(labels...).Set(float64(flaps))
+
+	var state string
+	switch {
+	case !o.flapping && flaps >= t.flapAt:
+		o.flapping = true
+		state = Flapping
+	case o.flapping && flaps <= t.stableAt:
+		o.flapping = false
+		state = Stabilized
+	default:
+		return nil
+	}
+	flapping := 0.0
+	if o.flapping {
+		flapping = 1
+	}
+	prometheus.ObjectFlapping.WithLabelValues(labels...).Set(flapping)
+
+	severity := 4
+	if state == Stabilized {
+		severity = 5
+	}
+	return format.LogParts{
+		"timestamp": now,
+		"hostname":  o.device,
+		"severity":  severity,
+		"content":   fmt.Sprintf("%s %s on %s is %s (%d state changes in %s)", o.kind, o.name, o.device, state, flaps, t.window),
+		Kind:        o.kind,
+		Object:      o.name,
+		State:       state,
+		Count:       flaps,
+	}
+}
+
+func (t *Tracker) forget(o *object) {
+	t.lru.Remove(o.elem)
+	delete(t.objects, o.device+"\x00"+o.kind+"\x00"+o.name)
+	labels := []string{o.device, o.kind, o.name}
+	prometheus.ObjectUp.DeleteLabelValues(labels...)
+	prometheus.ObjectFlaps.DeleteLabelValues(labels...)
+	prometheus.ObjectFlapping.DeleteLabelValues(labels...)
+}
+
+func (t *Tracker) sweep(interval time.Duration) {
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-tick.C:
+			t.check()
+		}
+	}
+}
+
+// check re-evaluates objects with transitions so flapping objects that went
+// quiet are stabilized.
+func (t *Tracker) check() {
+	now := t.now()
+	var out []format.LogParts
+	t.mu.Lock()
+	for _, o := range t.objects {
+		if len(o.transitions) == 0 && !o.flapping {
+			continue
+		}
+		if e := t.evaluate(o, now); e != nil {
+			out = append(out, e)
+		}
+	}
+	emit := t.emit
+	t.mu.Unlock()
+
+	if emit == nil {
+		return
+	}
+	for _, e := range out {
+		emit(e)
+	}
+}
+
+// States returns the tracked objects, optionally only those of one device.
+func (t *Tracker) States(device string) []Status {
+	now := t.now()
+	t.mu.Lock()
+	states := make([]Status, 0, len(t.objects))
+	for _, o := range t.objects {
+		if device != "" && o.device != device {
+			continue
+		}
+		flaps := 0
+		for _, ts := range o.transitions {
+			if now.Sub(ts) <= t.window {
+				flaps++
+			}
+		}
+		states = append(states, Status{
+			Device:   o.device,
+			Kind:     o.kind,
+			Name:     o.name,
+			Up:       o.up,
+			Since:    o.since,
+			Flaps:    flaps,
+			Flapping: o.flapping,
+		})
+	}
+	t.mu.Unlock()
+	sort.Slice(states, func(i, j int) bool {
+		a, b := states[i], states[j]
+		if a.Device != b.Device {
+			return a.Device < b.Device
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return states
+}
+
+// ServeHTTP serves the tracked state as JSON, ?device= limits it to one device.
+func (t *Tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(t.States(r.URL.Query().Get("device"))); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
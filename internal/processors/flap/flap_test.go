@@ -0,0 +1,125 @@
+package flap
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/metajar/metalogger/internal/fakeclock"
+	"github.com/metajar/metalogger/internal/metrics/prometheus"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type FlapSuite struct{}
+
+var _ = Suite(&FlapSuite{})
+
+func newTest(opts ...Option) (*Tracker, *fakeclock.Clock, *[]format.LogParts) {
+	clk := fakeclock.New()
+	t := New(append(opts, WithClock(clk.Now))...)
+	t.Stop()
+	var emitted []format.LogParts
+	t.SetEmit(func(parts format.LogParts) {
+		emitted = append(emitted, parts)
+	})
+	return t, clk, &emitted
+}
+
+func link(state string) format.LogParts {
+	return format.LogParts{"hostname": "r1", "content": "%PKT_INFRA-LINK-3-UPDOWN : Interface GigabitEthernet0/0/0/1, changed state to " + state}
+}
+
+func (s *FlapSuite) TestPatterns(c *C) {
+	t, _, _ := newTest()
+	msgs := []format.LogParts{
+		{"hostname": "r1", "content": "%LINEPROTO-5-UPDOWN: Line protocol on Interface Gi0/1, changed state to down"},
+		{"hostname": "r1", "message": "%ROUTING-BGP-5-ADJCHANGE : neighbor 10.0.0.1 Up (VRF: default)"},
+		{"hostname": "j1", "content": "mib2d[1234]: SNMP_TRAP_LINK_DOWN: ifIndex 520, ifAdminStatus up(1), ifOperStatus down(2), ifName ge-0/0/1"},
+		{"hostname": "j1", "content": "rpd[99]: BGP_STATE_CHANGED: BGP peer 2001:db8::1 (External AS 65001) changed state from OpenConfirm to Established (event RecvKeepAlive)"},
+		{"hostname": "r1", "content": "nothing to see here"},
+	}
+	for _, m := range msgs {
+		c.Check(t.Process(m), DeepEquals, m)
+	}
+	states := t.States("")
+	c.Assert(states, HasLen, 4)
+	c.Check(states[0], DeepEquals, Status{Device: "j1", Kind: BGP, Name: "2001:db8::1", Up: true, Since: t.now()})
+	c.Check(states[1].Name, Equals, "ge-0/0/1")
+	c.Check(states[1].Up, Equals, false)
+	c.Check(states[2].Kind, Equals, BGP)
+	c.Check(states[2].Name, Equals, "10.0.0.1")
+	c.Check(states[3].Name, Equals, "Gi0/1")
+	c.Check(t.States("r1"), HasLen, 2)
+}
+
+func (s *FlapSuite) TestFlappingAndStabilized(c *C) {
+	t, clk, emitted := newTest(WithWindow(time.Minute), WithThresholds(3, 1))
+	t.Process(link("Up"))
+	for _, state := range []string{"Down", "Up"} {
+		clk.Advance(time.Second)
+		t.Process(link(state))
+	}
+	// A repeated state is not a transition.
+	t.Process(link("Up"))
+	c.Check(*emitted, HasLen, 0)
+
+	clk.Advance(time.Second)
+	t.Process(link("Down"))
+	c.Assert(*emitted, HasLen, 1)
+	e := (*emitted)[0]
+	c.Check(e[State], Equals, Flapping)
+	c.Check(e[Kind], Equals, Interface)
+	c.Check(e[Object], Equals, "GigabitEthernet0/0/0/1")
+	c.Check(e[Count], Equals, 3)
+	c.Check(e["hostname"], Equals, "r1")
+	c.Check(e["severity"], Equals, 4)
+
+	// Still above the stabilized threshold, no bouncing back.
+	clk.Advance(58 * time.Second)
+	t.check()
+	c.Check(*emitted, HasLen, 1)
+	c.Check(t.States("")[0].Flapping, Equals, true)
+
+	clk.Advance(2 * time.Second)
+	t.check()
+	c.Assert(*emitted, HasLen, 2)
+	c.Check((*emitted)[1][State], Equals, Stabilized)
+	c.Check((*emitted)[1]["severity"], Equals, 5)
+	c.Check(t.States("")[0].Flapping, Equals, false)
+}
+
+func (s *FlapSuite) TestFlappingGaugeStartsAtZero(c *C) {
+	t, _, _ := newTest()
+	before := testutil.CollectAndCount(prometheus.ObjectFlapping)
+	t.Process(format.LogParts{"hostname": "gauge1", "content": "%BGP-5-ADJCHANGE: neighbor 10.0.0.9 Up"})
+	c.Check(testutil.CollectAndCount(prometheus.ObjectFlapping), Equals, before+1)
+	c.Check(testutil.ToFloat64(prometheus.ObjectFlapping.WithLabelValues("gauge1", BGP, "10.0.0.9")), Equals, 0.0)
+}
+
+func (s *FlapSuite) TestMaxObjects(c *C) {
+	t, _, _ := newTest(WithMaxObjects(1))
+	t.Process(link("Up"))
+	t.Process(format.LogParts{"hostname": "r2", "content": "%BGP-5-ADJCHANGE: neighbor 10.0.0.2 Down"})
+	states := t.States("")
+	c.Assert(states, HasLen, 1)
+	c.Check(states[0].Device, Equals, "r2")
+}
+
+func (s *FlapSuite) TestServeHTTP(c *C) {
+	t, _, _ := newTest()
+	t.Process(link("Down"))
+	t.Process(format.LogParts{"hostname": "r2", "content": "%BGP-5-ADJCHANGE: neighbor 10.0.0.2 Up"})
+	rec := httptest.NewRecorder()
+	t.ServeHTTP(rec, httptest.NewRequest("GET", "/flaps?device=r2", nil))
+	c.Check(rec.Header().Get("Content-Type"), Equals, "application/json")
+	var states []Status
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &states), IsNil)
+	c.Assert(states, HasLen, 1)
+	c.Check(states[0].Name, Equals, "10.0.0.2")
+	c.Check(states[0].Up, Equals, true)
+}
@@ -0,0 +1,240 @@
+// Package mutate provides a declarative Processor for reshaping LogParts. The
+// parsers all produce slightly different schemas (rfc3164 content vs rfc5424
+// message, CiscoXR severity as a string) and this saves writing a one-off
+// Processor every time fields need to line up.
+package mutate
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/metajar/metalogger/internal/rules"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// Type is the target type of a Convert operation.
+type Type int
+
+const (
+	String Type = iota
+	Int
+	Float
+	Bool
+)
+
+type operation func(parts format.LogParts)
+
+// Mutate applies its operations in the order the options were given.
+type Mutate struct {
+	ops []operation
+}
+
+type Option func(*Mutate)
+
+func New(opts ...Option) *Mutate {
+	m := &Mutate{}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+func (m *Mutate) Process(parts format.LogParts) format.LogParts {
+	for _, op := range m.ops {
+		op(parts)
+	}
+	return parts
+}
+
+func (m *Mutate) add(op operation) {
+	m.ops = append(m.ops, op)
+}
+
+// Rename moves from to to. Nothing happens if from is not set.
+func Rename(from, to string) Option {
+	return func(m *Mutate) {
+		m.add(func(parts format.LogParts) {
+			if v, ok := parts[from]; ok {
+				delete(parts, from)
+				parts[to] = v
+			}
+		})
+	}
+}
+
+// Remove drops the given keys.
+func Remove(keys ...string) Option {
+	return func(m *Mutate) {
+		m.add(func(parts format.LogParts) {
+			for _, k := range keys {
+				delete(parts, k)
+			}
+		})
+	}
+}
+
+// Set sets key to a constant value.
+func Set(key string, value interface{}) Option {
+	return func(m *Mutate) {
+		m.add(func(parts format.LogParts) {
+			parts[key] = value
+		})
+	}
+}
+
+// Copy copies from to to, leaving from in place.
+func Copy(from, to string) Option {
+	return func(m *Mutate) {
+		m.add(func(parts format.LogParts) {
+			if v, ok := parts[from]; ok {
+				parts[to] = v
+			}
+		})
+	}
+}
+
+// Convert coerces key to the given type. Values that can not be converted are
+// left untouched.
+func Convert(key string, t Type) Option {
+	return func(m *Mutate) {
+		m.add(func(parts format.LogParts) {
+			v, ok := parts[key]
+			if !ok {
+				return
+			}
+			if c, err := convert(v, t); err == nil {
+				parts[key] = c
+			}
+		})
+	}
+}
+
+// ParseTime parses a string field into a time.Time trying each layout in
+// turn. Layouts without zone information are interpreted as UTC.
+func ParseTime(key string, layouts ...string) Option {
+	return func(m *Mutate) {
+		m.add(func(parts format.LogParts) {
+			s, ok := parts[key].(string)
+			if !ok {
+				return
+			}
+			for _, l := range layouts {
+				if ts, err := time.Parse(l, strings.TrimSpace(s)); err == nil {
+					parts[key] = ts
+					return
+				}
+			}
+		})
+	}
+}
+
+// Lowercase lowercases string fields.
+func Lowercase(keys ...string) Option {
+	return stringOp(keys, strings.ToLower)
+}
+
+// Uppercase uppercases string fields.
+func Uppercase(keys ...string) Option {
+	return stringOp(keys, strings.ToUpper)
+}
+
+func stringOp(keys []string, fn func(string) string) Option {
+	return func(m *Mutate) {
+		m.add(func(parts format.LogParts) {
+			for _, k := range keys {
+				if s, ok := parts[k].(string); ok {
+					parts[k] = fn(s)
+				}
+			}
+		})
+	}
+}
+
+// Flatten replaces a nested map field with dotted keys for writers that need
+// flat records, e.g. Flatten("sd_elements", ".") turns the rfc5424 structured
+// data into sd_elements.origin.ip and friends, each holding the list of the
+// param's values. Any map keyed by strings is supported, nested maps are
+// flattened recursively.
+func Flatten(key, separator string) Option {
+	return func(m *Mutate) {
+		m.add(func(parts format.LogParts) {
+			v := reflect.ValueOf(parts[key])
+			if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+				return
+			}
+			delete(parts, key)
+			flatten(parts, key, separator, v)
+		})
+	}
+}
+
+func flatten(parts format.LogParts, prefix, separator string, v reflect.Value) {
+	iter := v.MapRange()
+	for iter.Next() {
+		k := prefix + separator + iter.Key().String()
+		e := iter.Value()
+		if e.Kind() == reflect.Interface {
+			e = e.Elem()
+		}
+		if e.Kind() == reflect.Map && e.Type().Key().Kind() == reflect.String {
+			flatten(parts, k, separator, e)
+			continue
+		}
+		if e.IsValid() {
+			parts[k] = e.Interface()
+		} else {
+			parts[k] = nil
+		}
+	}
+}
+
+// Template builds a new string field from existing ones using the same
+// %{field} syntax as grok, e.g. Template("source", "%{hostname}/%{tag}").
+// Missing fields render as an empty string.
+func Template(key, tmpl string) Option {
+	return func(m *Mutate) {
+		m.add(func(parts format.LogParts) {
+			parts[key] = rules.Expand(tmpl, parts, nil)
+		})
+	}
+}
+
+func convert(v interface{}, t Type) (interface{}, error) {
+	switch t {
+	case String:
+		return fmt.Sprint(v), nil
+	case Int:
+		switch n := v.(type) {
+		case int:
+			return n, nil
+		case int64:
+			return int(n), nil
+		case float64:
+			return int(n), nil
+		case string:
+			return strconv.Atoi(strings.TrimSpace(n))
+		}
+	case Float:
+		switch n := v.(type) {
+		case float64:
+			return n, nil
+		case int:
+			return float64(n), nil
+		case int64:
+			return float64(n), nil
+		case string:
+			return strconv.ParseFloat(strings.TrimSpace(n), 64)
+		}
+	case Bool:
+		switch n := v.(type) {
+		case bool:
+			return n, nil
+		case string:
+			return strconv.ParseBool(strings.TrimSpace(n))
+		}
+	}
+	return nil, fmt.Errorf("can not convert %T", v)
+}
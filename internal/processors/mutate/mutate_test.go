@@ -0,0 +1,86 @@
+package mutate
+
+import (
+	"testing"
+	"time"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	"github.com/metajar/metalogger/internal/syslogger/syslogparser/rfc5424"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type MutateSuite struct{}
+
+var _ = Suite(&MutateSuite{})
+
+func (s *MutateSuite) TestUnifyFields(c *C) {
+	m := New(
+		Rename("content", "message"),
+		Copy("hostname", "host"),
+		Remove("tls_peer"),
+		Set("env", "prod"),
+		Lowercase("host"),
+	)
+	parts := m.Process(format.LogParts{"content": "hi", "hostname": "RTR1", "tls_peer": ""})
+	c.Check(parts, DeepEquals, format.LogParts{
+		"message":  "hi",
+		"hostname": "RTR1",
+		"host":     "rtr1",
+		"env":      "prod",
+	})
+}
+
+func (s *MutateSuite) TestConvert(c *C) {
+	m := New(
+		Convert("severity", Int),
+		Convert("ratio", Float),
+		Convert("ok", Bool),
+		Convert("priority", String),
+		Convert("broken", Int),
+	)
+	parts := m.Process(format.LogParts{"severity": "3", "ratio": "0.5", "ok": "true", "priority": 14, "broken": "x"})
+	c.Check(parts["severity"], Equals, 3)
+	c.Check(parts["ratio"], Equals, 0.5)
+	c.Check(parts["ok"], Equals, true)
+	c.Check(parts["priority"], Equals, "14")
+	c.Check(parts["broken"], Equals, "x")
+}
+
+func (s *MutateSuite) TestParseTime(c *C) {
+	m := New(ParseTime("log_date", time.RFC3339, "Jan 2 2006 15:04:05.000 MST"))
+	parts := m.Process(format.LogParts{"log_date": "Dec 12 2022 00:19:57.123 UTC"})
+	ts, ok := parts["log_date"].(time.Time)
+	c.Assert(ok, Equals, true)
+	c.Check(ts.Equal(time.Date(2022, 12, 12, 0, 19, 57, 123000000, time.UTC)), Equals, true)
+}
+
+func (s *MutateSuite) TestTemplateAndOrder(c *C) {
+	m := New(
+		Template("source", "%{hostname}/%{tag}%{missing}"),
+		Uppercase("source"),
+	)
+	parts := m.Process(format.LogParts{"hostname": "rtr1", "tag": "bgp"})
+	c.Check(parts["source"], Equals, "RTR1/BGP")
+}
+
+func (s *MutateSuite) TestFlatten(c *C) {
+	m := New(
+		Flatten("sd_elements", "."),
+		Flatten("json", "_"),
+	)
+	parts := m.Process(format.LogParts{
+		"sd_elements": rfc5424.StructuredData{
+			"origin": {"ip": {"192.0.2.1", "198.51.100.1"}},
+			"meta":   {"sequenceId": {"1"}},
+		},
+		"json": map[string]interface{}{"a": map[string]interface{}{"b": 1}, "c": nil},
+	})
+	c.Check(parts, DeepEquals, format.LogParts{
+		"sd_elements.origin.ip":       []string{"192.0.2.1", "198.51.100.1"},
+		"sd_elements.meta.sequenceId": []string{"1"},
+		"json_a_b":                    1,
+		"json_c":                      nil,
+	})
+}
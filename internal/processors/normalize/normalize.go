@@ -0,0 +1,253 @@
+// Package normalize maps the LogParts produced by the different formats onto
+// one schema so that writers and other processors can rely on field names and
+// types no matter which Format the server was configured with.
+//
+// The schema follows the Elastic Common Schema naming where a field exists:
+//
+//	@timestamp                  time.Time  event time as reported by the device
+//	message                     string     the free form message
+//	host.hostname               string     hostname from the header
+//	source.address              string     address the message was received from
+//	source.port                 int        port the message was received from
+//	tls.client.subject          string     TLS peer name if received over TLS
+//	log.syslog.format           string     rfc3164, rfc5424 or ciscoxr
+//	log.syslog.priority         int
+//	log.syslog.facility.code    int
+//	log.syslog.facility.name    string     kern, user, ..., local7
+//	log.syslog.severity.code    int
+//	log.syslog.severity.name    string     emergency, ..., debug
+//	log.syslog.version          int        rfc5424 only
+//	log.syslog.appname          string     rfc5424 APP-NAME, rfc3164 tag, CiscoXR process
+//	log.syslog.procid           string     rfc5424 PROCID, CiscoXR pid
+//	log.syslog.msgid            string     rfc5424 MSGID, CiscoXR mnemonic
+//	log.syslog.structured_data  rfc5424.StructuredData, SD-ID -> param -> values
+//	event.sequence              int        CiscoXR sequence number
+//	cisco.category              string     CiscoXR category, e.g. PKT_INFRA
+//	cisco.group                 string     CiscoXR group, e.g. LINK
+//	cisco.log_date              string     CiscoXR timestamp as sent
+//
+// Fields that are not recognised, such as the ones added by other processors,
+// are passed through untouched.
+package normalize
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+const (
+	Timestamp      = "@timestamp"
+	Message        = "message"
+	Hostname       = "host.hostname"
+	SourceAddress  = "source.address"
+	SourcePort     = "source.port"
+	TLSSubject     = "tls.client.subject"
+	Format         = "log.syslog.format"
+	Priority       = "log.syslog.priority"
+	FacilityCode   = "log.syslog.facility.code"
+	FacilityName   = "log.syslog.facility.name"
+	SeverityCode   = "log.syslog.severity.code"
+	SeverityName   = "log.syslog.severity.name"
+	Version        = "log.syslog.version"
+	AppName        = "log.syslog.appname"
+	ProcID         = "log.syslog.procid"
+	MsgID          = "log.syslog.msgid"
+	StructuredData = "log.syslog.structured_data"
+	Sequence       = "event.sequence"
+	CiscoCategory  = "cisco.category"
+	CiscoGroup     = "cisco.group"
+	CiscoLogDate   = "cisco.log_date"
+)
+
+const (
+	FormatRFC3164 = "rfc3164"
+	FormatRFC5424 = "rfc5424"
+	FormatCiscoXR = "ciscoxr"
+)
+
+var severityNames = []string{"emergency", "alert", "critical", "error", "warning", "notice", "informational", "debug"}
+
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+// fieldMaps maps the keys of each format onto the schema.
+var fieldMaps = map[string]map[string]string{
+	FormatRFC3164: {
+		"timestamp": Timestamp,
+		"hostname":  Hostname,
+		"tag":       AppName,
+		"content":   Message,
+		"priority":  Priority,
+		"facility":  FacilityCode,
+		"severity":  SeverityCode,
+	},
+	FormatRFC5424: {
+		"timestamp":   Timestamp,
+		"hostname":    Hostname,
+		"app_name":    AppName,
+		"proc_id":     ProcID,
+		"msg_id":      MsgID,
+		"message":     Message,
+		"priority":    Priority,
+		"facility":    FacilityCode,
+		"severity":    SeverityCode,
+		"version":     Version,
+		"sd_elements": StructuredData,
+	},
+	FormatCiscoXR: {
+		"timestamp": Timestamp,
+		"hostname":  Hostname,
+		"process":   AppName,
+		"pid":       ProcID,
+		"mnemonic":  MsgID,
+		"message":   Message,
+		"priority":  Priority,
+		"severity":  SeverityCode,
+		"sequence":  Sequence,
+		"category":  CiscoCategory,
+		"group":     CiscoGroup,
+		"log_date":  CiscoLogDate,
+	},
+}
+
+// dropFields are superseded by a schema field and removed unless the original
+// fields are kept.
+var dropFields = map[string][]string{
+	FormatRFC5424: {"structured_data"},
+}
+
+// intFields are coerced to int, CiscoXR hands everything over as strings.
+var intFields = []string{Priority, FacilityCode, SeverityCode, Version, Sequence, SourcePort}
+
+// Normalizer is a Processor rewriting LogParts into the schema above.
+type Normalizer struct {
+	keepOriginal bool
+}
+
+type Option func(*Normalizer)
+
+// WithKeepOriginal keeps the original parser fields next to the normalized
+// ones, handy while migrating writers over.
+func WithKeepOriginal() Option {
+	return func(n *Normalizer) {
+		n.keepOriginal = true
+	}
+}
+
+func New(opts ...Option) *Normalizer {
+	n := &Normalizer{}
+	for _, opt := range opts {
+		opt(n)
+	}
+	return n
+}
+
+func (n *Normalizer) Process(parts format.LogParts) format.LogParts {
+	f := Detect(parts)
+	if f == "" {
+		return parts
+	}
+	for from, to := range fieldMaps[f] {
+		v, ok := parts[from]
+		if !ok {
+			continue
+		}
+		if !n.keepOriginal {
+			delete(parts, from)
+		}
+		parts[to] = v
+	}
+	if !n.keepOriginal {
+		for _, k := range dropFields[f] {
+			delete(parts, k)
+		}
+	}
+	n.source(parts)
+	parts[Format] = f
+
+	for _, k := range intFields {
+		if v, ok := parts[k]; ok {
+			if i, ok := toInt(v); ok {
+				parts[k] = i
+			} else {
+				delete(parts, k)
+			}
+		}
+	}
+
+	// CiscoXR does not send a facility so work it out from the priority.
+	if _, ok := parts[FacilityCode]; !ok {
+		if p, ok := parts[Priority].(int); ok {
+			parts[FacilityCode] = p / 8
+		}
+	}
+	if i, ok := parts[FacilityCode].(int); ok && i >= 0 && i < len(facilityNames) {
+		parts[FacilityName] = facilityNames[i]
+	}
+	if i, ok := parts[SeverityCode].(int); ok && i >= 0 && i < len(severityNames) {
+		parts[SeverityName] = severityNames[i]
+	}
+	if ts, ok := parts[Timestamp].(time.Time); ok && ts.IsZero() {
+		delete(parts, Timestamp)
+	}
+	return parts
+}
+
+func (n *Normalizer) source(parts format.LogParts) {
+	if client, ok := parts["client"].(string); ok {
+		if !n.keepOriginal {
+			delete(parts, "client")
+		}
+		if host, port, err := net.SplitHostPort(client); err == nil {
+			parts[SourceAddress] = host
+			parts[SourcePort] = port
+		} else if client != "" {
+			parts[SourceAddress] = client
+		}
+	}
+	if peer, ok := parts["tls_peer"].(string); ok {
+		if !n.keepOriginal {
+			delete(parts, "tls_peer")
+		}
+		if peer != "" {
+			parts[TLSSubject] = peer
+		}
+	}
+}
+
+// Detect works out which format produced the LogParts from the keys present.
+// It returns an empty string when the parts do not look like any of them, for
+// example because they were already normalized.
+func Detect(parts format.LogParts) string {
+	if _, ok := parts["mnemonic"]; ok {
+		return FormatCiscoXR
+	}
+	if _, ok := parts["app_name"]; ok {
+		return FormatRFC5424
+	}
+	if _, ok := parts["content"]; ok {
+		return FormatRFC3164
+	}
+	return ""
+}
+
+func toInt(v interface{}) (int, bool) {
+	switch t := v.(type) {
+	case int:
+		return t, true
+	case int64:
+		return int(t), true
+	case float64:
+		return int(t), true
+	case string:
+		i, err := strconv.Atoi(t)
+		return i, err == nil
+	}
+	return 0, false
+}
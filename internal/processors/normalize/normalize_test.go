@@ -0,0 +1,90 @@
+package normalize
+
+import (
+	"testing"
+	"time"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	"github.com/metajar/metalogger/internal/syslogger/syslogparser/rfc5424"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type NormalizeSuite struct{}
+
+var _ = Suite(&NormalizeSuite{})
+
+func parse(c *C, f format.Format, line string) format.LogParts {
+	p := f.GetParser([]byte(line))
+	c.Assert(p.Parse(), IsNil)
+	parts := p.Dump()
+	parts["client"] = "10.0.0.1:514"
+	parts["tls_peer"] = ""
+	return parts
+}
+
+func (s *NormalizeSuite) TestRFC3164(c *C) {
+	parts := New().Process(parse(c, &format.RFC3164{}, `<13>May  1 20:51:40 myhostname myprogram[42]: ciao`))
+	c.Check(parts[Format], Equals, FormatRFC3164)
+	c.Check(parts[Message], Equals, "ciao")
+	c.Check(parts[Hostname], Equals, "myhostname")
+	c.Check(parts[AppName], Equals, "myprogram")
+	c.Check(parts[Priority], Equals, 13)
+	c.Check(parts[FacilityCode], Equals, 1)
+	c.Check(parts[FacilityName], Equals, "user")
+	c.Check(parts[SeverityCode], Equals, 5)
+	c.Check(parts[SeverityName], Equals, "notice")
+	c.Check(parts[SourceAddress], Equals, "10.0.0.1")
+	c.Check(parts[SourcePort], Equals, 514)
+	c.Check(parts[Timestamp], FitsTypeOf, time.Time{})
+	for _, k := range []string{"content", "tag", "client", "tls_peer", "hostname"} {
+		c.Check(parts[k], IsNil, Commentf("key %s", k))
+	}
+}
+
+func (s *NormalizeSuite) TestRFC5424(c *C) {
+	parts := New().Process(parse(c, &format.RFC5424{}, `<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3"] An application event`))
+	c.Check(parts[Format], Equals, FormatRFC5424)
+	c.Check(parts[Message], Equals, "An application event")
+	c.Check(parts[Hostname], Equals, "mymachine.example.com")
+	c.Check(parts[AppName], Equals, "evntslog")
+	c.Check(parts[ProcID], Equals, "-")
+	c.Check(parts[MsgID], Equals, "ID47")
+	c.Check(parts[Version], Equals, 1)
+	c.Check(parts[FacilityName], Equals, "local4")
+	c.Check(parts[SeverityName], Equals, "notice")
+	c.Check(parts[StructuredData], DeepEquals, rfc5424.StructuredData{"exampleSDID@32473": {"iut": {"3"}}})
+	c.Check(parts["structured_data"], IsNil)
+	c.Check(parts["sd_elements"], IsNil)
+}
+
+func (s *NormalizeSuite) TestCiscoXR(c *C) {
+	parts := New().Process(parse(c, &format.CiscoXR{}, `<187>1234: RP/0/RSP0/CPU0:Dec 12 00:19:57.123 UTC: ifmgr[123]: %PKT_INFRA-LINK-3-UPDOWN : Interface GigabitEthernet0/0/0/1, changed state to Down`))
+	c.Check(parts[Format], Equals, FormatCiscoXR)
+	c.Check(parts[Priority], Equals, 187)
+	c.Check(parts[FacilityCode], Equals, 23)
+	c.Check(parts[FacilityName], Equals, "local7")
+	c.Check(parts[SeverityCode], Equals, 3)
+	c.Check(parts[SeverityName], Equals, "error")
+	c.Check(parts[Sequence], Equals, 1234)
+	c.Check(parts[AppName], Equals, "ifmgr")
+	c.Check(parts[ProcID], Equals, "123")
+	c.Check(parts[MsgID], Equals, "UPDOWN")
+	c.Check(parts[CiscoCategory], Equals, "PKT_INFRA")
+	c.Check(parts[CiscoGroup], Equals, "LINK")
+	c.Check(parts[Message], Equals, "Interface GigabitEthernet0/0/0/1, changed state to Down")
+}
+
+func (s *NormalizeSuite) TestKeepOriginalAndPassthrough(c *C) {
+	parts := parse(c, &format.RFC3164{}, `<13>May  1 20:51:40 myhostname myprogram: ciao`)
+	parts["extra"] = "x"
+	New(WithKeepOriginal()).Process(parts)
+	c.Check(parts["content"], Equals, "ciao")
+	c.Check(parts[Message], Equals, "ciao")
+	c.Check(parts["extra"], Equals, "x")
+
+	// Already normalized parts are left alone.
+	again := New().Process(format.LogParts{Message: "x"})
+	c.Check(again, DeepEquals, format.LogParts{Message: "x"})
+}
@@ -0,0 +1,320 @@
+// Package redact masks sensitive data such as addresses, email addresses, card
+// numbers and credentials in message fields before they leave metalogger.
+// Every detector match is masked, replaced by a keyed HMAC so the same value
+// can still be joined across messages, or truncated.
+package redact
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/metajar/metalogger/internal/metrics/prometheus"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// Action decides what a match is replaced with.
+type Action int
+
+const (
+	// Mask replaces the match with the mask, [REDACTED] by default.
+	Mask Action = iota
+	// Hash replaces the match with hmac: and the first 16 hex characters of
+	// its HMAC-SHA256.
+	Hash
+	// Truncate keeps the detector specific prefix of the match, the network
+	// for addresses, the OUI for MACs and the first 4 characters otherwise.
+	Truncate
+)
+
+// Detector finds sensitive data. If the regexp has a group named value only
+// that group is redacted, so "password=hunter2" becomes "password=[REDACTED]".
+type Detector struct {
+	Name   string
+	Regexp *regexp.Regexp
+	// Trim optionally shortens a match the regexp ran past, before it is
+	// validated.
+	Trim func(string) string
+	// Valid optionally rejects false positives.
+	Valid func(string) bool
+	// Truncate optionally overrides the default truncation.
+	Truncate func(string) string
+}
+
+// Built-in detectors.
+var (
+	IPv4 = Detector{
+		Name:     "ipv4",
+		Regexp:   regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`),
+		Valid:    func(s string) bool { return net.ParseIP(s) != nil },
+		Truncate: func(s string) string { return net.ParseIP(s).Mask(net.CIDRMask(24, 32)).String() },
+	}
+	IPv6 = Detector{
+		Name:   "ipv6",
+		Regexp: regexp.MustCompile(`[0-9A-Fa-f]{0,4}(?::[0-9A-Fa-f]{0,4}){2,7}(?:\d{1,3}(?:\.\d{1,3}){3})?`),
+		Trim:   trimIPv6,
+		Valid: func(s string) bool {
+			ip := net.ParseIP(s)
+			return ip != nil && strings.Contains(s, ":")
+		},
+		Truncate: func(s string) string { return net.ParseIP(s).Mask(net.CIDRMask(48, 128)).String() },
+	}
+	MAC = Detector{
+		Name:   "mac",
+		Regexp: regexp.MustCompile(`\b(?:[0-9A-Fa-f]{2}[:-]){5}[0-9A-Fa-f]{2}\b|\b(?:[0-9A-Fa-f]{4}\.){2}[0-9A-Fa-f]{4}\b`),
+		Truncate: func(s string) string {
+			hw, err := net.ParseMAC(s)
+			if err != nil {
+				return truncate(s)
+			}
+			return hw[:3].String()[:8] + ":00:00:00"
+		},
+	}
+	Email = Detector{
+		Name:   "email",
+		Regexp: regexp.MustCompile(`\b[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}\b`),
+		Truncate: func(s string) string {
+			return "***" + s[strings.IndexByte(s, '@'):]
+		},
+	}
+	Card = Detector{
+		Name:   "card",
+		Regexp: regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		Valid:  luhn,
+	}
+	// Secret matches key=value and key: value pairs for password like keys.
+	Secret = Detector{
+		Name:   "secret",
+		Regexp: regexp.MustCompile(`(?i)\b(?:password|passwd|pwd|secret|community|pre-shared-key|auth-key|api[_-]?key|token)\s*[=:]\s*(?P<value>"[^"]*"|\S+)`),
+	}
+	// SecretCLI matches credentials in Cisco style configuration lines such
+	// as "enable secret 5 ..." and "snmp-server community public RO".
+	SecretCLI = Detector{
+		Name:   "secret",
+		Regexp: regexp.MustCompile(`(?i)\b(?:(?:password|secret|key-string|key)\s+\d\s+|snmp-server community\s+)(?P<value>\S+)`),
+	}
+)
+
+// DefaultDetectors are used unless WithDetectors is given. Credentials come
+// first so their values are not picked apart by the other detectors.
+var DefaultDetectors = []Detector{Secret, SecretCLI, Email, MAC, IPv4, IPv6, Card}
+
+// Redactor is a Processor.
+type Redactor struct {
+	detectors []Detector
+	fields    []string
+	actions   map[string]Action
+	action    Action
+	mask      string
+	key       []byte
+}
+
+type Option func(*Redactor)
+
+// WithDetectors replaces the default detectors. Earlier detectors win when
+// matches overlap.
+func WithDetectors(d ...Detector) Option {
+	return func(r *Redactor) {
+		r.detectors = d
+	}
+}
+
+// WithCustom adds a detector for a custom regexp after the configured ones.
+func WithCustom(name string, re *regexp.Regexp) Option {
+	return func(r *Redactor) {
+		r.detectors = append(r.detectors, Detector{Name: name, Regexp: re})
+	}
+}
+
+// WithFields sets the fields to redact. Defaults to content and message.
+func WithFields(f ...string) Option {
+	return func(r *Redactor) {
+		r.fields = f
+	}
+}
+
+// WithAction sets the action for fields without their own. Defaults to Mask.
+func WithAction(a Action) Option {
+	return func(r *Redactor) {
+		r.action = a
+	}
+}
+
+// WithFieldAction sets the action for a single field.
+func WithFieldAction(field string, a Action) Option {
+	return func(r *Redactor) {
+		r.actions[field] = a
+	}
+}
+
+// WithMask sets the replacement used by Mask.
+func WithMask(m string) Option {
+	return func(r *Redactor) {
+		r.mask = m
+	}
+}
+
+// WithHMACKey sets the key used by Hash. Without one a random key is
+// generated, so hashes only join up within a single run.
+func WithHMACKey(key []byte) Option {
+	return func(r *Redactor) {
+		r.key = key
+	}
+}
+
+func New(opts ...Option) *Redactor {
+	r := &Redactor{
+		detectors: DefaultDetectors,
+		fields:    []string{"content", "message"},
+		actions:   map[string]Action{},
+		mask:      "[REDACTED]",
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.key == nil {
+		r.key = make([]byte, 32)
+		rand.Read(r.key)
+	}
+	return r
+}
+
+func (r *Redactor) Process(parts format.LogParts) format.LogParts {
+	for _, f := range r.fields {
+		s, ok := parts[f].(string)
+		if !ok || s == "" {
+			continue
+		}
+		action, ok := r.actions[f]
+		if !ok {
+			action = r.action
+		}
+		parts[f] = r.Redact(f, s, action)
+	}
+	return parts
+}
+
+type span struct {
+	start, end int
+	detector   *Detector
+}
+
+// Redact applies the detectors to s, counting redactions under field.
+func (r *Redactor) Redact(field, s string, action Action) string {
+	var spans []span
+	for i := range r.detectors {
+		d := &r.detectors[i]
+		value := d.Regexp.SubexpIndex("value")
+		for _, m := range d.Regexp.FindAllStringSubmatchIndex(s, -1) {
+			start, end := m[0], m[1]
+			if value > 0 {
+				if m[2*value] < 0 {
+					continue
+				}
+				start, end = m[2*value], m[2*value+1]
+			}
+			if d.Trim != nil {
+				end = start + len(d.Trim(s[start:end]))
+			}
+			if start == end {
+				continue
+			}
+			if d.Valid != nil && !d.Valid(s[start:end]) {
+				continue
+			}
+			if overlaps(spans, start, end) {
+				continue
+			}
+			spans = append(spans, span{start, end, d})
+		}
+	}
+	if len(spans) == 0 {
+		return s
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	var b strings.Builder
+	last := 0
+	for _, sp := range spans {
+		b.WriteString(s[last:sp.start])
+		b.WriteString(r.replace(sp.detector, s[sp.start:sp.end], action))
+		last = sp.end
+		prometheus.Redactions.WithLabelValues(sp.detector.Name, field).Inc()
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+func (r *Redactor) replace(d *Detector, v string, action Action) string {
+	switch action {
+	case Hash:
+		h := hmac.New(sha256.New, r.key)
+		h.Write([]byte(v))
+		return "hmac:" + hex.EncodeToString(h.Sum(nil))[:16]
+	case Truncate:
+		if d.Truncate != nil {
+			return d.Truncate(v)
+		}
+		return truncate(v)
+	default:
+		return r.mask
+	}
+}
+
+// trimIPv6 drops the trailing colons and dots the regexp picks up in text
+// such as "neighbor 2001:db8::1: Down", keeping the longest address.
+func trimIPv6(s string) string {
+	for t := s; t != "" && net.ParseIP(t) == nil; {
+		last := t[len(t)-1]
+		if last != ':' && last != '.' {
+			break
+		}
+		t = t[:len(t)-1]
+		if net.ParseIP(t) != nil {
+			return t
+		}
+	}
+	return s
+}
+
+func truncate(s string) string {
+	if len(s) <= 4 {
+		return strings.Repeat("*", len(s))
+	}
+	return s[:4] + strings.Repeat("*", len(s)-4)
+}
+
+func overlaps(spans []span, start, end int) bool {
+	for _, sp := range spans {
+		if start < sp.end && sp.start < end {
+			return true
+		}
+	}
+	return false
+}
+
+// luhn reports whether the digits in s pass the Luhn checksum.
+func luhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && sum%10 == 0
+}
@@ -0,0 +1,77 @@
+package redact
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type RedactSuite struct{}
+
+var _ = Suite(&RedactSuite{})
+
+func (s *RedactSuite) TestMask(c *C) {
+	r := New()
+	fixtures := map[string]string{
+		"login from 10.1.2.3 port 22":                      "login from [REDACTED] port 22",
+		"neighbor 2001:db8::1 Down":                        "neighbor [REDACTED] Down",
+		"BGP neighbor 2001:db8::1: Down":                   "BGP neighbor [REDACTED]: Down",
+		"peer fe80::1:":                                    "peer [REDACTED]:",
+		"route via 2001:db8::.":                            "route via [REDACTED].",
+		"neighbor 2001:db8::1. Down":                       "neighbor [REDACTED]. Down",
+		"host aa:bb:cc:dd:ee:ff moved from 0011.2233.4455": "host [REDACTED] moved from [REDACTED]",
+		"mail for alice@example.com bounced":               "mail for [REDACTED] bounced",
+		"card 4111 1111 1111 1111 declined":                "card [REDACTED] declined",
+		"order 4111111111111112 shipped":                   "order 4111111111111112 shipped",
+		"user=bob password=hunter2 ok":                     "user=bob password=[REDACTED] ok",
+		`api_key: "a b c" rejected`:                        "api_key: [REDACTED] rejected",
+		"enable secret 5 $1$abcd$xyz":                      "enable secret 5 [REDACTED]",
+		"snmp-server community public RO":                  "snmp-server community [REDACTED] RO",
+		"Failed password for alice":                        "Failed password for alice",
+		"uptime 12:34:56":                                  "uptime 12:34:56",
+	}
+	for in, out := range fixtures {
+		c.Check(r.Redact("content", in, Mask), Equals, out, Commentf("input %s", in))
+	}
+}
+
+func (s *RedactSuite) TestProcessPerFieldActions(c *C) {
+	r := New(
+		WithFields("content", "src"),
+		WithFieldAction("src", Truncate),
+		WithHMACKey([]byte("k")),
+		WithAction(Hash),
+		WithCustom("ticket", regexp.MustCompile(`CASE-\d+`)),
+	)
+	parts := r.Process(format.LogParts{
+		"content":  "from 10.1.2.3 about CASE-42",
+		"src":      "10.1.2.3 aa:bb:cc:dd:ee:ff 2001:db8:1:2::5 bob@example.com",
+		"hostname": "10.9.9.9",
+		"severity": 5,
+	})
+	c.Check(parts["content"], Equals, "from hmac:4c56f914d39eda04 about hmac:0405a62fa588b6de")
+	c.Check(parts["src"], Equals, "10.1.2.0 aa:bb:cc:00:00:00 2001:db8:1:: ***@example.com")
+	c.Check(parts["hostname"], Equals, "10.9.9.9")
+	c.Check(parts["severity"], Equals, 5)
+
+	// Hashes are stable for joining across messages.
+	again := r.Process(format.LogParts{"content": "10.1.2.3"})
+	c.Check(again["content"], Equals, "hmac:4c56f914d39eda04")
+}
+
+func (s *RedactSuite) TestDetectorsAndMask(c *C) {
+	r := New(WithDetectors(Email), WithMask("<email>"))
+	c.Check(r.Redact("content", "alice@example.com from 10.1.2.3", Mask), Equals, "<email> from 10.1.2.3")
+	c.Check(r.Redact("content", "abcdefgh", Truncate), Equals, "abcdefgh")
+	c.Check(truncate("abcdefgh"), Equals, "abcd****")
+}
+
+func (s *RedactSuite) TestLuhn(c *C) {
+	c.Check(luhn("4111-1111-1111-1111"), Equals, true)
+	c.Check(luhn("4111111111111112"), Equals, false)
+	c.Check(luhn("0"), Equals, false)
+}
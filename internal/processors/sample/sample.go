@@ -0,0 +1,166 @@
+// Package sample thins out high volume, low value messages. Each message gets
+// a rate of 1 in N from the first rule that applies to it, and kept messages
+// carry that rate in sample_rate so counts can be weighted back up downstream.
+package sample
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/metajar/metalogger/internal/metrics/prometheus"
+	"github.com/metajar/metalogger/internal/processors/normalize"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// Rate is set on kept messages that were sampled at a rate above 1.
+const Rate = "sample_rate"
+
+type matchRule struct {
+	field string
+	re    *regexp.Regexp
+	rate  int
+}
+
+// Sampler is a Processor. Messages it samples out are dropped.
+type Sampler struct {
+	mu         sync.Mutex
+	rand       *rand.Rand
+	matches    []matchRule
+	severities map[int]int
+	facilities map[int]int
+	rate       int
+	hashFields []string
+}
+
+type Option func(*Sampler)
+
+// WithMatchRate keeps 1 in rate messages whose field matches re. Match rules
+// are checked in the order given and take precedence over severity and
+// facility rates.
+func WithMatchRate(field string, re *regexp.Regexp, rate int) Option {
+	return func(s *Sampler) {
+		s.matches = append(s.matches, matchRule{field, re, rate})
+	}
+}
+
+// WithSeverityRate keeps 1 in rate messages of the severity. Takes precedence
+// over facility rates.
+func WithSeverityRate(severity, rate int) Option {
+	return func(s *Sampler) {
+		s.severities[severity] = rate
+	}
+}
+
+// WithFacilityRate keeps 1 in rate messages of the facility.
+func WithFacilityRate(facility, rate int) Option {
+	return func(s *Sampler) {
+		s.facilities[facility] = rate
+	}
+}
+
+// WithDefaultRate sets the rate of messages no rule applies to. Defaults to 1,
+// keeping everything.
+func WithDefaultRate(rate int) Option {
+	return func(s *Sampler) {
+		s.rate = rate
+	}
+}
+
+// WithHashFields samples deterministically on a hash of the fields, so all
+// messages with the same values are either kept or dropped together, e.g. by
+// session or flow ID. Messages are sampled randomly otherwise.
+func WithHashFields(fields ...string) Option {
+	return func(s *Sampler) {
+		s.hashFields = fields
+	}
+}
+
+// WithSeed seeds the random sampling, for reproducible runs.
+func WithSeed(seed int64) Option {
+	return func(s *Sampler) {
+		s.rand = rand.New(rand.NewSource(seed))
+	}
+}
+
+func New(opts ...Option) *Sampler {
+	s := &Sampler{
+		rand:       rand.New(rand.NewSource(rand.Int63())),
+		severities: map[int]int{},
+		facilities: map[int]int{},
+		rate:       1,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+func (s *Sampler) Process(parts format.LogParts) format.LogParts {
+	rate := s.rateFor(parts)
+	if rate <= 1 {
+		return parts
+	}
+	if !s.keep(parts, rate) {
+		prometheus.MessagesSampled.Inc()
+		return nil
+	}
+	parts[Rate] = rate
+	return parts
+}
+
+func (s *Sampler) rateFor(parts format.LogParts) int {
+	for _, m := range s.matches {
+		if v, ok := parts[m.field]; ok && v != nil && m.re.MatchString(fmt.Sprint(v)) {
+			return m.rate
+		}
+	}
+	if sev, ok := intField(parts, "severity", normalize.SeverityCode); ok {
+		if rate, ok := s.severities[sev]; ok {
+			return rate
+		}
+	}
+	if fac, ok := intField(parts, "facility", normalize.FacilityCode); ok {
+		if rate, ok := s.facilities[fac]; ok {
+			return rate
+		}
+	}
+	return s.rate
+}
+
+func (s *Sampler) keep(parts format.LogParts, rate int) bool {
+	if len(s.hashFields) == 0 {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.rand.Intn(rate) == 0
+	}
+	values := make([]string, len(s.hashFields))
+	for i, f := range s.hashFields {
+		if v, ok := parts[f]; ok && v != nil {
+			values[i] = fmt.Sprint(v)
+		}
+	}
+	h := fnv.New64a()
+	h.Write([]byte(strings.Join(values, "\x00")))
+	return h.Sum64()%uint64(rate) == 0
+}
+
+// intField returns the first of the fields holding an integer, as an int or
+// a numeric string.
+func intField(parts format.LogParts, fields ...string) (int, bool) {
+	for _, f := range fields {
+		switch v := parts[f].(type) {
+		case int:
+			return v, true
+		case string:
+			if i, err := strconv.Atoi(v); err == nil {
+				return i, true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,63 @@
+package sample
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type SampleSuite struct{}
+
+var _ = Suite(&SampleSuite{})
+
+func (s *SampleSuite) TestRates(c *C) {
+	sm := New(
+		WithMatchRate("content", regexp.MustCompile(`^%LINK`), 1),
+		WithSeverityRate(7, 100),
+		WithFacilityRate(23, 10),
+		WithDefaultRate(1),
+	)
+	c.Check(sm.rateFor(format.LogParts{"severity": 7, "facility": 23}), Equals, 100)
+	c.Check(sm.rateFor(format.LogParts{"log.syslog.severity.code": 7}), Equals, 100)
+	c.Check(sm.rateFor(format.LogParts{"severity": "7"}), Equals, 100)
+	c.Check(sm.rateFor(format.LogParts{"severity": 6, "facility": 23}), Equals, 10)
+	c.Check(sm.rateFor(format.LogParts{"severity": 7, "content": "%LINK-3-UPDOWN"}), Equals, 1)
+	c.Check(sm.rateFor(format.LogParts{"severity": 3}), Equals, 1)
+
+	msg := format.LogParts{"severity": 3}
+	c.Check(sm.Process(msg), DeepEquals, format.LogParts{"severity": 3})
+}
+
+func (s *SampleSuite) TestProbabilistic(c *C) {
+	sm := New(WithSeverityRate(7, 10), WithSeed(1))
+	kept := 0
+	for i := 0; i < 10000; i++ {
+		if p := sm.Process(format.LogParts{"severity": 7}); p != nil {
+			c.Assert(p[Rate], Equals, 10)
+			kept++
+		}
+	}
+	c.Check(kept > 800 && kept < 1200, Equals, true, Commentf("kept %d", kept))
+}
+
+func (s *SampleSuite) TestDeterministic(c *C) {
+	sm := New(WithDefaultRate(4), WithHashFields("session"))
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		session := fmt.Sprintf("s%d", i)
+		first := sm.Process(format.LogParts{"session": session}) != nil
+		// Every message of a session shares the decision.
+		for j := 0; j < 3; j++ {
+			c.Assert(sm.Process(format.LogParts{"session": session}) != nil, Equals, first)
+		}
+		if first {
+			kept++
+		}
+	}
+	c.Check(kept > 150 && kept < 350, Equals, true, Commentf("kept %d", kept))
+}
@@ -0,0 +1,305 @@
+// Package ratelimit implements per key token bucket rate limiting. A Limiter
+// can be set on the syslog.Server to limit sources before their messages are
+// parsed, or wrapped in a Processor to limit on any LogParts field.
+package ratelimit
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/metajar/metalogger/internal/metrics/prometheus"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// Policy decides what happens to messages once a key ran out of tokens.
+type Policy int
+
+const (
+	// Drop drops every message over the limit.
+	Drop Policy = iota
+	// Sample lets one in every SampleRate messages over the limit through.
+	Sample
+)
+
+// Fields set on summary events and sampled messages.
+const (
+	Key        = "rate_limit_key"
+	Suppressed = "rate_limit_suppressed"
+	SampleRate = "rate_limit_sample_rate"
+)
+
+// otherKey is used once a cardinality cap has been hit.
+const otherKey = "other"
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+	excess int
+	elem   *list.Element
+}
+
+type Limiter struct {
+	mu         sync.Mutex
+	buckets    map[string]*bucket
+	lru        *list.List
+	suppressed map[string]int
+	metricKeys map[string]struct{}
+	emit       func(format.LogParts)
+	now        func() time.Time
+	done       chan struct{}
+	stopOnce   sync.Once
+
+	name           string
+	rate           float64
+	burst          int
+	policy         Policy
+	sampleRate     int
+	maxKeys        int
+	maxMetricKeys  int
+	summaryEvery   time.Duration
+	summaryStarted bool
+}
+
+type Option func(*Limiter)
+
+// WithName is used as the limiter label on the metrics.
+func WithName(n string) Option {
+	return func(l *Limiter) {
+		l.name = n
+	}
+}
+
+// WithRate sets the sustained rate in messages per second. Defaults to 100.
+func WithRate(perSecond float64) Option {
+	return func(l *Limiter) {
+		l.rate = perSecond
+	}
+}
+
+// WithBurst sets the bucket size. Defaults to the rate.
+func WithBurst(i int) Option {
+	return func(l *Limiter) {
+		l.burst = i
+	}
+}
+
+// WithSampling lets one in every n messages over the limit through instead of
+// dropping all of them.
+func WithSampling(n int) Option {
+	return func(l *Limiter) {
+		l.policy = Sample
+		l.sampleRate = n
+	}
+}
+
+// WithMaxKeys bounds the number of buckets kept. The least recently used
+// bucket is forgotten when full. Defaults to 100000.
+func WithMaxKeys(i int) Option {
+	return func(l *Limiter) {
+		l.maxKeys = i
+	}
+}
+
+// WithMaxMetricKeys caps the number of distinct key labels exported to
+// Prometheus, any further key is counted as "other". Defaults to 100.
+func WithMaxMetricKeys(i int) Option {
+	return func(l *Limiter) {
+		l.maxMetricKeys = i
+	}
+}
+
+// WithSummary emits a "N messages suppressed from X" event per limited key
+// at the given interval. Summaries need an emit function, see SetEmit.
+func WithSummary(every time.Duration) Option {
+	return func(l *Limiter) {
+		l.summaryEvery = every
+	}
+}
+
+// WithClock replaces time.Now for the token buckets and the timestamp of
+// summaries. The summary loop started by SetEmit still ticks on wall time.
+func WithClock(now func() time.Time) Option {
+	return func(l *Limiter) {
+		l.now = now
+	}
+}
+
+func New(opts ...Option) *Limiter {
+	l := &Limiter{
+		buckets:       make(map[string]*bucket),
+		lru:           list.New(),
+		suppressed:    make(map[string]int),
+		metricKeys:    make(map[string]struct{}),
+		now:           time.Now,
+		done:          make(chan struct{}),
+		name:          "default",
+		rate:          100,
+		maxKeys:       100000,
+		maxMetricKeys: 100,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	if l.burst <= 0 {
+		l.burst = int(l.rate)
+		if l.burst < 1 {
+			l.burst = 1
+		}
+	}
+	if l.sampleRate <= 0 {
+		l.sampleRate = 1
+	}
+	return l
+}
+
+// SetEmit sets where summary events are sent and starts the summary loop if
+// WithSummary was given.
+func (l *Limiter) SetEmit(emit func(format.LogParts)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.emit = emit
+	if l.summaryEvery > 0 && !l.summaryStarted {
+		l.summaryStarted = true
+		go l.summaryLoop()
+	}
+}
+
+// Stop stops the summary loop.
+func (l *Limiter) Stop() {
+	l.stopOnce.Do(func() {
+		close(l.done)
+	})
+}
+
+// Allow reports whether a message for key may go through.
+func (l *Limiter) Allow(key string) bool {
+	ok, _ := l.take(key)
+	return ok
+}
+
+// take returns whether the message may go through and whether it only did so
+// because it was sampled.
+func (l *Limiter) take(key string) (allowed bool, sampled bool) {
+	now := l.now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		if l.maxKeys > 0 && len(l.buckets) >= l.maxKeys {
+			oldest := l.lru.Front().Value.(*bucket)
+			l.lru.Remove(oldest.elem)
+			delete(l.buckets, oldest.key)
+		}
+		b = &bucket{key: key, tokens: float64(l.burst), last: now}
+		b.elem = l.lru.PushBack(b)
+		l.buckets[key] = b
+	} else {
+		l.lru.MoveToBack(b.elem)
+		b.tokens += now.Sub(b.last).Seconds() * l.rate
+		if b.tokens > float64(l.burst) {
+			b.tokens = float64(l.burst)
+		}
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.excess = 0
+		return true, false
+	}
+
+	b.excess++
+	if l.policy == Sample && b.excess%l.sampleRate == 0 {
+		return true, true
+	}
+	l.suppress(key)
+	return false, false
+}
+
+// suppress records a dropped message. Must be called with the lock held.
+func (l *Limiter) suppress(key string) {
+	if _, ok := l.suppressed[key]; !ok && l.maxKeys > 0 && len(l.suppressed) >= l.maxKeys {
+		key = otherKey
+	}
+	l.suppressed[key]++
+
+	label := key
+	if _, ok := l.metricKeys[key]; !ok {
+		if len(l.metricKeys) >= l.maxMetricKeys {
+			label = otherKey
+		} else {
+			l.metricKeys[key] = struct{}{}
+		}
+	}
+	prometheus.RateLimited.WithLabelValues(l.name, label).Inc()
+}
+
+func (l *Limiter) summaryLoop() {
+	t := time.NewTicker(l.summaryEvery)
+	defer t.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-t.C:
+			l.summarize()
+		}
+	}
+}
+
+// summarize emits a summary per key that had messages suppressed since the
+// last call and resets the counts.
+func (l *Limiter) summarize() {
+	l.mu.Lock()
+	suppressed := l.suppressed
+	l.suppressed = make(map[string]int)
+	emit := l.emit
+	l.mu.Unlock()
+
+	if emit == nil {
+		return
+	}
+	now := l.now()
+	for key, n := range suppressed {
+		emit(format.LogParts{
+			"timestamp": now,
+			"content":   fmt.Sprintf("%d messages suppressed from %s", n, key),
+			Key:         key,
+			Suppressed:  n,
+		})
+	}
+}
+
+// Processor limits messages keyed on LogParts fields.
+type Processor struct {
+	*Limiter
+	fields []string
+}
+
+// NewProcessor limits on the combination of the given fields, for example
+// NewProcessor(l, "hostname") or NewProcessor(l, "hostname", "tag").
+func NewProcessor(l *Limiter, fields ...string) *Processor {
+	return &Processor{Limiter: l, fields: fields}
+}
+
+func (p *Processor) Process(parts format.LogParts) format.LogParts {
+	key := ""
+	for i, f := range p.fields {
+		if i > 0 {
+			key += "/"
+		}
+		key += fmt.Sprint(parts[f])
+	}
+	allowed, sampled := p.take(key)
+	if !allowed {
+		return nil
+	}
+	if sampled {
+		parts[SampleRate] = p.sampleRate
+	}
+	return parts
+}
@@ -0,0 +1,90 @@
+package ratelimit
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/metajar/metalogger/internal/fakeclock"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type RateLimitSuite struct{}
+
+var _ = Suite(&RateLimitSuite{})
+
+func newTest(opts ...Option) (*Limiter, *fakeclock.Clock) {
+	clk := fakeclock.New()
+	l := New(append(opts, WithClock(clk.Now))...)
+	return l, clk
+}
+
+func (s *RateLimitSuite) TestBurstAndRefill(c *C) {
+	l, clk := newTest(WithRate(2), WithBurst(3))
+	for i := 0; i < 3; i++ {
+		c.Check(l.Allow("10.0.0.1"), Equals, true)
+	}
+	c.Check(l.Allow("10.0.0.1"), Equals, false)
+	// Other keys have their own bucket.
+	c.Check(l.Allow("10.0.0.2"), Equals, true)
+
+	clk.Advance(500 * time.Millisecond)
+	c.Check(l.Allow("10.0.0.1"), Equals, true)
+	c.Check(l.Allow("10.0.0.1"), Equals, false)
+
+	// The bucket never refills past the burst.
+	clk.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		c.Check(l.Allow("10.0.0.1"), Equals, true)
+	}
+	c.Check(l.Allow("10.0.0.1"), Equals, false)
+}
+
+func (s *RateLimitSuite) TestSummary(c *C) {
+	l, _ := newTest(WithRate(1))
+	var emitted []format.LogParts
+	l.SetEmit(func(parts format.LogParts) {
+		emitted = append(emitted, parts)
+	})
+	for i := 0; i < 5; i++ {
+		l.Allow("10.0.0.1")
+	}
+	l.summarize()
+	c.Assert(emitted, HasLen, 1)
+	c.Check(emitted[0]["content"], Equals, "4 messages suppressed from 10.0.0.1")
+	c.Check(emitted[0][Key], Equals, "10.0.0.1")
+	c.Check(emitted[0][Suppressed], Equals, 4)
+
+	l.summarize()
+	c.Check(emitted, HasLen, 1)
+}
+
+func (s *RateLimitSuite) TestMaxKeys(c *C) {
+	l, _ := newTest(WithRate(1), WithMaxKeys(2), WithMaxMetricKeys(1))
+	for i := 0; i < 5; i++ {
+		key := strconv.Itoa(i)
+		l.Allow(key)
+		l.Allow(key)
+	}
+	c.Check(l.buckets, HasLen, 2)
+	c.Check(l.suppressed, DeepEquals, map[string]int{"0": 1, "1": 1, otherKey: 3})
+	c.Check(l.metricKeys, HasLen, 1)
+}
+
+func (s *RateLimitSuite) TestProcessorSampling(c *C) {
+	l, _ := newTest(WithRate(1), WithSampling(2))
+	p := NewProcessor(l, "hostname", "tag")
+	msg := func() format.LogParts {
+		return format.LogParts{"hostname": "r1", "tag": "bgp"}
+	}
+	c.Check(p.Process(msg()), DeepEquals, msg())
+	c.Check(p.Process(msg()), IsNil)
+	sampled := p.Process(msg())
+	c.Assert(sampled, NotNil)
+	c.Check(sampled[SampleRate], Equals, 2)
+	c.Check(p.Process(msg()), IsNil)
+	c.Check(l.suppressed, DeepEquals, map[string]int{"r1/bgp": 2})
+}
@@ -0,0 +1,88 @@
+// Package rules holds what the rule driven processors share: durations that
+// read as "5s" in JSON, loading rule files and reading the fields of a
+// message and the captures of the regexes that matched it.
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// Duration is a time.Duration that reads as "5s" or "2m" in JSON.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Load reads the JSON rule file at path into v.
+func Load(path string, v interface{}) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return nil
+}
+
+// Lookup returns field from the captures, or else from the message as a
+// string. ok is false if neither has it.
+func Lookup(field string, parts format.LogParts, captures map[string]string) (string, bool) {
+	if v, ok := captures[field]; ok {
+		return v, true
+	}
+	v, ok := parts[field]
+	if !ok || v == nil {
+		return "", false
+	}
+	if s, ok := v.(string); ok {
+		return s, true
+	}
+	return fmt.Sprint(v), true
+}
+
+// GroupKey joins the values of fields with "/", ok is false if one of them
+// is missing.
+func GroupKey(fields []string, parts format.LogParts, captures map[string]string) (string, bool) {
+	values := make([]string, 0, len(fields))
+	for _, f := range fields {
+		v, ok := Lookup(f, parts, captures)
+		if !ok {
+			return "", false
+		}
+		values = append(values, v)
+	}
+	return strings.Join(values, "/"), true
+}
+
+var templateField = regexp.MustCompile(`%\{([^}]+)\}`)
+
+// Expand replaces each %{field} in tmpl as Lookup finds it, missing fields
+// render as an empty string.
+func Expand(tmpl string, parts format.LogParts, captures map[string]string) string {
+	return templateField.ReplaceAllStringFunc(tmpl, func(s string) string {
+		v, _ := Lookup(s[2:len(s)-1], parts, captures)
+		return v
+	})
+}
@@ -0,0 +1,68 @@
+package rules
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type RulesSuite struct{}
+
+var _ = Suite(&RulesSuite{})
+
+func (s *RulesSuite) TestDuration(c *C) {
+	var d Duration
+	c.Assert(json.Unmarshal([]byte(`"90s"`), &d), IsNil)
+	c.Check(time.Duration(d), Equals, 90*time.Second)
+	b, err := json.Marshal(d)
+	c.Assert(err, IsNil)
+	c.Check(string(b), Equals, `"1m30s"`)
+	c.Check(json.Unmarshal([]byte(`"soon"`), &d), NotNil)
+	c.Check(json.Unmarshal([]byte(`5`), &d), NotNil)
+}
+
+func (s *RulesSuite) TestLoad(c *C) {
+	path := filepath.Join(c.MkDir(), "rules.json")
+	c.Assert(os.WriteFile(path, []byte(`[{"name": "a", "within": "1m"}]`), 0644), IsNil)
+	var rules []struct {
+		Name   string   `json:"name"`
+		Within Duration `json:"within"`
+	}
+	c.Assert(Load(path, &rules), IsNil)
+	c.Assert(rules, HasLen, 1)
+	c.Check(rules[0].Name, Equals, "a")
+	c.Check(time.Duration(rules[0].Within), Equals, time.Minute)
+
+	c.Assert(os.WriteFile(path, []byte(`[`), 0644), IsNil)
+	c.Check(Load(path, &rules), ErrorMatches, "parsing .*rules.json: .*")
+	c.Check(Load(filepath.Join(c.MkDir(), "missing.json"), &rules), NotNil)
+}
+
+func (s *RulesSuite) TestLookup(c *C) {
+	parts := format.LogParts{"hostname": "core1", "severity": 3, "empty": nil}
+	captures := map[string]string{"hostname": "captured", "ifname": "Gi0/0/0/1"}
+
+	v, ok := Lookup("hostname", parts, captures)
+	c.Check(v, Equals, "captured")
+	c.Check(ok, Equals, true)
+	v, ok = Lookup("severity", parts, nil)
+	c.Check(v, Equals, "3")
+	c.Check(ok, Equals, true)
+	_, ok = Lookup("empty", parts, nil)
+	c.Check(ok, Equals, false)
+
+	key, ok := GroupKey([]string{"severity", "ifname"}, parts, captures)
+	c.Check(key, Equals, "3/Gi0/0/0/1")
+	c.Check(ok, Equals, true)
+	_, ok = GroupKey([]string{"severity", "missing"}, parts, captures)
+	c.Check(ok, Equals, false)
+
+	c.Check(Expand("%{ifname} on %{hostname}%{missing}", parts, captures), Equals, "Gi0/0/0/1 on captured")
+}
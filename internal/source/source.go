@@ -0,0 +1,73 @@
+// Package source matches a device by the hostname in its messages or the
+// network it sends from, for settings configured per source such as its
+// format or time zone.
+package source
+
+import (
+	"net"
+	"sort"
+	"strings"
+)
+
+type network struct {
+	net   *net.IPNet
+	value interface{}
+}
+
+// Matcher finds the value set for a source by hostname, then by the longest
+// matching source network.
+type Matcher struct {
+	hostnames map[string]interface{}
+	networks  []network
+}
+
+func NewMatcher() *Matcher {
+	return &Matcher{hostnames: map[string]interface{}{}}
+}
+
+// AddHostname sets the value of a device by the hostname in its messages.
+// Matching is case insensitive and a short name also matches the fully
+// qualified one.
+func (m *Matcher) AddHostname(hostname string, v interface{}) {
+	m.hostnames[strings.ToLower(hostname)] = v
+}
+
+// AddCIDR sets the value of devices sending from a network, it returns an
+// error if cidr is invalid.
+func (m *Matcher) AddCIDR(cidr string, v interface{}) error {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+	m.networks = append(m.networks, network{n, v})
+	sort.SliceStable(m.networks, func(i, j int) bool {
+		a, _ := m.networks[i].net.Mask.Size()
+		b, _ := m.networks[j].net.Mask.Size()
+		return a > b
+	})
+	return nil
+}
+
+// Match returns the value of the device at ip, hostname may be empty if it
+// is not known yet. It returns false if nothing matches.
+func (m *Matcher) Match(ip, hostname string) (interface{}, bool) {
+	if hostname != "" {
+		h := strings.ToLower(hostname)
+		if v, ok := m.hostnames[h]; ok {
+			return v, true
+		}
+		if i := strings.IndexByte(h, '.'); i > 0 {
+			if v, ok := m.hostnames[h[:i]]; ok {
+				return v, true
+			}
+		}
+	}
+	if addr := net.ParseIP(ip); addr != nil {
+		for _, n := range m.networks {
+			if n.net.Contains(addr) {
+				return n.value, true
+			}
+		}
+	}
+	return nil, false
+}
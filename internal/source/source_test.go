@@ -0,0 +1,42 @@
+package source
+
+import (
+	"testing"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type SourceSuite struct{}
+
+var _ = Suite(&SourceSuite{})
+
+func (s *SourceSuite) TestMatch(c *C) {
+	m := NewMatcher()
+	c.Assert(m.AddCIDR("10.0.0.0/8", "wide"), IsNil)
+	c.Assert(m.AddCIDR("10.1.0.0/16", "narrow"), IsNil)
+	c.Assert(m.AddCIDR("2001:db8::/32", "v6"), IsNil)
+	c.Check(m.AddCIDR("10.0.0.0/33", "bad"), ErrorMatches, "invalid CIDR address: 10.0.0.0/33")
+	m.AddHostname("Core1", "host")
+
+	fixtures := []struct {
+		ip, hostname string
+		expected     interface{}
+		ok           bool
+	}{
+		{"10.1.2.3", "", "narrow", true},
+		{"10.2.2.3", "", "wide", true},
+		{"2001:db8::1", "", "v6", true},
+		{"10.1.2.3", "CORE1", "host", true},
+		{"10.1.2.3", "core1.example.net", "host", true},
+		{"10.1.2.3", "core2.example.net", "narrow", true},
+		{"192.0.2.1", "", nil, false},
+		{"not an address", "", nil, false},
+	}
+	for _, f := range fixtures {
+		v, ok := m.Match(f.ip, f.hostname)
+		c.Check(v, Equals, f.expected, Commentf("%s %s", f.ip, f.hostname))
+		c.Check(ok, Equals, f.ok, Commentf("%s %s", f.ip, f.hostname))
+	}
+}
@@ -0,0 +1,195 @@
+package syslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/metajar/metalogger/internal/metrics/prometheus"
+)
+
+// ACLAction is what an ACL does with the sources a rule matches
+type ACLAction string
+
+const (
+	ACLAllow ACLAction = "allow"
+	ACLDeny  ACLAction = "deny"
+)
+
+// aclDefaultRule names the default action in metrics
+const aclDefaultRule = "default"
+
+// ACLRule allows or denies the sources in its networks
+type ACLRule struct {
+	Name    string    `json:"name"`
+	Action  ACLAction `json:"action"`
+	Sources []string  `json:"sources"`
+}
+
+// ACLConfig is an ordered list of rules, the first one matching a source
+// decides. Sources no rule matches get Default, which allows if empty.
+type ACLConfig struct {
+	Default ACLAction `json:"default"`
+	Rules   []ACLRule `json:"rules"`
+}
+
+type aclRule struct {
+	name    string
+	allow   bool
+	sources []*net.IPNet
+}
+
+// ACL restricts the sources a listener accepts datagrams and connections
+// from. Its rules can be replaced at runtime with Set, or with Reload and
+// Watch when loaded from a file.
+type ACL struct {
+	mu           sync.RWMutex
+	rules        []aclRule
+	defaultAllow bool
+
+	path    string
+	watcher *fileWatcher
+}
+
+// NewACL returns an error if a rule is invalid
+func NewACL(config ACLConfig) (*ACL, error) {
+	a := &ACL{}
+	if err := a.Set(config); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// LoadACL reads an ACLConfig from a JSON file, which Reload and Watch read
+// again
+func LoadACL(path string) (*ACL, error) {
+	a := &ACL{path: path}
+	a.watcher = newFileWatcher("acl", []string{path}, a.read, prometheus.ACLReloads)
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func compileACL(config ACLConfig) ([]aclRule, bool, error) {
+	defaultAllow, err := aclAllows(config.Default, ACLAllow)
+	if err != nil {
+		return nil, false, fmt.Errorf("default: %w", err)
+	}
+	var rules []aclRule
+	for i, r := range config.Rules {
+		rule := aclRule{name: r.Name}
+		if rule.name == "" {
+			rule.name = fmt.Sprint(i)
+		}
+		if rule.allow, err = aclAllows(r.Action, ""); err != nil {
+			return nil, false, fmt.Errorf("rule %s: %w", rule.name, err)
+		}
+		for _, cidr := range r.Sources {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, false, fmt.Errorf("rule %s: %w", rule.name, err)
+			}
+			rule.sources = append(rule.sources, n)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, defaultAllow, nil
+}
+
+func aclAllows(action, empty ACLAction) (bool, error) {
+	if action == "" {
+		action = empty
+	}
+	switch action {
+	case ACLAllow:
+		return true, nil
+	case ACLDeny:
+		return false, nil
+	}
+	return false, fmt.Errorf("unknown acl action %q", action)
+}
+
+// Set replaces the rules, they are left as they are if config is invalid
+func (a *ACL) Set(config ACLConfig) error {
+	rules, defaultAllow, err := compileACL(config)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.rules, a.defaultAllow = rules, defaultAllow
+	a.mu.Unlock()
+	return nil
+}
+
+// Reload reads the file the ACL was loaded from again
+func (a *ACL) Reload() error {
+	if a.watcher == nil {
+		return nil
+	}
+	return a.watcher.load()
+}
+
+func (a *ACL) read() error {
+	b, err := os.ReadFile(a.path)
+	if err != nil {
+		return err
+	}
+	var config ACLConfig
+	if err := json.Unmarshal(b, &config); err != nil {
+		return fmt.Errorf("parsing %s: %w", a.path, err)
+	}
+	if err := a.Set(config); err != nil {
+		return fmt.Errorf("%s: %w", a.path, err)
+	}
+	return nil
+}
+
+// Watch checks the file for changes every interval until Stop, a file that
+// fails to load keeps the previous rules
+func (a *ACL) Watch(interval time.Duration) {
+	if a.watcher == nil {
+		return
+	}
+	a.watcher.watch(interval)
+}
+
+// Stop stops Watch
+func (a *ACL) Stop() {
+	if a.watcher == nil {
+		return
+	}
+	a.watcher.Stop()
+}
+
+// Check returns whether ip is allowed and the name of the rule that decided
+func (a *ACL) Check(ip net.IP) (rule string, allowed bool) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if ip != nil {
+		for _, r := range a.rules {
+			for _, n := range r.sources {
+				if n.Contains(ip) {
+					return r.name, r.allow
+				}
+			}
+		}
+	}
+	return aclDefaultRule, a.defaultAllow
+}
+
+// admit applies the listener ACL to a datagram or connection from addr,
+// kind is what is counted when it is rejected
+func (s *Server) admit(info ListenerInfo, addr, kind string) bool {
+	if info.acl == nil {
+		return true
+	}
+	rule, ok := info.acl.Check(net.ParseIP(clientHost(addr)))
+	if !ok {
+		prometheus.ACLRejected.WithLabelValues(info.Name, rule, kind).Inc()
+	}
+	return ok
+}
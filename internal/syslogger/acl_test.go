@@ -0,0 +1,190 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/metajar/metalogger/internal/metrics/prometheus"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestACL(c *C) {
+	acl, err := NewACL(ACLConfig{
+		Default: ACLDeny,
+		Rules: []ACLRule{
+			{Name: "spoofers", Action: ACLDeny, Sources: []string{"10.1.2.0/24"}},
+			{Name: "routers", Action: ACLAllow, Sources: []string{"10.0.0.0/8", "2001:db8::/32"}},
+			{Action: ACLAllow, Sources: []string{"10.1.2.3/32"}},
+		},
+	})
+	c.Assert(err, IsNil)
+	fixtures := []struct {
+		ip      string
+		rule    string
+		allowed bool
+	}{
+		{"10.9.9.9", "routers", true},
+		{"10.1.2.3", "spoofers", false},
+		{"2001:db8::1", "routers", true},
+		{"192.0.2.1", "default", false},
+		{"", "default", false},
+	}
+	for _, f := range fixtures {
+		rule, allowed := acl.Check(net.ParseIP(f.ip))
+		c.Check(rule, Equals, f.rule, Commentf("ip %s", f.ip))
+		c.Check(allowed, Equals, f.allowed, Commentf("ip %s", f.ip))
+	}
+
+	// an empty default allows, Set replaces the rules
+	c.Assert(acl.Set(ACLConfig{Rules: []ACLRule{{Action: ACLDeny, Sources: []string{"10.0.0.0/8"}}}}), IsNil)
+	rule, allowed := acl.Check(net.ParseIP("10.9.9.9"))
+	c.Check(rule, Equals, "0")
+	c.Check(allowed, Equals, false)
+	_, allowed = acl.Check(net.ParseIP("192.0.2.1"))
+	c.Check(allowed, Equals, true)
+
+	c.Check(acl.Set(ACLConfig{Default: "drop"}), ErrorMatches, `default: unknown acl action "drop"`)
+	c.Check(acl.Set(ACLConfig{Rules: []ACLRule{{Name: "r", Sources: []string{"10.0.0.0/8"}}}}), ErrorMatches, `rule r: unknown acl action ""`)
+	c.Check(acl.Set(ACLConfig{Rules: []ACLRule{{Name: "r", Action: ACLAllow, Sources: []string{"nope"}}}}), ErrorMatches, "rule r: invalid CIDR address: nope")
+	// invalid configs leave the rules as they are
+	_, allowed = acl.Check(net.ParseIP("10.9.9.9"))
+	c.Check(allowed, Equals, false)
+}
+
+func (s *ServerSuite) TestLoadACL(c *C) {
+	path := filepath.Join(c.MkDir(), "acl.json")
+	c.Assert(os.WriteFile(path, []byte(`{"default": "deny", "rules": [{"name": "lab", "action": "allow", "sources": ["192.0.2.0/24"]}]}`), 0o600), IsNil)
+	acl, err := LoadACL(path)
+	c.Assert(err, IsNil)
+	defer acl.Stop()
+	_, allowed := acl.Check(net.ParseIP("192.0.2.1"))
+	c.Check(allowed, Equals, true)
+
+	acl.Watch(10 * time.Millisecond)
+	c.Assert(os.WriteFile(path, []byte(`{"default": "deny"}`), 0o600), IsNil)
+	c.Assert(os.Chtimes(path, time.Now().Add(time.Second), time.Now().Add(time.Second)), IsNil)
+	deadline := time.Now().Add(2 * time.Second)
+	for allowed && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+		_, allowed = acl.Check(net.ParseIP("192.0.2.1"))
+	}
+	c.Check(allowed, Equals, false)
+
+	c.Assert(os.WriteFile(path, []byte(`{"default": "deny", "rules": [`), 0o600), IsNil)
+	c.Check(acl.Reload(), ErrorMatches, "parsing .*acl.json: .*")
+	_, err = LoadACL(filepath.Join(c.MkDir(), "missing.json"))
+	c.Check(err, NotNil)
+}
+
+func (s *ServerSuite) TestListenerACL(c *C) {
+	acl, err := NewACL(ACLConfig{
+		Default: ACLDeny,
+		Rules: []ACLRule{
+			{Name: "spoofer", Action: ACLDeny, Sources: []string{"192.0.2.66/32"}},
+			{Name: "routers", Action: ACLAllow, Sources: []string{"192.0.2.0/24"}},
+		},
+	})
+	c.Assert(err, IsNil)
+	handler := &handlerCollector{parts: make(chan format.LogParts, 8)}
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(handler)
+	// the PROXY protocol stands in for sources other than loopback
+	trusted := []string{"127.0.0.0/8"}
+	c.Assert(server.Listen(ListenerConfig{Name: "acl-udp", Protocol: "udp", Address: "127.0.0.1:0", TrustedProxies: trusted, ACL: acl}), IsNil)
+	c.Assert(server.Listen(ListenerConfig{Name: "acl-tcp", Protocol: "tcp", Address: "127.0.0.1:0", TrustedProxies: trusted, ACL: acl}), IsNil)
+	ca := newTestCert(c, nil, "ca")
+	files := writeTLSFiles(c, c.MkDir(), newTestCert(c, ca, "syslog", "syslog.example.net"), ca)
+	c.Assert(server.Listen(ListenerConfig{Name: "acl-tls", Protocol: "tls", Address: "127.0.0.1:0", TLSFiles: &files, TrustedProxies: trusted, ACL: acl}), IsNil)
+	c.Assert(server.Boot(), IsNil)
+
+	// the counters are global, count from here
+	rejected := map[string]float64{}
+	counter := func(listener, rule, kind string) float64 {
+		v := testutil.ToFloat64(prometheus.ACLRejected.WithLabelValues(listener, rule, kind))
+		key := listener + rule + kind
+		if _, ok := rejected[key]; !ok {
+			rejected[key] = v
+		}
+		return v - rejected[key]
+	}
+	for _, l := range []string{"acl-udp datagram", "acl-tcp connection", "acl-tls connection"} {
+		f := strings.Fields(l)
+		for _, rule := range []string{"spoofer", "default", "lab"} {
+			counter(f[0], rule, f[1])
+		}
+	}
+
+	sendUDP := func(source string) {
+		conn, err := net.Dial("udp", server.connections[0].LocalAddr().String())
+		c.Assert(err, IsNil)
+		conn.Write(append(proxyV2Header(&net.TCPAddr{IP: net.ParseIP(source), Port: 514}, true), exampleSyslog...))
+		conn.Close()
+	}
+	sendTCP := func(source string) {
+		conn, err := net.Dial("tcp", server.listeners[0].Addr().String())
+		c.Assert(err, IsNil)
+		conn.Write([]byte("PROXY TCP4 " + source + " 198.51.100.1 40000 514\r\n" + exampleSyslog + "\n"))
+		conn.Close()
+	}
+	received := func() []string {
+		var clients []string
+		for {
+			select {
+			case parts := <-handler.parts:
+				clients = append(clients, parts["listener"].(string)+" "+clientHost(parts["client"].(string)))
+			case <-time.After(200 * time.Millisecond):
+				sort.Strings(clients)
+				return clients
+			}
+		}
+	}
+
+	for _, source := range []string{"192.0.2.1", "192.0.2.66", "198.51.100.7"} {
+		sendUDP(source)
+		sendTCP(source)
+	}
+	c.Check(received(), DeepEquals, []string{"acl-tcp 192.0.2.1", "acl-udp 192.0.2.1"})
+	c.Check(counter("acl-udp", "spoofer", "datagram"), Equals, 1.0)
+	c.Check(counter("acl-udp", "default", "datagram"), Equals, 1.0)
+	c.Check(counter("acl-tcp", "spoofer", "connection"), Equals, 1.0)
+	c.Check(counter("acl-tcp", "default", "connection"), Equals, 1.0)
+
+	// rejected before the handshake
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	handshake := func(source string) error {
+		conn, err := net.Dial("tcp", server.listeners[1].Addr().String())
+		c.Assert(err, IsNil)
+		defer conn.Close()
+		conn.Write([]byte("PROXY TCP4 " + source + " 198.51.100.1 40000 514\r\n"))
+		tlsConn := tls.Client(conn, &tls.Config{
+			RootCAs:      roots,
+			ServerName:   "syslog.example.net",
+			Certificates: []tls.Certificate{newTestCert(c, ca, "core1").tlsCertificate(c)},
+		})
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		return tlsConn.Handshake()
+	}
+	c.Check(handshake("192.0.2.1"), IsNil)
+	c.Check(handshake("198.51.100.7"), NotNil)
+	c.Check(counter("acl-tls", "default", "connection"), Equals, 1.0)
+
+	// the running listeners pick up new rules
+	c.Assert(acl.Set(ACLConfig{Default: ACLAllow, Rules: []ACLRule{{Name: "lab", Action: ACLDeny, Sources: []string{"192.0.2.0/24"}}}}), IsNil)
+	sendUDP("192.0.2.1")
+	sendUDP("198.51.100.7")
+	c.Check(received(), DeepEquals, []string{"acl-udp 198.51.100.7"})
+	c.Check(counter("acl-udp", "lab", "datagram"), Equals, 1.0)
+
+	server.Kill()
+	server.Wait()
+}
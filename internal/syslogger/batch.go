@@ -0,0 +1,72 @@
+package syslog
+
+import (
+	"time"
+)
+
+const defaultBatchSize = 32
+
+// listenWorkers opens cfg.Workers udp sockets sharing the address
+func (s *Server) listenWorkers(cfg ListenerConfig, proxies trustedProxies) error {
+	batchSize := cfg.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	address := cfg.Address
+	var info ListenerInfo
+	connections, sockets := len(s.connections), len(s.udpSockets)
+	for i := 0; i < cfg.Workers; i++ {
+		connection, err := listenReusePort(address)
+		if err != nil {
+			// unbind the sockets opened so far
+			for _, c := range s.connections[connections:] {
+				c.Close()
+			}
+			s.connections = s.connections[:connections]
+			s.connectionInfos = s.connectionInfos[:connections]
+			s.udpSockets = s.udpSockets[:sockets]
+			return err
+		}
+		size := s.readBufferSize(cfg)
+		connection.SetReadBuffer(size)
+		if i == 0 {
+			// the other sockets bind the port picked for the first
+			address = connection.LocalAddr().String()
+			info = newListenerInfo(cfg.Name, cfg.Protocol, connection.LocalAddr())
+			info.format = cfg.Format
+			info.proxies = proxies
+			info.acl = cfg.ACL
+			info.parseErrorAction = cfg.ParseErrorAction
+			info.batchSize = batchSize
+		}
+		info.socket = s.addUDPSocket(info.Name, i, connection, size)
+		s.connections = append(s.connections, connection)
+		s.connectionInfos = append(s.connectionInfos, info)
+	}
+	return nil
+}
+
+// goReceiveBatches reads datagrams in batches, with recvmmsg on Linux, and
+// parses them on the same goroutine
+func (s *Server) goReceiveBatches(r *batchReader, info ListenerInfo) {
+	s.wait.Add(1)
+	go func() {
+		defer s.wait.Done()
+		for {
+			n, err := r.read()
+			if err != nil {
+				if !temporaryError(err) {
+					return
+				}
+				continue
+			}
+			receivedAt := time.Now()
+			for i := 0; i < n; i++ {
+				info.socket.recordOverflows(r.oobs[i])
+				if msg, ok := s.datagram(r.bufs[i], r.sizes[i], r.addrs[i], info, receivedAt); ok {
+					s.parseDatagram(msg)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,66 @@
+package syslog
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"time"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestUDPWorkers(c *C) {
+	if runtime.GOOS != "linux" {
+		server := NewServer()
+		c.Check(server.Listen(ListenerConfig{Protocol: "udp", Address: "127.0.0.1:0", Workers: 2}), ErrorMatches, "udp workers are only supported on linux")
+		return
+	}
+	const senders = 16
+	handler := &handlerCollector{parts: make(chan format.LogParts, senders)}
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(handler)
+	c.Assert(server.Listen(ListenerConfig{Name: "workers", Protocol: "udp", Address: "127.0.0.1:0", Workers: 2, BatchSize: 4}), IsNil)
+	c.Assert(server.connections, HasLen, 2)
+	addr := server.connections[0].LocalAddr().String()
+	c.Check(server.connections[1].LocalAddr().String(), Equals, addr)
+	c.Check(server.connectionInfos[1].batchSize, Equals, 4)
+	c.Assert(server.Boot(), IsNil)
+	defer server.Kill()
+	c.Check(server.datagramChannel, IsNil)
+
+	// the kernel spreads the senders over the sockets by their source port
+	clients := map[string]bool{}
+	for i := 0; i < senders; i++ {
+		conn, err := net.Dial("udp", addr)
+		c.Assert(err, IsNil)
+		clients[conn.LocalAddr().String()] = true
+		conn.Write([]byte(exampleSyslog))
+		conn.Close()
+	}
+	for i := 0; i < senders; i++ {
+		select {
+		case parts := <-handler.parts:
+			c.Check(parts["content"], Equals, "content")
+			c.Check(parts["listener"], Equals, "workers")
+			c.Check(clients[parts["client"].(string)], Equals, true, Commentf("client %v", parts["client"]))
+		case <-time.After(2 * time.Second):
+			c.Fatalf("received %d of %d messages", i, senders)
+		}
+	}
+}
+
+func (s *ServerSuite) TestBatchReaderError(c *C) {
+	if runtime.GOOS != "linux" {
+		c.Skip("batch reads only fail without a socket on linux")
+	}
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(new(HandlerMock))
+	server.connections = append(server.connections, &fakePacketConn{PipeReader: reader})
+	server.connectionInfos = append(server.connectionInfos, ListenerInfo{Name: "workers", batchSize: 4})
+	c.Check(server.Boot(), ErrorMatches, "listener workers: batch reads need a socket")
+}
@@ -0,0 +1,219 @@
+package syslog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/metajar/metalogger/internal/logger"
+	"github.com/metajar/metalogger/internal/metrics/prometheus"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// ParseErrorAction decides what happens to a message that failed to parse
+type ParseErrorAction int
+
+const (
+	// ParseErrorDefault leaves a listener to the server policy, and passes
+	// the message on as the server policy
+	ParseErrorDefault ParseErrorAction = iota
+	// ParseErrorPass hands the message on with parse_error set
+	ParseErrorPass
+	// ParseErrorDrop drops the message
+	ParseErrorDrop
+	// ParseErrorQuarantine hands the message, with the raw bytes, to the
+	// quarantine writer instead of the handler. Boot fails if a listener
+	// quarantines without a quarantine writer.
+	ParseErrorQuarantine
+)
+
+// quarantineQueueSize is how many messages may wait for the quarantine
+// writer before further ones are dropped
+const quarantineQueueSize = 1024
+
+// QuarantineWriter receives the messages diverted by ParseErrorQuarantine. Any
+// metalogger writer satisfies it.
+type QuarantineWriter interface {
+	Write(parts format.LogParts)
+}
+
+// Failure is a message that failed to parse
+type Failure struct {
+	Time     time.Time `json:"time"`
+	Listener string    `json:"listener"`
+	Client   string    `json:"client"`
+	Format   string    `json:"format"`
+	Error    string    `json:"error"`
+	Raw      string    `json:"raw"`
+}
+
+// failureRing keeps the most recent failures
+type failureRing struct {
+	mu       sync.Mutex
+	failures []Failure
+	next     int
+	full     bool
+}
+
+func (r *failureRing) add(f Failure) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.failures) == 0 {
+		return
+	}
+	r.failures[r.next] = f
+	r.next = (r.next + 1) % len(r.failures)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// recent returns the failures, oldest first
+func (r *failureRing) recent() []Failure {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]Failure(nil), r.failures[:r.next]...)
+	}
+	return append(append([]Failure(nil), r.failures[r.next:]...), r.failures[:r.next]...)
+}
+
+// SetParseErrorPolicy Sets what happens to messages that fail to parse on
+// listeners without their own policy, see ListenerConfig.ParseErrorAction
+func (s *Server) SetParseErrorPolicy(a ParseErrorAction) {
+	s.parseErrorAction = a
+}
+
+// SetQuarantineWriter Sets the writer for ParseErrorQuarantine. It is called
+// from its own goroutine, started by Boot, so a slow writer does not hold up
+// reading.
+func (s *Server) SetQuarantineWriter(w QuarantineWriter) {
+	s.quarantine = w
+	s.quarantineQueue = make(chan format.LogParts, quarantineQueueSize)
+}
+
+// parseErrorActionFor returns the parse error policy of the listener
+func (s *Server) parseErrorActionFor(info ListenerInfo) ParseErrorAction {
+	if info.parseErrorAction != ParseErrorDefault {
+		return info.parseErrorAction
+	}
+	return s.parseErrorAction
+}
+
+// checkQuarantine returns an error if a listener quarantines without a
+// quarantine writer
+func (s *Server) checkQuarantine(infos []ListenerInfo) error {
+	if s.quarantine != nil {
+		return nil
+	}
+	for _, info := range infos {
+		if s.parseErrorActionFor(info) == ParseErrorQuarantine {
+			return fmt.Errorf("listener %s: quarantine parse error policy without a quarantine writer", info.Name)
+		}
+	}
+	return nil
+}
+
+func (s *Server) goWriteQuarantine() {
+	if s.doneTcp == nil {
+		s.doneTcp = make(chan bool)
+	}
+	done := s.doneTcp
+	queue := s.quarantineQueue
+	s.wait.Add(1)
+	go func() {
+		defer s.wait.Done()
+		for {
+			select {
+			case <-done:
+				return
+			case parts := <-queue:
+				s.quarantine.Write(parts)
+			}
+		}
+	}()
+}
+
+// SetRecentFailures Sets how many failures RecentFailures keeps, 100 by
+// default. n <= 0 keeps none.
+func (s *Server) SetRecentFailures(n int) {
+	if n < 0 {
+		n = 0
+	}
+	s.failures = &failureRing{failures: make([]Failure, n)}
+}
+
+// RecentFailures returns the most recent parse failures, oldest first
+func (s *Server) RecentFailures() []Failure {
+	return s.failures.recent()
+}
+
+// FailuresHandler serves RecentFailures as JSON
+func (s *Server) FailuresHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.RecentFailures()); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
+
+// parseFailed records the failure and applies the listener's policy. It
+// returns false if the handler should not see the message.
+func (s *Server) parseFailed(logParts format.LogParts, line []byte, o origin, err error) bool {
+	name, _ := logParts["format"].(string)
+	prometheus.ParseErrors.WithLabelValues(name, o.listener.Name).Inc()
+	s.lastErrorMu.Lock()
+	s.lastError = err
+	s.lastErrorMu.Unlock()
+	s.failures.add(Failure{
+		Time:     o.receivedAt,
+		Listener: o.listener.Name,
+		Client:   o.client,
+		Format:   name,
+		Error:    err.Error(),
+		Raw:      string(line),
+	})
+
+	logParts["parse_error"] = err.Error()
+	switch s.parseErrorActionFor(o.listener) {
+	case ParseErrorDrop:
+		return false
+	case ParseErrorQuarantine:
+		logParts["raw"] = string(line)
+		select {
+		case s.quarantineQueue <- logParts:
+		default:
+			// full, or no quarantine writer
+			prometheus.QuarantineDropped.WithLabelValues(o.listener.Name).Inc()
+		}
+		return false
+	}
+	return true
+}
+
+// isFramingError reports whether a stream was ended by a frame that could not
+// be split off, rather than by the connection
+func isFramingError(err error) bool {
+	return errors.Is(err, format.ErrInvalidFraming) ||
+		errors.Is(err, format.ErrMessageTooLarge) ||
+		errors.Is(err, bufio.ErrTooLong)
+}
+
+// framingFailed records a framing error, which ends the connection
+func (s *Server) framingFailed(o origin, err error) {
+	name := format.Name(s.formatForSource(o.listener, clientHost(o.client), ""))
+	prometheus.FramingErrors.WithLabelValues(name, o.listener.Name).Inc()
+	logger.SugarLogger.Warnw("closing connection with invalid framing", "listener", o.listener.Name, "client", o.client, "error", err)
+	s.failures.add(Failure{
+		Time:     time.Now(),
+		Listener: o.listener.Name,
+		Client:   o.client,
+		Format:   name,
+		Error:    err.Error(),
+	})
+}
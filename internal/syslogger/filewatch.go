@@ -0,0 +1,100 @@
+package syslog
+
+import (
+	"os"
+	"sync"
+	"time"
+
+	"github.com/metajar/metalogger/internal/logger"
+	promclient "github.com/prometheus/client_golang/prometheus"
+)
+
+// fileWatcher reloads a set of files when their modification times change,
+// for CertReloader and ACL. A reload that fails keeps what was loaded before.
+type fileWatcher struct {
+	name    string
+	paths   []string
+	read    func() error
+	reloads *promclient.CounterVec
+
+	mu      sync.RWMutex
+	modTime map[string]time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// newFileWatcher calls read to load paths, name and reloads are used to log
+// and count the reloads
+func newFileWatcher(name string, paths []string, read func() error, reloads *promclient.CounterVec) *fileWatcher {
+	return &fileWatcher{name: name, paths: paths, read: read, reloads: reloads, stop: make(chan struct{})}
+}
+
+// load calls read, recording the modification times from before the read so
+// a change during it is picked up next time
+func (w *fileWatcher) load() error {
+	modTime := map[string]time.Time{}
+	for _, name := range w.paths {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return err
+		}
+		modTime[name] = fi.ModTime()
+	}
+	if err := w.read(); err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.modTime = modTime
+	w.mu.Unlock()
+	return nil
+}
+
+// changed reports whether any of the files has a new modification time
+func (w *fileWatcher) changed() bool {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	for _, name := range w.paths {
+		fi, err := os.Stat(name)
+		if err != nil {
+			// mid rotation, try again later
+			continue
+		}
+		if !fi.ModTime().Equal(w.modTime[name]) {
+			return true
+		}
+	}
+	return false
+}
+
+// watch checks the files for changes every interval until Stop
+func (w *fileWatcher) watch(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-w.stop:
+				return
+			case <-ticker.C:
+				if !w.changed() {
+					continue
+				}
+				if err := w.load(); err != nil {
+					w.reloads.WithLabelValues("error").Inc()
+					logger.SugarLogger.Errorw("could not reload "+w.name, "path", w.paths[0], "error", err)
+					continue
+				}
+				w.reloads.WithLabelValues("ok").Inc()
+				logger.SugarLogger.Infow("reloaded "+w.name, "path", w.paths[0])
+			}
+		}
+	}()
+}
+
+// Stop stops watch
+func (w *fileWatcher) Stop() {
+	w.once.Do(func() {
+		close(w.stop)
+	})
+}
@@ -62,12 +62,14 @@ func detect(data []byte) int {
 	return detectedRFC3164
 }
 
+// GetParser returns a parser for the detected format, which is recorded in the
+// format field
 func (f *Automatic) GetParser(line []byte) LogParser {
 	switch format := detect(line); format {
 	case detectedRFC3164:
-		return &parserWrapper{rfc3164.NewParser(line)}
+		return &automaticParser{&parserWrapper{rfc3164.NewParser(line)}, "rfc3164"}
 	case detectedRFC5424:
-		return &parserWrapper{rfc5424.NewParser(line)}
+		return &automaticParser{&parserWrapper{rfc5424.NewParser(line)}, "rfc5424"}
 	default:
 		// If the line was an RFC6587 line, the splitter should already have removed the length,
 		// so one of the above two will be chosen if the line is correctly formed. However, it
@@ -75,10 +77,24 @@ func (f *Automatic) GetParser(line []byte) LogParser {
 		// will return detectedRFC6587. The line may also simply be malformed after the length in
 		// which case we will have detectedUnknown. In this case we return the simplest parser so
 		// the illegally formatted line is properly handled
-		return &parserWrapper{rfc3164.NewParser(line)}
+		return &automaticParser{&parserWrapper{rfc3164.NewParser(line)}, "rfc3164"}
 	}
 }
 
+type automaticParser struct {
+	*parserWrapper
+	format string
+}
+
+func (p *automaticParser) Dump() LogParts {
+	parts := p.parserWrapper.Dump()
+	if parts == nil {
+		parts = LogParts{}
+	}
+	parts["format"] = p.format
+	return parts
+}
+
 func (f *Automatic) GetSplitFunc() bufio.SplitFunc {
 	return f.automaticScannerSplit
 }
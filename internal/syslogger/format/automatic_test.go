@@ -0,0 +1,22 @@
+package format
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *FormatSuite) TestAutomaticFormat(c *C) {
+	fixtures := map[string]string{
+		`<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - 'su root' failed`: "rfc5424",
+		`<31>Dec 26 05:08:46 hostname tag[296]: content`:                                    "rfc3164",
+		`garbage`: "rfc3164",
+	}
+	for line, want := range fixtures {
+		parser := (&Automatic{}).GetParser([]byte(line))
+		parser.Parse()
+		c.Check(parser.Dump()["format"], Equals, want, Commentf("line %s", line))
+	}
+
+	parser := NewChain(&CiscoXR{}, &Automatic{}).GetParser([]byte(`<31>Dec 26 05:08:46 hostname tag[296]: content`))
+	c.Check(parser.Parse(), IsNil)
+	c.Check(parser.Dump()["format"], Equals, "rfc3164")
+}
@@ -0,0 +1,121 @@
+package format
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Chain tries each of its formats in order, the first one that parses the
+// message wins. The winner is recorded in the format field. If none of them
+// parse it, the result and error of the first one is kept.
+//
+// Framing is taken from the first format that has a split function, so mix
+// only formats that agree on it.
+type Chain struct {
+	Formats []Format
+}
+
+// NewChain returns a Chain of the formats
+func NewChain(formats ...Format) *Chain {
+	return &Chain{Formats: formats}
+}
+
+func (f *Chain) GetParser(line []byte) LogParser {
+	return &chainParser{chain: f, line: line}
+}
+
+func (f *Chain) GetSplitFunc() bufio.SplitFunc {
+	for _, format := range f.Formats {
+		if sf := format.GetSplitFunc(); sf != nil {
+			return sf
+		}
+	}
+	return nil
+}
+
+type chainParser struct {
+	chain      *Chain
+	line       []byte
+	location   *time.Location
+	receivedAt time.Time
+	parser     LogParser
+	format     Format
+}
+
+func (p *chainParser) Parse() error {
+	var firstErr error
+	var first LogParser
+	for _, format := range p.chain.Formats {
+		parser := format.GetParser(p.line)
+		if p.location != nil {
+			parser.Location(p.location)
+		}
+		if !p.receivedAt.IsZero() {
+			parser.ReceivedAt(p.receivedAt)
+		}
+		err := parser.Parse()
+		if err == nil {
+			p.parser, p.format = parser, format
+			return nil
+		}
+		if first == nil {
+			first, firstErr = parser, err
+			p.format = format
+		}
+	}
+	if first == nil {
+		return errors.New("empty format chain")
+	}
+	p.parser = first
+	return firstErr
+}
+
+func (p *chainParser) Dump() LogParts {
+	if p.parser == nil {
+		return nil
+	}
+	parts := p.parser.Dump()
+	if parts == nil {
+		parts = LogParts{}
+	}
+	// Automatic reports the format it detected
+	if _, ok := parts["format"]; !ok {
+		parts["format"] = Name(p.format)
+	}
+	return parts
+}
+
+func (p *chainParser) Location(location *time.Location) {
+	p.location = location
+}
+
+func (p *chainParser) ReceivedAt(t time.Time) {
+	p.receivedAt = t
+}
+
+// Name returns the short name of a format, as used in the format field and
+// metric labels
+func Name(f Format) string {
+	switch f := f.(type) {
+	case *RFC3164:
+		return "rfc3164"
+	case *RFC5424:
+		return "rfc5424"
+	case *RFC6587:
+		return "rfc6587"
+	case *RFC5425:
+		return "rfc5425"
+	case *Automatic:
+		return "automatic"
+	case *CiscoXR:
+		return "ciscoxr"
+	case *Chain:
+		return "chain"
+	case nil:
+		return ""
+	default:
+		return fmt.Sprintf("%T", f)
+	}
+}
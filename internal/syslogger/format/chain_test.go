@@ -0,0 +1,41 @@
+package format
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *FormatSuite) TestChain(c *C) {
+	chain := NewChain(&CiscoXR{}, &RFC5424{}, &RFC3164{})
+	fixtures := map[string]string{
+		`<187>1234: RP/0/RSP0/CPU0:Dec 12 00:19:57.123 UTC: ifmgr[123]: %PKT_INFRA-LINK-3-UPDOWN : Interface GigabitEthernet0/0/0/1, changed state to Down`: "ciscoxr",
+		`<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - 'su root' failed`:                                                                 "rfc5424",
+		`<31>Dec 26 05:08:46 hostname tag[296]: content`:                                                                                                    "rfc3164",
+	}
+	for line, want := range fixtures {
+		parser := chain.GetParser([]byte(line))
+		parser.Location(time.UTC)
+		c.Check(parser.Parse(), IsNil, Commentf("line %s", line))
+		c.Check(parser.Dump()["format"], Equals, want, Commentf("line %s", line))
+	}
+	c.Check(chain.GetSplitFunc(), IsNil)
+	c.Check(NewChain(&RFC3164{}, &RFC6587{}).GetSplitFunc(), NotNil)
+}
+
+func (s *FormatSuite) TestChainNoMatch(c *C) {
+	parser := NewChain(&CiscoXR{}, &RFC5424{}).GetParser([]byte("garbage"))
+	c.Check(parser.Parse(), Equals, ErrCiscoXRNoMatch)
+	c.Check(parser.Dump()["format"], Equals, "ciscoxr")
+
+	parser = NewChain().GetParser([]byte("garbage"))
+	c.Check(parser.Parse(), ErrorMatches, "empty format chain")
+	c.Check(parser.Dump(), IsNil)
+}
+
+func (s *FormatSuite) TestName(c *C) {
+	c.Check(Name(&RFC3164{}), Equals, "rfc3164")
+	c.Check(Name(&Automatic{}), Equals, "automatic")
+	c.Check(Name(NewChain()), Equals, "chain")
+	c.Check(Name(nil), Equals, "")
+}
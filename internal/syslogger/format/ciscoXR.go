@@ -3,16 +3,21 @@ package format
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/metajar/metalogger/internal/logger"
 	"github.com/metajar/metalogger/internal/syslogger/syslogparser"
 	"github.com/vjeantet/grok"
 	"log"
+	"strings"
 	"time"
 )
 
 var GrokParser *grok.Grok
 
+// ErrCiscoXRNoMatch is returned for messages that do not look like IOS-XR.
+var ErrCiscoXRNoMatch = errors.New("message does not match the CiscoXR pattern")
+
 func init() {
 	g, _ := grok.NewWithConfig(&grok.Config{NamedCapturesOnly: true})
 	err := g.AddPatternsFromMap(CommonPatterns)
@@ -41,17 +46,19 @@ var CommonPatterns = map[string]string{
 }
 
 type CiscoXR struct {
-	buff     []byte
-	parsed   map[string]string
-	Priority int    `json:"priority"`
-	Sequence int    `json:"sequence"`
-	Message  string `json:"message"`
-	Severity int    `json:"severity"`
-	Mnemonic string `json:"mnemonic"`
-	LogDate  string `json:"log_date"`
-	Process  string `json:"process"`
-	Category string `json:"category"`
-	Group    string `json:"group"`
+	buff       []byte
+	parsed     map[string]string
+	location   *time.Location
+	receivedAt time.Time
+	Priority   int    `json:"priority"`
+	Sequence   int    `json:"sequence"`
+	Message    string `json:"message"`
+	Severity   int    `json:"severity"`
+	Mnemonic   string `json:"mnemonic"`
+	LogDate    string `json:"log_date"`
+	Process    string `json:"process"`
+	Category   string `json:"category"`
+	Group      string `json:"group"`
 }
 
 func (f *CiscoXR) GetSplitFunc() bufio.SplitFunc {
@@ -64,6 +71,9 @@ func (f *CiscoXR) Parse() error {
 		return err
 	}
 	f.parsed = m
+	if len(m) == 0 {
+		return ErrCiscoXRNoMatch
+	}
 	return nil
 }
 
@@ -77,15 +87,83 @@ func (f *CiscoXR) Dump() syslogparser.LogParts {
 	if err != nil {
 		logger.SugarLogger.Error(err)
 	}
+	now := f.receivedAt
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if ts, ok := ParseCiscoDate(f.parsed["log_date"], f.location, now); ok {
+		m["timestamp"] = ts
+	}
 	return m
 }
 
+// Location sets the zone of timestamps without one, nil means UTC
 func (f *CiscoXR) Location(location *time.Location) {
+	if location == nil {
+		location = time.UTC
+	}
+	f.location = location
+}
 
+// ReceivedAt sets when the message was received, log dates without a year
+// get the one that puts them closest to it. Defaults to the time of parsing.
+func (f *CiscoXR) ReceivedAt(t time.Time) {
+	f.receivedAt = t
 }
 
 func NewParser(line []byte) syslogparser.LogParser {
-	return &CiscoXR{buff: line}
+	return &CiscoXR{buff: line, location: time.UTC}
+}
+
+var ciscoDateLayouts = []string{
+	"Jan _2 15:04:05.000000",
+	"Jan _2 15:04:05.000",
+	"Jan _2 15:04:05",
+	"Jan _2 2006 15:04:05.000000",
+	"Jan _2 2006 15:04:05.000",
+	"Jan _2 2006 15:04:05",
+}
+
+// ParseCiscoDate parses Cisco style dates such as "Dec 12 00:19:57.123 UTC"
+// in loc. UTC and GMT are honoured, other zone abbreviations are ambiguous
+// and ignored in favour of loc. Dates without a year get the one that puts
+// them closest to now.
+func ParseCiscoDate(s string, loc *time.Location, now time.Time) (time.Time, bool) {
+	// IOS prefixes the date with * or . when the clock is not synchronised.
+	s = strings.TrimSpace(strings.TrimLeft(s, "*."))
+	if i := strings.LastIndexByte(s, ' '); i > 0 && isZone(s[i+1:]) {
+		switch s[i+1:] {
+		case "UTC", "GMT", "Z":
+			loc = time.UTC
+		}
+		s = s[:i]
+	}
+	if loc == nil {
+		loc = time.UTC
+	}
+	for _, layout := range ciscoDateLayouts {
+		ts, err := time.ParseInLocation(layout, s, loc)
+		if err != nil {
+			continue
+		}
+		if ts.Year() == 0 {
+			ts = syslogparser.InferYear(ts, now)
+		}
+		return ts, true
+	}
+	return time.Time{}, false
+}
+
+func isZone(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
 }
 
 func (f *CiscoXR) GetParser(line []byte) LogParser {
@@ -0,0 +1,62 @@
+package format
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *FormatSuite) TestCiscoXR_Timestamp(c *C) {
+	f := CiscoXR{}
+
+	find := `<187>1234: RP/0/RSP0/CPU0:Dec 12 00:19:57.123 UTC: ifmgr[123]: %PKT_INFRA-LINK-3-UPDOWN : Interface GigabitEthernet0/0/0/1, changed state to Down`
+	parser := f.GetParser([]byte(find))
+	c.Assert(parser.Parse(), IsNil)
+	parts := parser.Dump()
+	c.Check(parts["log_date"], Equals, "Dec 12 00:19:57.123 UTC")
+	ts, ok := parts["timestamp"].(time.Time)
+	c.Assert(ok, Equals, true)
+	c.Check(ts.Location(), Equals, time.UTC)
+	c.Check(ts.Month(), Equals, time.December)
+	c.Check(ts.Nanosecond(), Equals, 123000000)
+}
+
+func (s *FormatSuite) TestCiscoXR_Location(c *C) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	c.Assert(err, IsNil)
+	f := CiscoXR{}
+
+	find := `<187>1234: RP/0/RSP0/CPU0:Dec 12 00:19:57.123 CET: ifmgr[123]: %PKT_INFRA-LINK-3-UPDOWN : Interface GigabitEthernet0/0/0/1, changed state to Down`
+	parser := f.GetParser([]byte(find))
+	parser.Location(berlin)
+	c.Assert(parser.Parse(), IsNil)
+	ts, ok := parser.Dump()["timestamp"].(time.Time)
+	c.Assert(ok, Equals, true)
+	c.Check(ts.Location(), Equals, berlin)
+	c.Check(ts.UTC().Hour(), Equals, 23)
+}
+
+func (s *FormatSuite) TestParseCiscoDate(c *C) {
+	now := time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC)
+	fixtures := map[string]time.Time{
+		"Dec 31 23:59:59 UTC":          time.Date(2022, 12, 31, 23, 59, 59, 0, time.UTC),
+		"*Jan  1 10:00:00.5 UTC":       time.Date(2023, 1, 1, 10, 0, 0, 500000000, time.UTC),
+		".Jan  1 10:00:00.500 GMT":     time.Date(2023, 1, 1, 10, 0, 0, 500000000, time.UTC),
+		"Jun 15 2021 08:00:00.000 UTC": time.Date(2021, 6, 15, 8, 0, 0, 0, time.UTC),
+		"Jun 15 08:00:00":              time.Date(2023, 6, 15, 8, 0, 0, 0, time.UTC),
+	}
+	for in, want := range fixtures {
+		ts, ok := ParseCiscoDate(in, time.UTC, now)
+		c.Check(ok, Equals, true, Commentf("date %s", in))
+		c.Check(ts.Equal(want), Equals, true, Commentf("date %s got %s", in, ts))
+	}
+	_, ok := ParseCiscoDate("yesterday", time.UTC, now)
+	c.Check(ok, Equals, false)
+}
+
+func (s *FormatSuite) TestCiscoXR_NoMatch(c *C) {
+	f := CiscoXR{}
+	parser := f.GetParser([]byte("definitely not IOS-XR"))
+	c.Check(parser.Parse(), Equals, ErrCiscoXRNoMatch)
+	c.Check(parser.Dump()["timestamp"], IsNil)
+}
@@ -13,6 +13,7 @@ type LogParser interface {
 	Parse() error
 	Dump() LogParts
 	Location(*time.Location)
+	ReceivedAt(time.Time)
 }
 
 type Format interface {
@@ -0,0 +1,99 @@
+package format
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+
+	"github.com/metajar/metalogger/internal/syslogger/syslogparser/rfc5424"
+)
+
+// DefaultRFC5425MaxMessageSize is the largest frame RFC5425 accepts unless
+// configured otherwise. The RFC requires 2048 and recommends 8192 octets.
+const DefaultRFC5425MaxMessageSize = 64 * 1024
+
+var (
+	// ErrInvalidFraming is returned for frames that do not start with a
+	// valid octet count
+	ErrInvalidFraming = errors.New("invalid octet counting frame")
+	// ErrMessageTooLarge is returned for frames larger than the maximum
+	// message size
+	ErrMessageTooLarge = errors.New("message exceeds the maximum size")
+)
+
+// RFC5425 is syslog over TLS: strict octet counting framing, unlike RFC6587
+// it does not fall back to non-transparent framing, and a maximum message
+// size. Framing errors end the connection.
+type RFC5425 struct {
+	// Format parses the framed messages, RFC5424 if nil
+	Format Format
+	// MaxMessageSize defaults to DefaultRFC5425MaxMessageSize
+	MaxMessageSize int
+}
+
+func (f *RFC5425) GetParser(line []byte) LogParser {
+	if f.Format != nil {
+		return f.Format.GetParser(line)
+	}
+	return &parserWrapper{rfc5424.NewParser(line)}
+}
+
+func (f *RFC5425) GetSplitFunc() bufio.SplitFunc {
+	return f.split
+}
+
+// BufferSize is the scanner buffer needed for the largest frame
+func (f *RFC5425) BufferSize() int {
+	max := f.maxMessageSize()
+	return max + len(strconv.Itoa(max)) + 1
+}
+
+func (f *RFC5425) maxMessageSize() int {
+	if f.MaxMessageSize > 0 {
+		return f.MaxMessageSize
+	}
+	return DefaultRFC5425MaxMessageSize
+}
+
+// split implements SYSLOG-FRAME = MSG-LEN SP SYSLOG-MSG, where MSG-LEN is a
+// non zero digit followed by digits
+func (f *RFC5425) split(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+
+	max := f.maxMessageSize()
+	digits := len(strconv.Itoa(max))
+	i := 0
+	for ; i < len(data) && data[i] >= '0' && data[i] <= '9'; i++ {
+		if i == digits {
+			return 0, nil, ErrMessageTooLarge
+		}
+	}
+	if i == len(data) {
+		if atEOF {
+			return 0, nil, ErrInvalidFraming
+		}
+		// Request more data
+		return 0, nil, nil
+	}
+	if i == 0 || data[0] == '0' || data[i] != ' ' {
+		return 0, nil, ErrInvalidFraming
+	}
+	length, err := strconv.Atoi(string(data[:i]))
+	if err != nil {
+		return 0, nil, ErrInvalidFraming
+	}
+	if length > max {
+		return 0, nil, ErrMessageTooLarge
+	}
+	end := i + 1 + length
+	if len(data) < end {
+		if atEOF {
+			return 0, nil, ErrInvalidFraming
+		}
+		// Request more data
+		return 0, nil, nil
+	}
+	return end, data[i+1 : end], nil
+}
@@ -0,0 +1,57 @@
+package format
+
+import (
+	"bufio"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func scanRFC5425(f *RFC5425, in string) ([]string, error) {
+	scanner := bufio.NewScanner(strings.NewReader(in))
+	scanner.Split(f.GetSplitFunc())
+	var frames []string
+	for scanner.Scan() {
+		frames = append(frames, scanner.Text())
+	}
+	return frames, scanner.Err()
+}
+
+func (s *FormatSuite) TestRFC5425_Split(c *C) {
+	frames, err := scanRFC5425(&RFC5425{}, "10 I am test.12 I am test 2.1 x")
+	c.Check(err, IsNil)
+	c.Check(frames, DeepEquals, []string{"I am test.", "I am test 2.", "x"})
+}
+
+func (s *FormatSuite) TestRFC5425_InvalidFraming(c *C) {
+	fixtures := map[string]error{
+		"<34>1 2003-10-11T22:14:15.003Z host app - - - hi\n": ErrInvalidFraming,
+		"010 I am test.": ErrInvalidFraming,
+		"10I am test.":   ErrInvalidFraming,
+		"10 I am":        ErrInvalidFraming,
+		"10":             ErrInvalidFraming,
+		"100000 x":       ErrMessageTooLarge,
+		"99999999999 x":  ErrMessageTooLarge,
+	}
+	for in, want := range fixtures {
+		frames, err := scanRFC5425(&RFC5425{}, "2 ok"+in)
+		c.Check(frames, DeepEquals, []string{"ok"}, Commentf("input %q", in))
+		c.Check(err, Equals, want, Commentf("input %q", in))
+	}
+
+	frames, err := scanRFC5425(&RFC5425{MaxMessageSize: 8}, "8 123456789 123456789")
+	c.Check(frames, DeepEquals, []string{"12345678"})
+	c.Check(err, Equals, ErrMessageTooLarge)
+}
+
+func (s *FormatSuite) TestRFC5425_Parser(c *C) {
+	parser := (&RFC5425{}).GetParser([]byte("<34>1 2003-10-11T22:14:15.003Z mymachine.example.com su - ID47 - hi"))
+	c.Assert(parser.Parse(), IsNil)
+	c.Check(parser.Dump()["app_name"], Equals, "su")
+
+	parser = (&RFC5425{Format: &RFC3164{}}).GetParser([]byte("<31>Dec 26 05:08:46 hostname tag[296]: content"))
+	c.Assert(parser.Parse(), IsNil)
+	c.Check(parser.Dump()["tag"], Equals, "tag")
+	c.Check((&RFC5425{}).BufferSize(), Equals, DefaultRFC5425MaxMessageSize+6)
+	c.Check(Name(&RFC5425{}), Equals, "rfc5425")
+}
@@ -0,0 +1,63 @@
+package syslog
+
+import (
+	"github.com/metajar/metalogger/internal/source"
+	"github.com/metajar/metalogger/internal/syslogger/format"
+)
+
+// FormatSelector picks the format of a source, overriding the listener and
+// server formats. hostname is empty until the message has been parsed once.
+// Select returns nil if it has no opinion.
+//
+// The hostname is the one extracted by the format picked without it, which
+// may not be the right one for the source, so a hostname selection is only
+// as trustworthy as that first parse. Prefer addresses where they suffice.
+type FormatSelector interface {
+	Select(ip, hostname string) format.Format
+}
+
+// SourceFormats selects formats by hostname, then by the longest matching
+// source network
+type SourceFormats struct {
+	m *source.Matcher
+}
+
+// NewSourceFormats returns an empty SourceFormats
+func NewSourceFormats() *SourceFormats {
+	return &SourceFormats{m: source.NewMatcher()}
+}
+
+// AddHostname Sets the format of a device by the hostname in its messages.
+// Matching is case insensitive and a short name also matches the fully
+// qualified one.
+func (sf *SourceFormats) AddHostname(hostname string, f format.Format) {
+	sf.m.AddHostname(hostname, f)
+}
+
+// AddCIDR Sets the format of devices sending from a network
+func (sf *SourceFormats) AddCIDR(cidr string, f format.Format) error {
+	return sf.m.AddCIDR(cidr, f)
+}
+
+// Select implements FormatSelector
+func (sf *SourceFormats) Select(ip, hostname string) format.Format {
+	if f, ok := sf.m.Match(ip, hostname); ok {
+		return f.(format.Format)
+	}
+	return nil
+}
+
+// SetFormatSelector Sets the selector for the format of each source
+func (s *Server) SetFormatSelector(sel FormatSelector) {
+	s.formatSelector = sel
+}
+
+// formatForSource returns the format of a source on the listener
+func (s *Server) formatForSource(info ListenerInfo, ip, hostname string) format.Format {
+	if s.formatSelector != nil {
+		if f := s.formatSelector.Select(ip, hostname); f != nil {
+			return f
+		}
+	}
+	return s.formatFor(info)
+}
@@ -0,0 +1,44 @@
+package syslog
+
+import (
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestSourceFormats(c *C) {
+	xr := &format.CiscoXR{}
+	sel := NewSourceFormats()
+	c.Assert(sel.AddCIDR("10.0.0.0/8", RFC3164), IsNil)
+	c.Assert(sel.AddCIDR("10.1.0.0/16", xr), IsNil)
+	c.Check(sel.AddCIDR("10.0.0.0/33", xr), ErrorMatches, "invalid CIDR address: 10.0.0.0/33")
+	sel.AddHostname("Core1", RFC5424)
+
+	c.Check(sel.Select("10.1.2.3", ""), Equals, xr)
+	c.Check(sel.Select("10.2.2.3", ""), Equals, RFC3164)
+	c.Check(sel.Select("10.2.2.3", "core1.example.net"), Equals, RFC5424)
+	c.Check(sel.Select("192.0.2.1", ""), IsNil)
+}
+
+func (s *ServerSuite) TestFormatSelector(c *C) {
+	sel := NewSourceFormats()
+	c.Assert(sel.AddCIDR("10.1.0.0/16", RFC5424), IsNil)
+	sel.AddHostname("hostname", format.NewChain(&format.CiscoXR{}, RFC3164))
+	handler := new(HandlerMock)
+	server := NewServer()
+	server.SetFormat(Automatic)
+	server.SetHandler(handler)
+	server.SetFormatSelector(sel)
+
+	server.parser([]byte(exampleRFC5424Syslog), origin{client: "192.0.2.1:514"})
+	c.Check(handler.LastLogParts["format"], Equals, "rfc5424")
+	c.Check(handler.LastLogParts["app_name"], Equals, "su")
+
+	server.parser([]byte(exampleRFC5424Syslog), origin{client: "10.1.0.1:514"})
+	c.Check(handler.LastLogParts["format"], Equals, "rfc5424")
+	c.Check(handler.LastLogParts["app_name"], Equals, "su")
+
+	// parsed again once the hostname is known
+	server.parser([]byte(exampleSyslog), origin{client: "192.0.2.1:514"})
+	c.Check(handler.LastLogParts["format"], Equals, "rfc3164")
+	c.Check(handler.LastLogParts["tag"], Equals, "tag")
+}
@@ -29,7 +29,10 @@ func (h *ChannelHandler) SetChannel(channel LogPartsChannel) {
 	h.channel = channel
 }
 
-//Syslog entry receiver
+//Syslog entry receiver, the parse error if any is kept in parse_error
 func (h *ChannelHandler) Handle(logParts format.LogParts, messageLength int64, err error) {
+	if err != nil {
+		logParts["parse_error"] = err.Error()
+	}
 	h.channel <- logParts
 }
@@ -1,6 +1,8 @@
 package syslog
 
 import (
+	"errors"
+
 	"github.com/metajar/metalogger/internal/syslogger/format"
 	. "gopkg.in/check.v1"
 )
@@ -17,3 +19,11 @@ func (s *HandlerSuite) TestHandle(c *C) {
 	fromChan := <-channel
 	c.Check(fromChan["tag"], Equals, logPart["tag"])
 }
+
+func (s *HandlerSuite) TestHandleKeepsError(c *C) {
+	channel := make(LogPartsChannel, 1)
+	handler := NewChannelHandler(channel)
+	handler.Handle(format.LogParts{"tag": "foo"}, 10, errors.New("No structured data"))
+	fromChan := <-channel
+	c.Check(fromChan["parse_error"], Equals, "No structured data")
+}
@@ -0,0 +1,231 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HAProxy PROXY protocol, which load balancers use to pass on the address of
+// the original client: https://www.haproxy.org/download/2.8/doc/proxy-protocol.txt
+
+var (
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+	errProxyHeader   = errors.New("invalid proxy protocol header")
+)
+
+const (
+	proxyV1MaxLength    = 107
+	proxyHeaderTimeout  = 5 * time.Second
+	proxyV2HeaderLength = 16
+)
+
+// trustedProxies are the networks allowed to send PROXY headers
+type trustedProxies []*net.IPNet
+
+func parseTrustedProxies(cidrs []string) (trustedProxies, error) {
+	var t trustedProxies
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		t = append(t, n)
+	}
+	return t, nil
+}
+
+func (t trustedProxies) trusts(addr net.Addr) bool {
+	if addr == nil {
+		return false
+	}
+	ip := net.ParseIP(clientHost(addr.String()))
+	if ip == nil {
+		return false
+	}
+	for _, n := range t {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProxyV1 parses a v1 header line such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 514\r\n". ip is nil for UNKNOWN.
+func parseProxyV1(line string) (ip net.IP, port int, err error) {
+	fields := strings.Fields(strings.TrimSuffix(line, "\r\n"))
+	if len(fields) < 2 || fields[0] != "PROXY" || !strings.HasSuffix(line, "\r\n") {
+		return nil, 0, errProxyHeader
+	}
+	switch fields[1] {
+	case "UNKNOWN":
+		return nil, 0, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, 0, errProxyHeader
+	}
+	if len(fields) != 6 {
+		return nil, 0, errProxyHeader
+	}
+	// both addresses must be of the declared family
+	for i, addr := range fields[2:4] {
+		a := net.ParseIP(addr)
+		if a == nil || strings.Contains(addr, ":") != (fields[1] == "TCP6") {
+			return nil, 0, errProxyHeader
+		}
+		if i == 0 {
+			ip = a
+		}
+	}
+	if port, err = strconv.Atoi(fields[4]); err != nil || port < 0 || port > 65535 {
+		return nil, 0, errProxyHeader
+	}
+	return ip, port, nil
+}
+
+// parseProxyV2 parses a v2 header at the start of b and returns its length.
+// ip is nil for LOCAL connections and address families other than IPv4 and
+// IPv6.
+func parseProxyV2(b []byte) (ip net.IP, port int, n int, err error) {
+	if len(b) < proxyV2HeaderLength || !bytes.HasPrefix(b, proxyV2Signature) || b[12]>>4 != 2 {
+		return nil, 0, 0, errProxyHeader
+	}
+	n = proxyV2HeaderLength + int(binary.BigEndian.Uint16(b[14:16]))
+	if len(b) < n {
+		return nil, 0, 0, errProxyHeader
+	}
+	addrs := b[proxyV2HeaderLength:n]
+	switch b[12] & 0x0f {
+	case 0x0: // LOCAL
+		return nil, 0, n, nil
+	case 0x1: // PROXY
+	default:
+		return nil, 0, 0, errProxyHeader
+	}
+	switch b[13] >> 4 {
+	case 0x1: // AF_INET
+		if len(addrs) < 12 {
+			return nil, 0, 0, errProxyHeader
+		}
+		return net.IP(addrs[0:4]).To16(), int(binary.BigEndian.Uint16(addrs[8:10])), n, nil
+	case 0x2: // AF_INET6
+		if len(addrs) < 36 {
+			return nil, 0, 0, errProxyHeader
+		}
+		return net.IP(append([]byte(nil), addrs[0:16]...)), int(binary.BigEndian.Uint16(addrs[32:34])), n, nil
+	}
+	return nil, 0, n, nil
+}
+
+// readProxyHeader reads a v1 or v2 header off r
+func readProxyHeader(r *bufio.Reader) (ip net.IP, port int, err error) {
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, 0, err
+	}
+	if bytes.Equal(sig, proxyV2Signature) {
+		header, err := r.Peek(proxyV2HeaderLength)
+		if err != nil {
+			return nil, 0, err
+		}
+		header, err = r.Peek(proxyV2HeaderLength + int(binary.BigEndian.Uint16(header[14:16])))
+		if err != nil {
+			return nil, 0, err
+		}
+		ip, port, n, err := parseProxyV2(header)
+		if err != nil {
+			return nil, 0, err
+		}
+		r.Discard(n)
+		return ip, port, nil
+	}
+	if !bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return nil, 0, errProxyHeader
+	}
+	var line []byte
+	for len(line) < proxyV1MaxLength {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, 0, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			return parseProxyV1(string(line))
+		}
+	}
+	return nil, 0, errProxyHeader
+}
+
+// proxyListener expects a PROXY header on the connections from trusted
+// proxies, other connections are passed on as they are
+type proxyListener struct {
+	net.Listener
+	trusted trustedProxies
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil || !l.trusted.trusts(conn.RemoteAddr()) {
+		return conn, err
+	}
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn reads the PROXY header on first use, so a slow proxy does not
+// hold up Accept. Reads fail if the header is invalid.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	once   sync.Once
+	source net.Addr
+	err    error
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		ip, port, err := readProxyHeader(c.r)
+		c.Conn.SetReadDeadline(time.Time{})
+		if err != nil {
+			c.err = errProxyHeader
+			return
+		}
+		if ip != nil {
+			c.source = &net.TCPAddr{IP: ip, Port: port}
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+// RemoteAddr is the client the proxy forwarded
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// proxyConnOf returns the proxyConn under a connection, if any
+func proxyConnOf(conn net.Conn) *proxyConn {
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	p, _ := conn.(*proxyConn)
+	return p
+}
@@ -0,0 +1,198 @@
+package syslog
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+// proxyV2Header builds a v2 PROXY header for an IPv4 or IPv6 source
+func proxyV2Header(src *net.TCPAddr, dgram bool) []byte {
+	ip, fam, dst := src.IP.To4(), byte(0x10), net.IPv4(198, 51, 100, 1).To4()
+	if ip == nil {
+		ip, fam, dst = src.IP.To16(), 0x20, net.IPv6loopback
+	}
+	if dgram {
+		fam |= 0x2
+	} else {
+		fam |= 0x1
+	}
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports, uint16(src.Port))
+	binary.BigEndian.PutUint16(ports[2:], 514)
+	addrs := append(append(append([]byte{}, ip...), dst...), ports...)
+	header := append(append([]byte{}, proxyV2Signature...), 0x21, fam, 0, byte(len(addrs)))
+	return append(header, addrs...)
+}
+
+func (s *ServerSuite) TestParseProxyHeader(c *C) {
+	fixtures := map[string]string{
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324 514\r\n":  "192.0.2.1:56324",
+		"PROXY TCP6 2001:db8::1 2001:db8::2 56324 514\r\n": "[2001:db8::1]:56324",
+		"PROXY UNKNOWN\r\n": "",
+		string(proxyV2Header(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 56324}, false)):   "192.0.2.1:56324",
+		string(proxyV2Header(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 56324}, false)): "[2001:db8::1]:56324",
+	}
+	for in, want := range fixtures {
+		r := bufio.NewReader(strings.NewReader(in + "rest"))
+		ip, port, err := readProxyHeader(r)
+		c.Assert(err, IsNil, Commentf("header %q", in))
+		if want == "" {
+			c.Check(ip, IsNil)
+		} else {
+			c.Check((&net.TCPAddr{IP: ip, Port: port}).String(), Equals, want)
+		}
+		rest, _ := r.ReadString('\n')
+		c.Check(rest, Equals, "rest")
+	}
+
+	for _, in := range []string{
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324 514\n",
+		"PROXY UDP4 192.0.2.1 198.51.100.1 56324 514\r\n",
+		"PROXY TCP4 2001:db8::1 198.51.100.1 56324 514\r\n",
+		"PROXY TCP4 192.0.2.1 2001:db8::2 56324 514\r\n",
+		"PROXY TCP6 192.0.2.1 2001:db8::2 56324 514\r\n",
+		"PROXY TCP6 2001:db8::1 198.51.100.1 56324 514\r\n",
+		"PROXY TCP6 2001:db8::1 nowhere 56324 514\r\n",
+		"PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+		"<34>1 2003-10-11T22:14:15.003Z host app - - - hi\n",
+	} {
+		_, _, err := readProxyHeader(bufio.NewReader(strings.NewReader(in)))
+		c.Check(err, Equals, errProxyHeader, Commentf("header %q", in))
+	}
+
+	local := append(append([]byte{}, proxyV2Signature...), 0x20, 0x00, 0, 0)
+	ip, _, n, err := parseProxyV2(append(local, "message"...))
+	c.Check(err, IsNil)
+	c.Check(ip, IsNil)
+	c.Check(n, Equals, 16)
+	_, _, _, err = parseProxyV2(proxyV2Header(&net.TCPAddr{IP: net.ParseIP("192.0.2.1")}, true)[:20])
+	c.Check(err, Equals, errProxyHeader)
+}
+
+func (s *ServerSuite) TestProxyProtocol(c *C) {
+	ca := newTestCert(c, nil, "ca")
+	files := writeTLSFiles(c, c.MkDir(), newTestCert(c, ca, "syslog", "syslog.example.net"), ca)
+	handler := &handlerCollector{parts: make(chan format.LogParts, 4)}
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(handler)
+	trusted := []string{"127.0.0.0/8"}
+	c.Assert(server.Listen(ListenerConfig{Name: "tcp", Protocol: "tcp", Address: "127.0.0.1:0", TrustedProxies: trusted}), IsNil)
+	c.Assert(server.Listen(ListenerConfig{Name: "tls", Protocol: "tls", Address: "127.0.0.1:0", TLSFiles: &files, TrustedProxies: trusted}), IsNil)
+	c.Assert(server.Listen(ListenerConfig{Name: "udp", Protocol: "udp", Address: "127.0.0.1:0", TrustedProxies: trusted}), IsNil)
+	c.Check(server.Listen(ListenerConfig{Protocol: "tcp", Address: "127.0.0.1:0", TrustedProxies: []string{"nope"}}), ErrorMatches, "invalid CIDR address: nope")
+	c.Assert(server.Boot(), IsNil)
+
+	conn, err := net.Dial("tcp", server.listeners[0].Addr().String())
+	c.Assert(err, IsNil)
+	conn.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 514\r\n" + exampleSyslog + "\n"))
+	conn.Close()
+
+	conn, err = net.Dial("tcp", server.listeners[1].Addr().String())
+	c.Assert(err, IsNil)
+	conn.Write(proxyV2Header(&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000}, false))
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	tlsConn := tls.Client(conn, &tls.Config{
+		RootCAs:      roots,
+		ServerName:   "syslog.example.net",
+		Certificates: []tls.Certificate{newTestCert(c, ca, "web1").tlsCertificate(c)},
+	})
+	tlsConn.Write([]byte(exampleSyslog + "\n"))
+	tlsConn.Close()
+
+	udp, err := net.Dial("udp", server.connections[0].LocalAddr().String())
+	c.Assert(err, IsNil)
+	udp.Write(append(proxyV2Header(&net.TCPAddr{IP: net.ParseIP("192.0.2.3"), Port: 514}, true), exampleSyslog...))
+	// datagrams from trusted proxies without a header are dropped
+	udp.Write([]byte(exampleSyslog))
+	udp.Close()
+
+	byListener := map[string]format.LogParts{}
+	for i := 0; i < 3; i++ {
+		select {
+		case parts := <-handler.parts:
+			byListener[parts["listener"].(string)] = parts
+		case <-time.After(2 * time.Second):
+			c.Fatal("timed out waiting for messages")
+		}
+	}
+	select {
+	case parts := <-handler.parts:
+		c.Errorf("unexpected message %v", parts)
+	case <-time.After(100 * time.Millisecond):
+	}
+	server.Kill()
+	server.Wait()
+
+	c.Check(byListener["tcp"]["client"], Equals, "192.0.2.1:56324")
+	c.Check(byListener["tcp"]["proxy"], Matches, `127\.0\.0\.1:\d+`)
+	c.Check(byListener["tls"]["client"], Equals, "[2001:db8::1]:40000")
+	c.Check(byListener["tls"]["tls_peer"], Equals, "web1")
+	c.Check(byListener["udp"]["client"], Equals, "192.0.2.3:514")
+	c.Check(byListener["udp"]["content"], Equals, "content")
+}
+
+func (s *ServerSuite) TestSilentConnectionDoesNotBlockAccept(c *C) {
+	ca := newTestCert(c, nil, "ca")
+	files := writeTLSFiles(c, c.MkDir(), newTestCert(c, ca, "syslog", "syslog.example.net"), ca)
+	handler := &handlerCollector{parts: make(chan format.LogParts, 2)}
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(handler)
+	trusted := []string{"127.0.0.0/8"}
+	c.Assert(server.Listen(ListenerConfig{Name: "tcp", Protocol: "tcp", Address: "127.0.0.1:0", TrustedProxies: trusted}), IsNil)
+	c.Assert(server.Listen(ListenerConfig{Name: "tls", Protocol: "tls", Address: "127.0.0.1:0", TLSFiles: &files}), IsNil)
+	c.Assert(server.Boot(), IsNil)
+
+	// neither sends a PROXY header nor starts the handshake
+	var silent []net.Conn
+	for _, l := range server.listeners {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		c.Assert(err, IsNil)
+		silent = append(silent, conn)
+	}
+
+	conn, err := net.Dial("tcp", server.listeners[0].Addr().String())
+	c.Assert(err, IsNil)
+	conn.Write([]byte("PROXY TCP4 192.0.2.1 198.51.100.1 56324 514\r\n" + exampleSyslog + "\n"))
+	conn.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	dialer := &net.Dialer{Timeout: 2 * time.Second}
+	tlsConn, err := tls.DialWithDialer(dialer, "tcp", server.listeners[1].Addr().String(), &tls.Config{
+		RootCAs:      roots,
+		ServerName:   "syslog.example.net",
+		Certificates: []tls.Certificate{newTestCert(c, ca, "web1").tlsCertificate(c)},
+	})
+	c.Assert(err, IsNil)
+	tlsConn.Write([]byte(exampleSyslog + "\n"))
+	tlsConn.Close()
+
+	byListener := map[string]format.LogParts{}
+	for i := 0; i < 2; i++ {
+		select {
+		case parts := <-handler.parts:
+			byListener[parts["listener"].(string)] = parts
+		case <-time.After(2 * time.Second):
+			c.Fatal("timed out waiting for messages behind the silent connections")
+		}
+	}
+	for _, conn := range silent {
+		conn.Close()
+	}
+	server.Kill()
+	server.Wait()
+	c.Check(byListener["tcp"]["client"], Equals, "192.0.2.1:56324")
+	c.Check(byListener["tls"]["tls_peer"], Equals, "web1")
+}
@@ -0,0 +1,111 @@
+package syslog
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// mmsghdr is struct mmsghdr from sys/socket.h
+type mmsghdr struct {
+	hdr unix.Msghdr
+	len uint32
+}
+
+// batchReader reads up to len(bufs) datagrams per recvmmsg call
+type batchReader struct {
+	conn  syscall.RawConn
+	bufs  [][]byte
+	sizes []int
+	addrs []net.Addr
+	// oobs are the control messages of each datagram, with SO_RXQ_OVFL
+	oobs [][]byte
+
+	hdrs  []mmsghdr
+	iovs  []unix.Iovec
+	names []unix.RawSockaddrAny
+	ctrls [][]byte
+}
+
+func newBatchReader(conn net.PacketConn, size int) (*batchReader, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("batch reads need a socket")
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	r := &batchReader{
+		conn:  raw,
+		bufs:  make([][]byte, size),
+		sizes: make([]int, size),
+		addrs: make([]net.Addr, size),
+		oobs:  make([][]byte, size),
+		hdrs:  make([]mmsghdr, size),
+		iovs:  make([]unix.Iovec, size),
+		names: make([]unix.RawSockaddrAny, size),
+		ctrls: make([][]byte, size),
+	}
+	for i := range r.bufs {
+		r.bufs[i] = make([]byte, datagramReadBufferSize)
+		r.iovs[i].Base = &r.bufs[i][0]
+		r.iovs[i].SetLen(len(r.bufs[i]))
+		r.hdrs[i].hdr.Iov = &r.iovs[i]
+		r.hdrs[i].hdr.SetIovlen(1)
+		r.hdrs[i].hdr.Name = (*byte)(unsafe.Pointer(&r.names[i]))
+		r.ctrls[i] = make([]byte, overflowOOBSize)
+		r.hdrs[i].hdr.Control = &r.ctrls[i][0]
+	}
+	return r, nil
+}
+
+// read fills bufs, sizes and addrs with the next batch and returns its length
+func (r *batchReader) read() (int, error) {
+	for i := range r.hdrs {
+		r.hdrs[i].hdr.Namelen = unix.SizeofSockaddrAny
+		r.hdrs[i].hdr.SetControllen(len(r.ctrls[i]))
+		r.hdrs[i].len = 0
+	}
+	var n int
+	var errno syscall.Errno
+	err := r.conn.Read(func(fd uintptr) bool {
+		r1, _, e := unix.Syscall6(unix.SYS_RECVMMSG, fd, uintptr(unsafe.Pointer(&r.hdrs[0])), uintptr(len(r.hdrs)), 0, 0, 0)
+		if e == unix.EAGAIN || e == unix.EWOULDBLOCK {
+			return false
+		}
+		n, errno = int(r1), e
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, &net.OpError{Op: "recvmmsg", Net: "udp", Err: errno}
+	}
+	for i := 0; i < n; i++ {
+		r.sizes[i] = int(r.hdrs[i].len)
+		r.addrs[i] = sockaddrToUDP(&r.names[i])
+		r.oobs[i] = r.ctrls[i][:r.hdrs[i].hdr.Controllen]
+	}
+	return n, nil
+}
+
+func sockaddrToUDP(sa *unix.RawSockaddrAny) net.Addr {
+	switch sa.Addr.Family {
+	case unix.AF_INET:
+		sa4 := (*unix.RawSockaddrInet4)(unsafe.Pointer(sa))
+		p := (*[2]byte)(unsafe.Pointer(&sa4.Port))
+		return &net.UDPAddr{IP: net.IPv4(sa4.Addr[0], sa4.Addr[1], sa4.Addr[2], sa4.Addr[3]), Port: int(p[0])<<8 | int(p[1])}
+	case unix.AF_INET6:
+		sa6 := (*unix.RawSockaddrInet6)(unsafe.Pointer(sa))
+		p := (*[2]byte)(unsafe.Pointer(&sa6.Port))
+		ip := make(net.IP, net.IPv6len)
+		copy(ip, sa6.Addr[:])
+		return &net.UDPAddr{IP: ip, Port: int(p[0])<<8 | int(p[1])}
+	}
+	return nil
+}
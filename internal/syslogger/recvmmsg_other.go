@@ -0,0 +1,34 @@
+//go:build !linux
+// +build !linux
+
+package syslog
+
+import "net"
+
+// batchReader reads one datagram at a time where recvmmsg is not available
+type batchReader struct {
+	conn  net.PacketConn
+	bufs  [][]byte
+	sizes []int
+	addrs []net.Addr
+	oobs  [][]byte
+}
+
+func newBatchReader(conn net.PacketConn, size int) (*batchReader, error) {
+	return &batchReader{
+		conn:  conn,
+		bufs:  [][]byte{make([]byte, datagramReadBufferSize)},
+		sizes: make([]int, 1),
+		addrs: make([]net.Addr, 1),
+		oobs:  make([][]byte, 1),
+	}, nil
+}
+
+func (r *batchReader) read() (int, error) {
+	n, addr, err := r.conn.ReadFrom(r.bufs[0])
+	if err != nil {
+		return 0, err
+	}
+	r.sizes[0], r.addrs[0] = n, addr
+	return 1, nil
+}
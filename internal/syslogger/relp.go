@@ -0,0 +1,235 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/metajar/metalogger/internal/logger"
+)
+
+// RELP, the Reliable Event Logging Protocol of rsyslog: every frame is
+// acknowledged once the handler has the message, so senders can resend what
+// was not acknowledged when a connection breaks.
+//
+//	RELP-FRAME = TXNR SP COMMAND SP DATALEN [SP DATA] TRAILER
+//	RSP-FRAME  = TXNR SP "rsp" SP DATALEN SP RSP-CODE [SP HUMANMSG] [LF CMDDATA] TRAILER
+const (
+	relpDefaultWindow = 128
+	relpMaxDataLen    = 128 * 1024
+	relpMaxTxnr       = 999999999
+	relpSoftware      = "metalogger"
+)
+
+var (
+	errRELPFrame    = errors.New("invalid relp frame")
+	errRELPTooLarge = errors.New("relp frame exceeds the maximum size")
+)
+
+// relpFrame is a command frame, or the error that ended the session
+type relpFrame struct {
+	txnr    int
+	command string
+	data    []byte
+	err     error
+}
+
+// readRELPFrame reads the next frame
+func readRELPFrame(r *bufio.Reader) (relpFrame, error) {
+	var f relpFrame
+	txnr, err := readRELPField(r, 9)
+	if err != nil {
+		return f, err
+	}
+	if f.txnr, err = strconv.Atoi(txnr); err != nil {
+		return f, errRELPFrame
+	}
+	if f.command, err = readRELPField(r, 32); err != nil {
+		return f, err
+	}
+	datalen, err := readRELPField(r, 9)
+	if err != nil {
+		return f, err
+	}
+	n, err := strconv.Atoi(datalen)
+	if err != nil {
+		return f, errRELPFrame
+	}
+	if n > relpMaxDataLen {
+		return f, errRELPTooLarge
+	}
+	if n > 0 {
+		f.data = make([]byte, n)
+		if _, err := io.ReadFull(r, f.data); err != nil {
+			return f, err
+		}
+	}
+	trailer, err := r.ReadByte()
+	if err != nil {
+		return f, err
+	}
+	if trailer != '\n' {
+		return f, errRELPFrame
+	}
+	return f, nil
+}
+
+// readRELPField reads up to max bytes terminated by SP, or by LF after
+// DATALEN 0, which is left unread
+func readRELPField(r *bufio.Reader, max int) (string, error) {
+	var field []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && len(field) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return "", err
+		}
+		switch {
+		case b == ' ' && len(field) > 0:
+			return string(field), nil
+		case b == '\n' && string(field) == "0":
+			r.UnreadByte()
+			return "0", nil
+		case b == ' ' || b == '\n' || len(field) == max:
+			return "", errRELPFrame
+		}
+		field = append(field, b)
+	}
+}
+
+func writeRELPResponse(w io.Writer, txnr int, data string) error {
+	_, err := fmt.Fprintf(w, "%d rsp %d %s\n", txnr, len(data), data)
+	return err
+}
+
+// writeRELPServerClose tells the client the server is ending the session
+func writeRELPServerClose(w io.Writer) error {
+	_, err := fmt.Fprint(w, "0 serverclose 0\n")
+	return err
+}
+
+// goServeRELP serves a RELP session. The connection is read ahead of the
+// acknowledgements by up to the listener's window of frames, after which
+// reading stops until the handler catches up.
+func (s *Server) goServeRELP(connection net.Conn, o origin) {
+	window := o.listener.relpWindow
+	if window <= 0 {
+		window = relpDefaultWindow
+	}
+	frames := make(chan relpFrame, window)
+	done := make(chan struct{})
+
+	s.wait.Add(2)
+	go s.readRELP(connection, o, frames, done)
+	go s.ackRELP(connection, o, frames, done)
+}
+
+// readRELP reads frames until the connection ends, a protocol error or
+// ackRELP is done
+func (s *Server) readRELP(connection net.Conn, o origin, frames chan<- relpFrame, done <-chan struct{}) {
+	defer s.wait.Done()
+	defer close(frames)
+	send := func(f relpFrame) bool {
+		select {
+		case frames <- f:
+			return true
+		case <-done:
+			return false
+		}
+	}
+	r := bufio.NewReader(connection)
+	next := 0
+	for {
+		if o.listener.timeout > 0 {
+			connection.SetReadDeadline(time.Now().Add(o.listener.timeout))
+		} else if s.readTimeoutMilliseconds > 0 {
+			connection.SetReadDeadline(time.Now().Add(time.Duration(s.readTimeoutMilliseconds) * time.Millisecond))
+		}
+		f, err := readRELPFrame(r)
+		if err != nil {
+			select {
+			case <-done:
+				// ackRELP closed the connection
+			default:
+				if err != io.EOF {
+					send(relpFrame{txnr: f.txnr, err: err})
+				}
+			}
+			return
+		}
+		// transaction numbers start at 1 and wrap to 1
+		if next != 0 && f.txnr != next {
+			send(relpFrame{txnr: f.txnr, err: fmt.Errorf("relp transaction %d out of order, expected %d", f.txnr, next)})
+			return
+		}
+		if next = f.txnr + 1; next > relpMaxTxnr {
+			next = 1
+		}
+		if !send(f) || f.command == "close" {
+			return
+		}
+	}
+}
+
+// ackRELP handles the frames and writes the responses, it owns the write side
+// of the connection and closes it, which also stops readRELP. When the server
+// is killed the frames not yet acknowledged are left for the client to resend.
+func (s *Server) ackRELP(connection net.Conn, o origin, frames <-chan relpFrame, done chan<- struct{}) {
+	defer s.wait.Done()
+	defer connection.Close()
+	defer close(done)
+	open := false
+	for {
+		var f relpFrame
+		select {
+		case <-s.doneTcp:
+			writeRELPServerClose(connection)
+			return
+		case next, ok := <-frames:
+			if !ok {
+				return
+			}
+			f = next
+		}
+		var err error
+		switch {
+		case f.err != nil:
+			logger.SugarLogger.Warnw("closing relp session", "listener", o.listener.Name, "client", o.client, "error", f.err)
+			writeRELPResponse(connection, f.txnr, "500 "+f.err.Error())
+			writeRELPServerClose(connection)
+			return
+		case f.command == "open":
+			open = true
+			err = writeRELPResponse(connection, f.txnr, "200 OK\nrelp_version=0\nrelp_software="+relpSoftware+"\ncommands=syslog")
+		case !open:
+			writeRELPResponse(connection, f.txnr, "500 session not open")
+			writeRELPServerClose(connection)
+			return
+		case f.command == "syslog":
+			if !s.allow(o.client) {
+				// not acknowledged, so the client holds on to it and retries
+				err = writeRELPResponse(connection, f.txnr, "500 rate limited")
+				break
+			}
+			o.receivedAt = time.Now()
+			o.length = len(f.data)
+			s.parser(bytes.TrimRight(f.data, "\n"), o)
+			err = writeRELPResponse(connection, f.txnr, "200 OK")
+		case f.command == "close":
+			fmt.Fprintf(connection, "%d rsp 0\n", f.txnr)
+			return
+		default:
+			err = writeRELPResponse(connection, f.txnr, "500 command not supported")
+		}
+		if err != nil {
+			return
+		}
+	}
+}
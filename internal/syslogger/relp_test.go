@@ -0,0 +1,178 @@
+package syslog
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestReadRELPFrame(c *C) {
+	r := bufio.NewReader(strings.NewReader("1 open 5 hello\n2 close 0\n3 syslog 10 short\n"))
+	f, err := readRELPFrame(r)
+	c.Assert(err, IsNil)
+	c.Check(f.txnr, Equals, 1)
+	c.Check(f.command, Equals, "open")
+	c.Check(string(f.data), Equals, "hello")
+	f, err = readRELPFrame(r)
+	c.Assert(err, IsNil)
+	c.Check(f.command, Equals, "close")
+	c.Check(f.data, IsNil)
+	_, err = readRELPFrame(r)
+	c.Check(err, NotNil)
+
+	for _, in := range []string{"x open 0\n", "1  open 0\n", "1 open 5 hello!", "1 open 0x\n", "1234567890 open 0\n"} {
+		_, err := readRELPFrame(bufio.NewReader(strings.NewReader(in)))
+		c.Check(err, Equals, errRELPFrame, Commentf("frame %q", in))
+	}
+	_, err = readRELPFrame(bufio.NewReader(strings.NewReader("1 syslog 999999 x")))
+	c.Check(err, Equals, errRELPTooLarge)
+}
+
+// relpClient speaks RELP to a server and returns its responses
+type relpClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (r *relpClient) send(txnr int, command, data string) {
+	if data == "" {
+		fmt.Fprintf(r.conn, "%d %s 0\n", txnr, command)
+		return
+	}
+	fmt.Fprintf(r.conn, "%d %s %d %s\n", txnr, command, len(data), data)
+}
+
+func (r *relpClient) response(c *C) string {
+	r.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	f, err := readRELPFrame(r.r)
+	c.Assert(err, IsNil)
+	return fmt.Sprintf("%d %s %s", f.txnr, f.command, f.data)
+}
+
+func (s *ServerSuite) TestRELP(c *C) {
+	handler := &handlerCollector{parts: make(chan format.LogParts, 2)}
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(handler)
+	c.Assert(server.Listen(ListenerConfig{Name: "relp", Protocol: "relp", Address: "127.0.0.1:0", RELPWindow: 1}), IsNil)
+	c.Assert(server.Boot(), IsNil)
+	addr := server.listeners[0].Addr().String()
+
+	conn, err := net.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	client := &relpClient{conn, bufio.NewReader(conn)}
+	client.send(1, "open", "relp_version=0\nrelp_software=test\ncommands=syslog")
+	client.send(2, "syslog", exampleSyslog)
+	client.send(3, "syslog", exampleSyslog+"\n")
+	client.send(4, "close", "")
+	c.Check(client.response(c), Equals, "1 rsp 200 OK\nrelp_version=0\nrelp_software=metalogger\ncommands=syslog")
+	c.Check(client.response(c), Equals, "2 rsp 200 OK")
+	c.Check(client.response(c), Equals, "3 rsp 200 OK")
+	c.Check(client.response(c), Equals, "4 rsp ")
+	conn.Close()
+	for i := 0; i < 2; i++ {
+		parts := <-handler.parts
+		c.Check(parts["listener"], Equals, "relp")
+		c.Check(parts["content"], Equals, "content")
+	}
+
+	// sessions must be opened and transactions numbered in order
+	conn, err = net.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	client = &relpClient{conn, bufio.NewReader(conn)}
+	client.send(1, "syslog", exampleSyslog)
+	c.Check(client.response(c), Equals, "1 rsp 500 session not open")
+	c.Check(client.response(c), Equals, "0 serverclose ")
+	conn.Close()
+
+	conn, err = net.Dial("tcp", addr)
+	c.Assert(err, IsNil)
+	client = &relpClient{conn, bufio.NewReader(conn)}
+	client.send(1, "open", "relp_version=0")
+	client.send(3, "syslog", exampleSyslog)
+	c.Check(client.response(c), Matches, "(?s)1 rsp 200 OK.*")
+	c.Check(client.response(c), Equals, "3 rsp 500 relp transaction 3 out of order, expected 2")
+	c.Check(client.response(c), Equals, "0 serverclose ")
+	conn.Close()
+
+	server.Kill()
+	server.Wait()
+	select {
+	case parts := <-handler.parts:
+		c.Errorf("unexpected message %v", parts)
+	default:
+	}
+}
+
+func (s *ServerSuite) TestRELPRateLimitAndKill(c *C) {
+	handler := &handlerCollector{parts: make(chan format.LogParts, 2)}
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(handler)
+	server.SetRateLimiter(&limiterMock{allowed: 1})
+	c.Assert(server.Listen(ListenerConfig{Protocol: "relp", Address: "127.0.0.1:0"}), IsNil)
+	c.Assert(server.Boot(), IsNil)
+
+	conn, err := net.Dial("tcp", server.listeners[0].Addr().String())
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	client := &relpClient{conn, bufio.NewReader(conn)}
+	client.send(1, "open", "relp_version=0")
+	client.send(2, "syslog", exampleSyslog)
+	client.send(3, "syslog", exampleSyslog)
+	c.Check(client.response(c), Matches, "(?s)1 rsp 200 OK.*")
+	c.Check(client.response(c), Equals, "2 rsp 200 OK")
+	c.Check(client.response(c), Equals, "3 rsp 500 rate limited")
+	c.Check((<-handler.parts)["content"], Equals, "content")
+
+	// the session is ended without waiting for the client
+	server.Kill()
+	c.Check(client.response(c), Equals, "0 serverclose ")
+	_, err = client.r.ReadByte()
+	c.Check(err, Equals, io.EOF)
+	server.Wait()
+	select {
+	case parts := <-handler.parts:
+		c.Errorf("unexpected message %v", parts)
+	default:
+	}
+}
+
+func (s *ServerSuite) TestRELPTLS(c *C) {
+	ca := newTestCert(c, nil, "ca")
+	files := writeTLSFiles(c, c.MkDir(), newTestCert(c, ca, "syslog", "syslog.example.net"), ca)
+	handler := &handlerCollector{parts: make(chan format.LogParts, 1)}
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(handler)
+	c.Assert(server.Listen(ListenerConfig{Protocol: "relp", Address: "127.0.0.1:0", TLSFiles: &files}), IsNil)
+	c.Assert(server.Boot(), IsNil)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	conn, err := tls.Dial("tcp", server.listeners[0].Addr().String(), &tls.Config{
+		RootCAs:      roots,
+		ServerName:   "syslog.example.net",
+		Certificates: []tls.Certificate{newTestCert(c, ca, "web1", "web1.example.net").tlsCertificate(c)},
+	})
+	c.Assert(err, IsNil)
+	client := &relpClient{conn, bufio.NewReader(conn)}
+	client.send(1, "open", "relp_version=0")
+	client.send(2, "syslog", exampleSyslog)
+	c.Check(client.response(c), Matches, "(?s)1 rsp 200 OK.*")
+	c.Check(client.response(c), Equals, "2 rsp 200 OK")
+	parts := <-handler.parts
+	c.Check(parts["tls_peer"], Equals, "web1")
+	c.Check(parts["listener_protocol"], Equals, "relp")
+	conn.Close()
+	server.Kill()
+	server.Wait()
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package syslog
+
+import (
+	"errors"
+	"net"
+)
+
+// listenReusePort is only supported on Linux
+func listenReusePort(addr string) (*net.UDPConn, error) {
+	return nil, errors.New("udp workers are only supported on linux")
+}
@@ -4,11 +4,15 @@ import (
 	"bufio"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/metajar/metalogger/internal/logger"
+	"github.com/metajar/metalogger/internal/metrics/prometheus"
 	"github.com/metajar/metalogger/internal/syslogger/format"
 )
 
@@ -16,21 +20,144 @@ var (
 	RFC3164   = &format.RFC3164{}   // RFC3164: http://www.ietf.org/rfc/rfc3164.txt
 	RFC5424   = &format.RFC5424{}   // RFC5424: http://www.ietf.org/rfc/rfc5424.txt
 	RFC6587   = &format.RFC6587{}   // RFC6587: http://www.ietf.org/rfc/rfc6587.txt - octet counting variant
+	RFC5425   = &format.RFC5425{}   // RFC5425: http://www.ietf.org/rfc/rfc5425.txt - strict octet counting over TLS
 	Automatic = &format.Automatic{} // Automatically identify the format
 )
 
 const (
 	datagramChannelBufferSize = 10
 	datagramReadBufferSize    = 64 * 1024
+	tlsHandshakeTimeout       = 10 * time.Second
 )
 
 // A function type which gets the TLS peer name from the connection. Can return
 // ok=false to terminate the connection
 type TlsPeerNameFunc func(tlsConn *tls.Conn) (tlsPeer string, ok bool)
 
+// RateLimiter decides if a message from the given source IP may be parsed.
+type RateLimiter interface {
+	Allow(key string) bool
+}
+
+// TimestampAction decides what happens to a timestamp outside the policy
+type TimestampAction int
+
+const (
+	// TimestampKeep only records the clock skew
+	TimestampKeep TimestampAction = iota
+	// TimestampFlag sets timestamp_suspect
+	TimestampFlag
+	// TimestampReplace sets timestamp_suspect, moves the timestamp to
+	// timestamp_original and uses the receive time instead
+	TimestampReplace
+)
+
+// TimestampPolicy bounds how far a device timestamp may be ahead of or behind
+// the receive time, zero means unbounded
+type TimestampPolicy struct {
+	MaxFuture time.Duration
+	MaxPast   time.Duration
+	Action    TimestampAction
+}
+
+// ListenerInfo identifies the listener a message arrived on
+type ListenerInfo struct {
+	Name     string
+	Protocol string
+	Address  string
+
+	// per listener overrides, zero values use the server settings
+	format           format.Format
+	timeout          time.Duration
+	authorizer       *TLSAuthorizer
+	relpWindow       int
+	proxies          trustedProxies
+	batchSize        int
+	socket           *udpSocket
+	acl              *ACL
+	parseErrorAction ParseErrorAction
+}
+
+func newListenerInfo(name, protocol string, addr net.Addr) ListenerInfo {
+	info := ListenerInfo{Name: name, Protocol: protocol}
+	if addr != nil {
+		info.Address = addr.String()
+	}
+	if info.Name == "" {
+		info.Name = protocol + "://" + info.Address
+	}
+	return info
+}
+
+// ListenerConfig describes a listener for Listen
+type ListenerConfig struct {
+	// Name identifies the listener in messages and metrics, defaults to
+	// protocol://address
+	Name string
+	// Protocol is one of udp, tcp, tls, rfc5425, relp, unix or unixgram.
+	// rfc5425 is tls that defaults to the RFC5425 format and
+	// TLSPolicyIntermediate. relp is over TLS if a TLS config is set.
+	Protocol string
+	Address  string
+	// Format overrides the server format
+	Format format.Format
+	// TLSConfig or TLSFiles is required for tls. With both, the
+	// certificates from the files are added to TLSConfig.
+	TLSConfig *tls.Config
+	TLSFiles  *TLSFiles
+	TLSPolicy TLSPolicy
+	// Authorizer rejects the tls clients none of its rules match
+	Authorizer *TLSAuthorizer
+	// SocketSize overrides the read buffer size of udp and unixgram sockets
+	SocketSize int
+	// Timeout overrides the read timeout of stream connections
+	Timeout time.Duration
+	// RELPWindow is how many relp frames may be outstanding before the
+	// server stops reading, 128 by default
+	RELPWindow int
+	// Workers opens that many udp sockets on the address with SO_REUSEPORT,
+	// on Linux only. Each is read in batches of BatchSize, 32 by default,
+	// and parsed by its own goroutine instead of the shared datagram
+	// channel.
+	Workers   int
+	BatchSize int
+	// TrustedProxies enables the PROXY protocol, v1 and v2 on stream
+	// listeners and v2 on udp, for connections and datagrams from these
+	// networks. They must send a header, others must not.
+	TrustedProxies []string
+	// ACL restricts the sources datagrams and connections are accepted
+	// from, the original sources with the PROXY protocol
+	ACL *ACL
+	// ParseErrorAction overrides the server policy for messages that fail
+	// to parse
+	ParseErrorAction ParseErrorAction
+}
+
+// origin describes where and when a message arrived
+type origin struct {
+	client     string
+	tlsPeer    string
+	proxy      string
+	identity   *ClientIdentity
+	listener   ListenerInfo
+	receivedAt time.Time
+	// length is the size of the message as read, framing and trailers
+	// included
+	length int
+}
+
+// LocationResolver picks the time zone of a device for timestamps without an
+// offset. hostname is empty until the message has been parsed once. A nil
+// location means the zone is unknown, the timestamp is then taken as UTC.
+type LocationResolver interface {
+	Resolve(ip, hostname string) *time.Location
+}
+
 type Server struct {
 	listeners               []net.Listener
+	listenerInfos           []ListenerInfo
 	connections             []net.PacketConn
+	connectionInfos         []ListenerInfo
 	socketSize              int
 	wait                    sync.WaitGroup
 	doneTcp                 chan bool
@@ -38,15 +165,28 @@ type Server struct {
 	datagramChannel         chan DatagramMessage
 	format                  format.Format
 	handler                 Handler
-	lastError               error
 	readTimeoutMilliseconds int64
 	tlsPeerNameFunc         TlsPeerNameFunc
 	datagramPool            sync.Pool
+	rateLimiter             RateLimiter
+	locationResolver        LocationResolver
+	timestampPolicy         TimestampPolicy
+	keepRaw                 bool
+	parseErrorAction        ParseErrorAction
+	quarantine              QuarantineWriter
+	quarantineQueue         chan format.LogParts
+	failures                *failureRing
+	lastErrorMu             sync.Mutex
+	lastError               error
+	formatSelector          FormatSelector
+	reloaders               []*CertReloader
+	udpSockets              []*udpSocket
+	socketStatsInterval     time.Duration
 }
 
 //NewServer returns a new Server
 func NewServer() *Server {
-	return &Server{tlsPeerNameFunc: defaultTlsPeerName, datagramPool: sync.Pool{
+	return &Server{tlsPeerNameFunc: defaultTlsPeerName, failures: &failureRing{failures: make([]Failure, 100)}, datagramPool: sync.Pool{
 		New: func() interface{} {
 			return make([]byte, 65536)
 		},
@@ -84,6 +224,43 @@ func (s *Server) SetDatagramChannelSize(size int) {
 	s.datagramChannelSize = size
 }
 
+// SetRateLimiter Sets a limiter applied per source IP before messages are parsed
+func (s *Server) SetRateLimiter(l RateLimiter) {
+	s.rateLimiter = l
+}
+
+// SetLocationResolver Sets the resolver for the time zone of each device
+func (s *Server) SetLocationResolver(r LocationResolver) {
+	s.locationResolver = r
+}
+
+// SetTimestampPolicy Sets what to do with timestamps too far from the receive time
+func (s *Server) SetTimestampPolicy(p TimestampPolicy) {
+	s.timestampPolicy = p
+}
+
+// SetKeepRaw Sets whether the raw message is kept in the raw field
+func (s *Server) SetKeepRaw(keep bool) {
+	s.keepRaw = keep
+}
+
+// allow checks the rate limiter, if any, for the client address
+func (s *Server) allow(client string) bool {
+	if s.rateLimiter == nil {
+		return true
+	}
+	return s.rateLimiter.Allow(clientHost(client))
+}
+
+// clientHost strips the port from a client address
+func clientHost(client string) string {
+	host, _, err := net.SplitHostPort(client)
+	if err != nil {
+		return client
+	}
+	return host
+}
+
 // Default TLS peer name function - returns the CN of the certificate
 func defaultTlsPeerName(tlsConn *tls.Conn) (tlsPeer string, ok bool) {
 	state := tlsConn.ConnectionState()
@@ -94,99 +271,250 @@ func defaultTlsPeerName(tlsConn *tls.Conn) (tlsPeer string, ok bool) {
 	return cn, true
 }
 
-// ListenUDP Configure the server for listen on an UDP addr
-func (s *Server) ListenUDP(addr string) error {
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
-	if err != nil {
-		return err
+// Listen Configure the server for listen as described by the config
+func (s *Server) Listen(cfg ListenerConfig) error {
+	switch cfg.Protocol {
+	case "udp", "unixgram":
+		return s.listenPacket(cfg)
+	case "tcp", "unix", "tls":
+		return s.listenStream(cfg)
+	case "rfc5425":
+		if cfg.Format == nil {
+			cfg.Format = RFC5425
+		}
+		if cfg.TLSPolicy == TLSPolicyDefault {
+			cfg.TLSPolicy = TLSPolicyIntermediate
+		}
+		return s.listenStream(cfg)
+	case "relp":
+		return s.listenStream(cfg)
 	}
+	return fmt.Errorf("unknown listener protocol %q", cfg.Protocol)
+}
 
-	connection, err := net.ListenUDP("udp", udpAddr)
+func (s *Server) listenPacket(cfg ListenerConfig) error {
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies)
 	if err != nil {
 		return err
 	}
-	if s.socketSize == 0 {
-		connection.SetReadBuffer(datagramReadBufferSize)
-	} else {
-		connection.SetReadBuffer(s.socketSize)
+	if cfg.Protocol == "udp" && cfg.Workers > 0 {
+		return s.listenWorkers(cfg, proxies)
+	}
+	var connection interface {
+		net.PacketConn
+		SetReadBuffer(int) error
+	}
+	switch cfg.Protocol {
+	case "udp":
+		udpAddr, err := net.ResolveUDPAddr("udp", cfg.Address)
+		if err != nil {
+			return err
+		}
+		c, err := net.ListenUDP("udp", udpAddr)
+		if err != nil {
+			return err
+		}
+		connection = c
+	default:
+		unixAddr, err := net.ResolveUnixAddr("unixgram", cfg.Address)
+		if err != nil {
+			return err
+		}
+		c, err := net.ListenUnixgram("unixgram", unixAddr)
+		if err != nil {
+			return err
+		}
+		connection = c
 	}
 
+	size := s.readBufferSize(cfg)
+	connection.SetReadBuffer(size)
+
+	info := newListenerInfo(cfg.Name, cfg.Protocol, connection.LocalAddr())
+	info.format = cfg.Format
+	info.proxies = proxies
+	info.acl = cfg.ACL
+	info.parseErrorAction = cfg.ParseErrorAction
+	if udp, ok := connection.(*net.UDPConn); ok {
+		info.socket = s.addUDPSocket(info.Name, 0, udp, size)
+	}
 	s.connections = append(s.connections, connection)
+	s.connectionInfos = append(s.connectionInfos, info)
 	return nil
 }
 
-// ListenUnixgram Configure the server for listen on an unix socket
-func (s *Server) ListenUnixgram(addr string) error {
-	unixAddr, err := net.ResolveUnixAddr("unixgram", addr)
-	if err != nil {
-		return err
+// requestsClientCerts reports whether a TLS listener asks clients for a
+// certificate
+func requestsClientCerts(cfg ListenerConfig) bool {
+	if cfg.TLSFiles != nil && cfg.TLSFiles.ClientCAFile != "" {
+		return true
 	}
+	return cfg.TLSConfig != nil && cfg.TLSConfig.ClientAuth != tls.NoClientCert
+}
 
-	connection, err := net.ListenUnixgram("unixgram", unixAddr)
-	if err != nil {
-		return err
+// readBufferSize is the socket receive buffer size of a datagram listener
+func (s *Server) readBufferSize(cfg ListenerConfig) int {
+	size := cfg.SocketSize
+	if size == 0 && cfg.Protocol == "udp" {
+		size = s.socketSize
 	}
-	connection.SetReadBuffer(datagramReadBufferSize)
-
-	s.connections = append(s.connections, connection)
-	return nil
+	if size == 0 {
+		size = datagramReadBufferSize
+	}
+	return size
 }
 
-// ListenTCP Configure the server for listen on a TCP addr
-func (s *Server) ListenTCP(addr string) error {
-	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+func (s *Server) listenStream(cfg ListenerConfig) error {
+	proxies, err := parseTrustedProxies(cfg.TrustedProxies)
 	if err != nil {
 		return err
 	}
+	useTLS := cfg.Protocol == "tls" || cfg.Protocol == "rfc5425" ||
+		(cfg.Protocol == "relp" && (cfg.TLSConfig != nil || cfg.TLSFiles != nil))
+	if cfg.Authorizer != nil && !useTLS {
+		return errors.New("authorizer on a listener without TLS")
+	}
+	var config *tls.Config
+	var reloader *CertReloader
+	if useTLS {
+		if cfg.TLSConfig == nil && cfg.TLSFiles == nil {
+			return errors.New("tls listener without a TLS config")
+		}
+		if cfg.Authorizer != nil && !requestsClientCerts(cfg) {
+			return errors.New("tls listener with an authorizer but without client certificates, set a client CA")
+		}
+		config = cfg.TLSPolicy.Apply(cfg.TLSConfig)
+		if cfg.TLSFiles != nil {
+			if reloader, err = NewCertReloader(*cfg.TLSFiles); err != nil {
+				return err
+			}
+			config = reloader.Config(config)
+		}
+	}
 
-	listener, err := net.ListenTCP("tcp", tcpAddr)
+	network := cfg.Protocol
+	if network != "unix" {
+		network = "tcp"
+	}
+	listener, err := net.Listen(network, cfg.Address)
 	if err != nil {
 		return err
 	}
+	if proxies != nil {
+		listener = &proxyListener{listener, proxies}
+	}
+	if useTLS {
+		listener = tls.NewListener(listener, config)
+		if reloader != nil {
+			reloader.Watch()
+			s.reloaders = append(s.reloaders, reloader)
+		}
+	}
 
-	s.doneTcp = make(chan bool)
+	info := newListenerInfo(cfg.Name, cfg.Protocol, listener.Addr())
+	info.format = cfg.Format
+	info.timeout = cfg.Timeout
+	info.authorizer = cfg.Authorizer
+	info.relpWindow = cfg.RELPWindow
+	info.proxies = proxies
+	info.acl = cfg.ACL
+	info.parseErrorAction = cfg.ParseErrorAction
+	if s.doneTcp == nil {
+		s.doneTcp = make(chan bool)
+	}
 	s.listeners = append(s.listeners, listener)
+	s.listenerInfos = append(s.listenerInfos, info)
 	return nil
 }
 
+// ListenUDP Configure the server for listen on an UDP addr
+func (s *Server) ListenUDP(addr string) error {
+	return s.Listen(ListenerConfig{Protocol: "udp", Address: addr})
+}
+
+// ListenUnixgram Configure the server for listen on an unix socket
+func (s *Server) ListenUnixgram(addr string) error {
+	return s.Listen(ListenerConfig{Protocol: "unixgram", Address: addr})
+}
+
+// ListenTCP Configure the server for listen on a TCP addr
+func (s *Server) ListenTCP(addr string) error {
+	return s.Listen(ListenerConfig{Protocol: "tcp", Address: addr})
+}
+
 // ListenTCPTLS Configure the server for listen on a TCP addr for TLS
 func (s *Server) ListenTCPTLS(addr string, config *tls.Config) error {
-	listener, err := tls.Listen("tcp", addr, config)
-	if err != nil {
-		return err
-	}
-
-	s.doneTcp = make(chan bool)
-	s.listeners = append(s.listeners, listener)
-	return nil
+	return s.Listen(ListenerConfig{Protocol: "tls", Address: addr, TLSConfig: config})
 }
 
 // Boot Starts the server, all the go routines goes to live
 func (s *Server) Boot() error {
-	if s.format == nil {
-		return errors.New("please set a valid format")
+	infos := append(append([]ListenerInfo(nil), s.listenerInfos...), s.connectionInfos...)
+	for _, info := range infos {
+		if s.formatFor(info) == nil {
+			return errors.New("please set a valid format")
+		}
 	}
 
 	if s.handler == nil {
 		return errors.New("please set a valid handler")
 	}
 
-	for _, listener := range s.listeners {
-		s.goAcceptConnection(listener)
+	if err := s.checkQuarantine(infos); err != nil {
+		return err
 	}
 
-	if len(s.connections) > 0 {
-		s.goParseDatagrams()
+	readers := make([]*batchReader, len(s.connections))
+	for i, connection := range s.connections {
+		if info := s.connectionInfos[i]; info.batchSize > 0 {
+			r, err := newBatchReader(connection, info.batchSize)
+			if err != nil {
+				return fmt.Errorf("listener %s: %w", info.Name, err)
+			}
+			readers[i] = r
+		}
 	}
 
-	for _, connection := range s.connections {
-		s.goReceiveDatagrams(connection)
+	for i, listener := range s.listeners {
+		s.goAcceptConnection(listener, s.listenerInfos[i])
+	}
+
+	// batch readers parse on their own goroutines
+	for _, r := range readers {
+		if r == nil {
+			s.goParseDatagrams()
+			break
+		}
+	}
+
+	for i, connection := range s.connections {
+		if info := s.connectionInfos[i]; readers[i] != nil {
+			s.goReceiveBatches(readers[i], info)
+		} else {
+			s.goReceiveDatagrams(connection, info)
+		}
+	}
+
+	if len(s.udpSockets) > 0 {
+		s.goWatchSockets()
+	}
+
+	if s.quarantine != nil {
+		s.goWriteQuarantine()
 	}
 
 	return nil
 }
 
-func (s *Server) goAcceptConnection(listener net.Listener) {
+// formatFor returns the format of the listener
+func (s *Server) formatFor(info ListenerInfo) format.Format {
+	if info.format != nil {
+		return info.format
+	}
+	return s.format
+}
+
+func (s *Server) goAcceptConnection(listener net.Listener, info ListenerInfo) {
 	s.wait.Add(1)
 	go func(listener net.Listener) {
 	loop:
@@ -200,51 +528,107 @@ func (s *Server) goAcceptConnection(listener net.Listener) {
 			if err != nil {
 				continue
 			}
-
-			s.goScanConnection(connection)
+			s.wait.Add(1)
+			go s.serveConnection(connection, info)
 		}
 
 		s.wait.Done()
 	}(listener)
 }
 
-func (s *Server) goScanConnection(connection net.Conn) {
-	scanner := bufio.NewScanner(connection)
-	if sf := s.format.GetSplitFunc(); sf != nil {
-		scanner.Split(sf)
+// serveConnection identifies the client and hands the connection over. It
+// runs per connection, so a slow PROXY header or TLS handshake only holds up
+// its own client.
+func (s *Server) serveConnection(connection net.Conn, info ListenerInfo) {
+	defer s.wait.Done()
+	o, ok := s.connectionOrigin(connection, info)
+	if !ok {
+		connection.Close()
+		return
 	}
-
-	remoteAddr := connection.RemoteAddr()
-	var client string
-	if remoteAddr != nil {
-		client = remoteAddr.String()
+	if info.Protocol == "relp" {
+		s.goServeRELP(connection, o)
+	} else {
+		s.goScanConnection(connection, o)
 	}
+}
 
-	tlsPeer := ""
-	if tlsConn, ok := connection.(*tls.Conn); ok {
-		// Handshake now so we get the TLS peer information
-		if err := tlsConn.Handshake(); err != nil {
-			connection.Close()
-			return
-		}
-		if s.tlsPeerNameFunc != nil {
-			var ok bool
-			tlsPeer, ok = s.tlsPeerNameFunc(tlsConn)
-			if !ok {
-				connection.Close()
-				return
-			}
-		}
+func (s *Server) goScanConnection(connection net.Conn, o origin) {
+	info := o.listener
+	scanner := bufio.NewScanner(connection)
+	f := s.formatForSource(info, clientHost(o.client), "")
+	frames := &frameCounter{split: bufio.ScanLines}
+	if sf := f.GetSplitFunc(); sf != nil {
+		frames.split = sf
+	}
+	scanner.Split(frames.Split)
+	if b, ok := f.(bufferSizer); ok && b.BufferSize() > bufio.MaxScanTokenSize {
+		scanner.Buffer(make([]byte, 4096), b.BufferSize())
 	}
 
 	var scanCloser *ScanCloser
-	scanCloser = &ScanCloser{scanner, connection}
+	scanCloser = &ScanCloser{scanner, connection, frames}
 
 	s.wait.Add(1)
-	go s.scan(scanCloser, client, tlsPeer)
+	go s.scan(scanCloser, o)
 }
 
-func (s *Server) scan(scanCloser *ScanCloser, client string, tlsPeer string) {
+// connectionOrigin identifies the client of a stream connection, applies the
+// listener ACL once the PROXY header has named it, then completes the TLS
+// handshake and authorization. ok is false if the connection should be
+// closed.
+func (s *Server) connectionOrigin(connection net.Conn, info ListenerInfo) (o origin, ok bool) {
+	o.listener = info
+	if remoteAddr := connection.RemoteAddr(); remoteAddr != nil {
+		o.client = remoteAddr.String()
+	}
+	if p := proxyConnOf(connection); p != nil {
+		if p.err != nil {
+			prometheus.ProxyHeaderErrors.WithLabelValues(info.Name).Inc()
+			return o, false
+		}
+		o.proxy = p.Conn.RemoteAddr().String()
+	}
+	if !s.admit(info, o.client, "connection") {
+		return o, false
+	}
+
+	tlsConn, isTLS := connection.(*tls.Conn)
+	if !isTLS {
+		return o, true
+	}
+	// Handshake now so we get the TLS peer information
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return o, false
+	}
+	tlsConn.SetDeadline(time.Time{})
+	if s.tlsPeerNameFunc != nil {
+		if o.tlsPeer, ok = s.tlsPeerNameFunc(tlsConn); !ok {
+			return o, false
+		}
+	}
+	id, ok := identityOf(tlsConn.ConnectionState())
+	if info.authorizer != nil {
+		var rule TLSRule
+		if ok {
+			rule, ok = info.authorizer.Authorize(id, clientHost(o.client))
+		}
+		if !ok {
+			prometheus.TLSClients.WithLabelValues(info.Name, "rejected").Inc()
+			logger.SugarLogger.Warnw("tls client not authorized", "listener", info.Name, "client", o.client, "subject", id.Subject, "spki", id.SPKI)
+			return o, false
+		}
+		prometheus.TLSClients.WithLabelValues(info.Name, "accepted").Inc()
+		id.Tenant = rule.Tenant
+	}
+	if ok {
+		o.identity = &id
+	}
+	return o, true
+}
+
+func (s *Server) scan(scanCloser *ScanCloser, o origin) {
 loop:
 	for {
 		select {
@@ -252,43 +636,158 @@ loop:
 			break loop
 		default:
 		}
-		if s.readTimeoutMilliseconds > 0 {
+		if o.listener.timeout > 0 {
+			scanCloser.closer.SetReadDeadline(time.Now().Add(o.listener.timeout))
+		} else if s.readTimeoutMilliseconds > 0 {
 			scanCloser.closer.SetReadDeadline(time.Now().Add(time.Duration(s.readTimeoutMilliseconds) * time.Millisecond))
 		}
 		if scanCloser.Scan() {
-			s.parser([]byte(scanCloser.Text()), client, tlsPeer)
+			if s.allow(o.client) {
+				o.receivedAt = time.Now()
+				o.length = scanCloser.frames.last
+				s.parser([]byte(scanCloser.Text()), o)
+			}
 		} else {
 			break loop
 		}
 	}
+	if err := scanCloser.Err(); isFramingError(err) {
+		s.framingFailed(o, err)
+	}
 	scanCloser.closer.Close()
 
 	s.wait.Done()
 }
 
-func (s *Server) parser(line []byte, client string, tlsPeer string) {
-	parser := s.format.GetParser(line)
-	err := parser.Parse()
-	if err != nil {
-		s.lastError = err
+func (s *Server) parser(line []byte, o origin) {
+	client := o.client
+	ip := clientHost(client)
+	f := s.formatForSource(o.listener, ip, "")
+	if o.receivedAt.IsZero() {
+		o.receivedAt = time.Now()
+	}
+	var loc *time.Location
+	if s.locationResolver != nil {
+		loc = s.locationResolver.Resolve(ip, "")
+	}
+	logParts, err := parse(f, line, loc, o.receivedAt)
+
+	// The hostname is only known after parsing, parse again if the device
+	// is configured to a different format or zone by name.
+	if hostname, ok := logParts["hostname"].(string); ok && hostname != "" {
+		hf, hloc := f, loc
+		if s.formatSelector != nil {
+			if sel := s.formatSelector.Select(ip, hostname); sel != nil {
+				hf = sel
+			}
+		}
+		if s.locationResolver != nil {
+			if l := s.locationResolver.Resolve(ip, hostname); !sameLocation(l, loc) {
+				hloc = l
+			}
+		}
+		if hf != f || hloc != loc {
+			f, loc = hf, hloc
+			logParts, err = parse(f, line, loc, o.receivedAt)
+		}
+	}
+	if logParts == nil {
+		logParts = format.LogParts{}
+	}
+	if _, ok := logParts["format"]; !ok {
+		logParts["format"] = format.Name(f)
 	}
-
-	logParts := parser.Dump()
 	logParts["client"] = client
-	if logParts["hostname"] == "" && (s.format == RFC3164 || s.format == Automatic) {
+	if logParts["hostname"] == "" && logParts["format"] == "rfc3164" {
 		if i := strings.Index(client, ":"); i > 1 {
 			logParts["hostname"] = client[:i]
 		} else {
 			logParts["hostname"] = client
 		}
 	}
-	logParts["tls_peer"] = tlsPeer
+	logParts["tls_peer"] = o.tlsPeer
+	if o.proxy != "" {
+		logParts["proxy"] = o.proxy
+	}
+	if o.identity != nil {
+		logParts["tls_subject"] = o.identity.Subject
+		logParts["tls_san"] = o.identity.SANs
+		logParts["tls_spki"] = o.identity.SPKI
+		if o.identity.Tenant != "" {
+			logParts["tls_tenant"] = o.identity.Tenant
+		}
+	}
+	logParts["listener"] = o.listener.Name
+	logParts["listener_protocol"] = o.listener.Protocol
+	logParts["listener_address"] = o.listener.Address
+	if o.length > 0 {
+		logParts["message_length"] = o.length
+	} else {
+		logParts["message_length"] = len(line)
+	}
+	if s.keepRaw {
+		logParts["raw"] = string(line)
+	}
+	s.checkTimestamp(logParts, o.receivedAt)
+	if err != nil && !s.parseFailed(logParts, line, o, err) {
+		return
+	}
 
 	s.handler.Handle(logParts, int64(len(line)), err)
 }
 
-//Returns the last error
+// sameLocation compares zones by name, nil being UTC
+func sameLocation(a, b *time.Location) bool {
+	if a == nil {
+		a = time.UTC
+	}
+	if b == nil {
+		b = time.UTC
+	}
+	return a.String() == b.String()
+}
+
+// parse parses line in f, in loc if it is set, inferring missing years from
+// the receive time
+func parse(f format.Format, line []byte, loc *time.Location, receivedAt time.Time) (format.LogParts, error) {
+	parser := f.GetParser(line)
+	if loc != nil {
+		parser.Location(loc)
+	}
+	parser.ReceivedAt(receivedAt)
+	err := parser.Parse()
+	return parser.Dump(), err
+}
+
+// checkTimestamp sets received_at and clock_skew, the seconds the device
+// timestamp is behind the receive time, and applies the timestamp policy
+func (s *Server) checkTimestamp(logParts format.LogParts, receivedAt time.Time) {
+	logParts["received_at"] = receivedAt
+	ts, ok := logParts["timestamp"].(time.Time)
+	if !ok || ts.IsZero() {
+		return
+	}
+	skew := receivedAt.Sub(ts)
+	logParts["clock_skew"] = skew.Seconds()
+
+	p := s.timestampPolicy
+	if p.Action == TimestampKeep {
+		return
+	}
+	if (p.MaxFuture == 0 || -skew <= p.MaxFuture) && (p.MaxPast == 0 || skew <= p.MaxPast) {
+		return
+	}
+	logParts["timestamp_suspect"] = true
+	if p.Action == TimestampReplace {
+		logParts["timestamp_original"] = ts
+		logParts["timestamp"] = receivedAt
+	}
+}
+
+// GetLastError Returns the error of the most recent parse failure
 func (s *Server) GetLastError() error {
+	s.lastErrorMu.Lock()
+	defer s.lastErrorMu.Unlock()
 	return s.lastError
 }
 
@@ -307,6 +806,9 @@ func (s *Server) Kill() error {
 			return err
 		}
 	}
+	for _, reloader := range s.reloaders {
+		reloader.Stop()
+	}
 	// Only need to close channel once to broadcast to all waiting
 	if s.doneTcp != nil {
 		close(s.doneTcp)
@@ -322,6 +824,12 @@ func (s *Server) Wait() {
 	s.wait.Wait()
 }
 
+// bufferSizer is implemented by formats whose frames may not fit the default
+// scanner buffer
+type bufferSizer interface {
+	BufferSize() int
+}
+
 type TimeoutCloser interface {
 	Close() error
 	SetReadDeadline(t time.Time) error
@@ -330,45 +838,111 @@ type TimeoutCloser interface {
 type ScanCloser struct {
 	*bufio.Scanner
 	closer TimeoutCloser
+	frames *frameCounter
+}
+
+// frameCounter wraps a split function to record how many bytes the last
+// token took on the wire, framing included
+type frameCounter struct {
+	split bufio.SplitFunc
+	read  int
+	last  int
+}
+
+func (f *frameCounter) Split(data []byte, atEOF bool) (int, []byte, error) {
+	advance, token, err := f.split(data, atEOF)
+	f.read += advance
+	if token != nil {
+		f.last, f.read = f.read, 0
+	}
+	return advance, token, err
 }
 
 type DatagramMessage struct {
-	message []byte
-	client  string
+	message    []byte
+	client     string
+	proxy      string
+	listener   ListenerInfo
+	receivedAt time.Time
+	length     int
 }
 
-func (s *Server) goReceiveDatagrams(packetconn net.PacketConn) {
+func (s *Server) goReceiveDatagrams(packetconn net.PacketConn, info ListenerInfo) {
 	s.wait.Add(1)
 	go func() {
 		defer s.wait.Done()
+		var oob []byte
+		if info.socket != nil && overflowOOBSize > 0 {
+			oob = make([]byte, overflowOOBSize)
+		}
 		for {
 			buf := s.datagramPool.Get().([]byte)
-			n, addr, err := packetconn.ReadFrom(buf)
+			n, addr, err := readFrom(packetconn, buf, oob, info.socket)
 			if err == nil {
-				// Ignore trailing control characters and NULs
-				for ; (n > 0) && (buf[n-1] < 32); n-- {
-				}
-				if n > 0 {
-					var address string
-					if addr != nil {
-						address = addr.String()
-					}
-					s.datagramChannel <- DatagramMessage{buf[:n], address}
-				}
-			} else {
-				// there has been an error. Either the server has been killed
-				// or may be getting a transitory error due to (e.g.) the
-				// interface being shutdown in which case sleep() to avoid busy wait.
-				opError, ok := err.(*net.OpError)
-				if (ok) && !opError.Temporary() && !opError.Timeout() {
-					return
+				if msg, ok := s.datagram(buf, n, addr, info, time.Now()); ok {
+					s.datagramChannel <- msg
+				} else {
+					s.datagramPool.Put(buf)
 				}
-				time.Sleep(10 * time.Millisecond)
+			} else if !temporaryError(err) {
+				return
 			}
 		}
 	}()
 }
 
+// temporaryError reports whether reading may continue after err. Either the
+// server has been killed or there is a transitory error due to (e.g.) the
+// interface being shutdown, in which case it sleeps to avoid a busy wait.
+func temporaryError(err error) bool {
+	opError, ok := err.(*net.OpError)
+	if (ok) && !opError.Temporary() && !opError.Timeout() {
+		return false
+	}
+	time.Sleep(10 * time.Millisecond)
+	return true
+}
+
+// datagram prepares the n bytes read into buf from addr for parsing, ok is
+// false if it should be dropped
+func (s *Server) datagram(buf []byte, n int, addr net.Addr, info ListenerInfo, receivedAt time.Time) (msg DatagramMessage, ok bool) {
+	var address, proxy string
+	length := n
+	if addr != nil {
+		address = addr.String()
+	}
+	if info.proxies.trusts(addr) {
+		ip, port, hn, err := parseProxyV2(buf[:n])
+		if err != nil {
+			prometheus.ProxyHeaderErrors.WithLabelValues(info.Name).Inc()
+			return msg, false
+		}
+		if ip != nil {
+			proxy, address = address, net.JoinHostPort(ip.String(), strconv.Itoa(port))
+		}
+		n = copy(buf, buf[hn:n])
+	}
+	// Ignore trailing control characters and NULs
+	for ; (n > 0) && (buf[n-1] < 32); n-- {
+	}
+	if n == 0 || !s.admit(info, address, "datagram") || !s.allow(address) {
+		return msg, false
+	}
+	return DatagramMessage{buf[:n], address, proxy, info, receivedAt, length}, true
+}
+
+// parseDatagram hands a datagram to the parser
+func (s *Server) parseDatagram(msg DatagramMessage) {
+	o := origin{client: msg.client, proxy: msg.proxy, listener: msg.listener, receivedAt: msg.receivedAt, length: msg.length}
+	if sf := s.formatForSource(msg.listener, clientHost(msg.client), "").GetSplitFunc(); sf != nil {
+		if _, token, err := sf(msg.message, true); err == nil {
+			s.parser(token, o)
+		}
+	} else {
+		s.parser(msg.message, o)
+	}
+}
+
 func (s *Server) goParseDatagrams() {
 	s.datagramChannel = make(chan DatagramMessage, s.datagramChannelSize)
 
@@ -381,13 +955,7 @@ func (s *Server) goParseDatagrams() {
 				if !ok {
 					return
 				}
-				if sf := s.format.GetSplitFunc(); sf != nil {
-					if _, token, err := sf(msg.message, true); err == nil {
-						s.parser(token, msg.client, "")
-					}
-				} else {
-					s.parser(msg.message, msg.client, "")
-				}
+				s.parseDatagram(msg)
 				s.datagramPool.Put(msg.message[:cap(msg.message)])
 			}
 		}
@@ -4,6 +4,8 @@ import (
 	"bufio"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -22,6 +24,8 @@ func (noopFormatter) Dump() format.LogParts {
 
 func (noopFormatter) Location(*time.Location) {}
 
+func (noopFormatter) ReceivedAt(time.Time) {}
+
 func (n noopFormatter) GetParser(l []byte) format.LogParser {
 	return n
 }
@@ -33,11 +37,13 @@ func (n noopFormatter) GetSplitFunc() bufio.SplitFunc {
 type handlerCounter struct {
 	expected int
 	current  int
+	last     format.LogParts
 	done     chan struct{}
 }
 
 func (s *handlerCounter) Handle(logParts format.LogParts, msgLen int64, err error) {
 	s.current++
+	s.last = logParts
 	if s.current == s.expected {
 		close(s.done)
 	}
@@ -77,7 +83,7 @@ func BenchmarkDatagramNoFormatting(b *testing.B) {
 	server.SetFormat(noopFormatter{})
 	server.SetHandler(handler)
 	reader, writer := io.Pipe()
-	server.goReceiveDatagrams(&fakePacketConn{PipeReader: reader})
+	server.goReceiveDatagrams(&fakePacketConn{PipeReader: reader}, ListenerInfo{})
 	server.goParseDatagrams()
 	msg := []byte(exampleSyslog + "\n")
 	b.SetBytes(int64(len(msg)))
@@ -103,3 +109,63 @@ func BenchmarkTCPNoFormatting(b *testing.B) {
 	}
 	<-handler.done
 }
+
+// atomicCounter counts messages from several parsing goroutines
+type atomicCounter struct {
+	count int64
+}
+
+func (h *atomicCounter) Handle(logParts format.LogParts, msgLen int64, err error) {
+	atomic.AddInt64(&h.count, 1)
+}
+
+// benchmarkUDP sends b.N datagrams from several senders over loopback and
+// reports the share that made it through; the rest was dropped by the kernel
+func benchmarkUDP(b *testing.B, cfg ListenerConfig) {
+	handler := &atomicCounter{}
+	server := NewServer()
+	defer server.Kill()
+	server.SetFormat(noopFormatter{})
+	server.SetHandler(handler)
+	cfg.Protocol, cfg.Address, cfg.SocketSize = "udp", "127.0.0.1:0", 4<<20
+	if err := server.Listen(cfg); err != nil {
+		b.Skip(err)
+	}
+	server.Boot()
+	addr := server.connections[0].LocalAddr().String()
+
+	const senders = 8
+	msg := []byte(exampleSyslog)
+	b.SetBytes(int64(len(msg)))
+	b.ResetTimer()
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			conn, err := net.Dial("udp", addr)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			for j := 0; j < n; j++ {
+				conn.Write(msg)
+			}
+		}(b.N/senders + 1)
+	}
+	wg.Wait()
+	sent := senders * (b.N/senders + 1)
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt64(&handler.count) < int64(sent) && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(atomic.LoadInt64(&handler.count))/float64(sent), "delivered")
+}
+
+func BenchmarkUDP(b *testing.B) {
+	benchmarkUDP(b, ListenerConfig{})
+}
+
+func BenchmarkUDPWorkers(b *testing.B) {
+	benchmarkUDP(b, ListenerConfig{Workers: 4})
+}
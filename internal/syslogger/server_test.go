@@ -1,11 +1,13 @@
 package syslog
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/metajar/metalogger/internal/syslogger/format"
 	. "gopkg.in/check.v1"
 	"io"
 	"net"
+	"net/http/httptest"
 	"testing"
 	"time"
 )
@@ -117,7 +119,7 @@ func (s *ServerSuite) TestConnectionClose(c *C) {
 	server.SetFormat(RFC3164)
 	server.SetHandler(handler)
 	con := ConnMock{ReadData: []byte(exampleSyslog)}
-	server.goScanConnection(&con)
+	server.goScanConnection(&con, origin{})
 	server.Wait()
 	c.Check(con.isClosed, Equals, true)
 }
@@ -128,7 +130,7 @@ func (s *ServerSuite) TestConnectionUDPKill(c *C) {
 	server.SetFormat(RFC5424)
 	server.SetHandler(handler)
 	con := ConnMock{ReadData: []byte(exampleSyslog)}
-	server.goScanConnection(&con)
+	server.goScanConnection(&con, origin{})
 	server.Kill()
 	server.Wait()
 	c.Check(con.isClosed, Equals, true)
@@ -142,7 +144,7 @@ func (s *ServerSuite) TestTcpTimeout(c *C) {
 	server.SetTimeout(10)
 	con := ConnMock{ReadData: []byte(exampleSyslog), ReturnTimeout: true}
 	c.Check(con.isReadDeadline, Equals, false)
-	server.goScanConnection(&con)
+	server.goScanConnection(&con, origin{})
 	server.Wait()
 	c.Check(con.isReadDeadline, Equals, true)
 	c.Check(handler.LastLogParts, IsNil)
@@ -157,7 +159,7 @@ func (s *ServerSuite) TestUDP3164(c *C) {
 	server.SetHandler(handler)
 	server.SetTimeout(10)
 	server.goParseDatagrams()
-	server.datagramChannel <- DatagramMessage{[]byte(exampleSyslog), "0.0.0.0"}
+	server.datagramChannel <- DatagramMessage{message: []byte(exampleSyslog), client: "0.0.0.0"}
 	close(server.datagramChannel)
 	server.Wait()
 	c.Check(handler.LastLogParts["hostname"], Equals, "hostname")
@@ -174,7 +176,7 @@ func (s *ServerSuite) TestUDP3164NoTag(c *C) {
 	server.SetHandler(handler)
 	server.SetTimeout(10)
 	server.goParseDatagrams()
-	server.datagramChannel <- DatagramMessage{[]byte(exampleSyslogNoTSTagHost), "127.0.0.1:45789"}
+	server.datagramChannel <- DatagramMessage{message: []byte(exampleSyslogNoTSTagHost), client: "127.0.0.1:45789"}
 	close(server.datagramChannel)
 	server.Wait()
 	c.Check(handler.LastLogParts["hostname"], Equals, "127.0.0.1")
@@ -191,7 +193,7 @@ func (s *ServerSuite) TestUDPAutomatic3164NoPriority(c *C) {
 	server.SetHandler(handler)
 	server.SetTimeout(10)
 	server.goParseDatagrams()
-	server.datagramChannel <- DatagramMessage{[]byte(exampleSyslogNoPriority), "127.0.0.1:45789"}
+	server.datagramChannel <- DatagramMessage{message: []byte(exampleSyslogNoPriority), client: "127.0.0.1:45789"}
 	close(server.datagramChannel)
 	server.Wait()
 	c.Check(handler.LastLogParts["hostname"], Equals, "127.0.0.1")
@@ -210,7 +212,7 @@ func (s *ServerSuite) TestUDP6587(c *C) {
 	server.SetTimeout(10)
 	server.goParseDatagrams()
 	framedSyslog := []byte(fmt.Sprintf("%d %s", len(exampleRFC5424Syslog), exampleRFC5424Syslog))
-	server.datagramChannel <- DatagramMessage{[]byte(framedSyslog), "0.0.0.0"}
+	server.datagramChannel <- DatagramMessage{message: []byte(framedSyslog), client: "0.0.0.0"}
 	close(server.datagramChannel)
 	server.Wait()
 	c.Check(handler.LastLogParts["hostname"], Equals, "mymachine.example.com")
@@ -227,7 +229,7 @@ func (s *ServerSuite) TestUDPAutomatic3164(c *C) {
 	server.SetHandler(handler)
 	server.SetTimeout(10)
 	server.goParseDatagrams()
-	server.datagramChannel <- DatagramMessage{[]byte(exampleSyslog), "0.0.0.0"}
+	server.datagramChannel <- DatagramMessage{message: []byte(exampleSyslog), client: "0.0.0.0"}
 	close(server.datagramChannel)
 	server.Wait()
 	c.Check(handler.LastLogParts["hostname"], Equals, "hostname")
@@ -244,7 +246,7 @@ func (s *ServerSuite) TestUDPAutomatic5424(c *C) {
 	server.SetHandler(handler)
 	server.SetTimeout(10)
 	server.goParseDatagrams()
-	server.datagramChannel <- DatagramMessage{[]byte(exampleRFC5424Syslog), "0.0.0.0"}
+	server.datagramChannel <- DatagramMessage{message: []byte(exampleRFC5424Syslog), client: "0.0.0.0"}
 	close(server.datagramChannel)
 	server.Wait()
 	c.Check(handler.LastLogParts["hostname"], Equals, "mymachine.example.com")
@@ -262,7 +264,7 @@ func (s *ServerSuite) TestUDPAutomatic3164Plus6587OctetCount(c *C) {
 	server.SetTimeout(10)
 	server.goParseDatagrams()
 	framedSyslog := []byte(fmt.Sprintf("%d %s", len(exampleSyslog), exampleSyslog))
-	server.datagramChannel <- DatagramMessage{[]byte(framedSyslog), "0.0.0.0"}
+	server.datagramChannel <- DatagramMessage{message: []byte(framedSyslog), client: "0.0.0.0"}
 	close(server.datagramChannel)
 	server.Wait()
 	c.Check(handler.LastLogParts["hostname"], Equals, "hostname")
@@ -280,7 +282,7 @@ func (s *ServerSuite) TestUDPAutomatic5424Plus6587OctetCount(c *C) {
 	server.SetTimeout(10)
 	server.goParseDatagrams()
 	framedSyslog := []byte(fmt.Sprintf("%d %s", len(exampleRFC5424Syslog), exampleRFC5424Syslog))
-	server.datagramChannel <- DatagramMessage{[]byte(framedSyslog), "0.0.0.0"}
+	server.datagramChannel <- DatagramMessage{message: []byte(framedSyslog), client: "0.0.0.0"}
 	close(server.datagramChannel)
 	server.Wait()
 	c.Check(handler.LastLogParts["hostname"], Equals, "mymachine.example.com")
@@ -344,3 +346,311 @@ func (s *ServerSuite) TestTCPRace(c *C) {
 	<-handler.done
 	c.Check(handler.contents, DeepEquals, []string{"content1", "content2", "content3"})
 }
+
+type limiterMock struct {
+	allowed int
+	keys    []string
+}
+
+func (l *limiterMock) Allow(key string) bool {
+	l.keys = append(l.keys, key)
+	l.allowed--
+	return l.allowed >= 0
+}
+
+func (s *ServerSuite) TestTCPRateLimit(c *C) {
+	handler := &handlerCounter{expected: 1, done: make(chan struct{})}
+	limiter := &limiterMock{allowed: 1}
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(handler)
+	server.SetRateLimiter(limiter)
+	con := ConnMock{ReadData: []byte(exampleSyslog + "\n" + exampleSyslog + "\n")}
+	server.goScanConnection(&con, origin{})
+	server.Wait()
+	<-handler.done
+	c.Check(handler.current, Equals, 1)
+	c.Check(limiter.keys, DeepEquals, []string{"", ""})
+}
+
+func (s *ServerSuite) TestUDPRateLimit(c *C) {
+	handler := &handlerCounter{expected: 1, done: make(chan struct{})}
+	limiter := &limiterMock{allowed: 1}
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(handler)
+	server.SetRateLimiter(limiter)
+	server.ListenUDP("127.0.0.1:0")
+	server.Boot()
+	conn, err := net.Dial("udp", server.connections[0].LocalAddr().String())
+	c.Assert(err, IsNil)
+	for i := 0; i < 3; i++ {
+		_, err = conn.Write([]byte(exampleSyslog))
+		c.Assert(err, IsNil)
+	}
+	conn.Close()
+	<-handler.done
+	time.Sleep(100 * time.Millisecond)
+	server.Kill()
+	server.Wait()
+	c.Check(handler.current, Equals, 1)
+	c.Check(limiter.keys, DeepEquals, []string{"127.0.0.1", "127.0.0.1", "127.0.0.1"})
+}
+
+type resolverMock struct {
+	byHostname map[string]*time.Location
+	calls      []string
+}
+
+func (r *resolverMock) Resolve(ip, hostname string) *time.Location {
+	r.calls = append(r.calls, ip+"/"+hostname)
+	return r.byHostname[hostname]
+}
+
+func (s *ServerSuite) TestLocationResolver(c *C) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	c.Assert(err, IsNil)
+	resolver := &resolverMock{byHostname: map[string]*time.Location{"hostname": tokyo}}
+	handler := new(HandlerMock)
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(handler)
+	server.SetLocationResolver(resolver)
+	server.parser([]byte(exampleSyslog), origin{client: "10.0.0.1:514"})
+	c.Check(resolver.calls, DeepEquals, []string{"10.0.0.1/", "10.0.0.1/hostname"})
+	ts, ok := handler.LastLogParts["timestamp"].(time.Time)
+	c.Assert(ok, Equals, true)
+	c.Check(ts.Location(), Equals, tokyo)
+	c.Check(ts.Hour(), Equals, 5)
+	c.Check(handler.LastLogParts["content"], Equals, "content")
+}
+
+func (s *ServerSuite) TestLocationResolverNil(c *C) {
+	resolver := &resolverMock{}
+	for _, f := range []format.Format{RFC3164, Automatic, format.NewChain(RFC5424, RFC3164)} {
+		handler := new(HandlerMock)
+		server := NewServer()
+		server.SetFormat(f)
+		server.SetHandler(handler)
+		server.SetLocationResolver(resolver)
+		server.parser([]byte(exampleSyslog), origin{client: "10.0.0.1:514"})
+		ts, ok := handler.LastLogParts["timestamp"].(time.Time)
+		c.Assert(ok, Equals, true)
+		c.Check(ts.Location(), Equals, time.UTC)
+		c.Check(ts.Hour(), Equals, 5)
+		c.Check(handler.LastLogParts["content"], Equals, "content")
+	}
+}
+
+func (s *ServerSuite) TestYearFromReceivedAt(c *C) {
+	handler := new(HandlerMock)
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(handler)
+	receivedAt := time.Date(2023, time.January, 1, 0, 5, 0, 0, time.UTC)
+	server.parser([]byte(exampleSyslog), origin{client: "10.0.0.1:514", receivedAt: receivedAt})
+	c.Check(handler.LastLogParts["timestamp"], Equals, time.Date(2022, time.December, 26, 5, 8, 46, 0, time.UTC))
+	c.Check(handler.LastLogParts["received_at"], Equals, receivedAt)
+}
+
+func (s *ServerSuite) TestTimestampPolicy(c *C) {
+	handler := new(HandlerMock)
+	server := NewServer()
+	server.SetFormat(RFC5424)
+	server.SetHandler(handler)
+
+	server.parser([]byte(exampleRFC5424Syslog), origin{client: "10.0.0.1:514"})
+	receivedAt, ok := handler.LastLogParts["received_at"].(time.Time)
+	c.Assert(ok, Equals, true)
+	c.Check(time.Since(receivedAt) < time.Minute, Equals, true)
+	c.Check(handler.LastLogParts["clock_skew"].(float64) > 0, Equals, true)
+	c.Check(handler.LastLogParts["timestamp_suspect"], IsNil)
+
+	server.SetTimestampPolicy(TimestampPolicy{MaxPast: 24 * time.Hour, Action: TimestampFlag})
+	server.parser([]byte(exampleRFC5424Syslog), origin{client: "10.0.0.1:514"})
+	c.Check(handler.LastLogParts["timestamp_suspect"], Equals, true)
+	c.Check(handler.LastLogParts["timestamp_original"], IsNil)
+
+	server.SetTimestampPolicy(TimestampPolicy{MaxPast: 24 * time.Hour, Action: TimestampReplace})
+	server.parser([]byte(exampleRFC5424Syslog), origin{client: "10.0.0.1:514"})
+	c.Check(handler.LastLogParts["timestamp"], Equals, handler.LastLogParts["received_at"])
+	original, ok := handler.LastLogParts["timestamp_original"].(time.Time)
+	c.Assert(ok, Equals, true)
+	c.Check(original.Year(), Equals, 2003)
+
+	future := "<34>1 " + time.Now().Add(time.Hour).UTC().Format(time.RFC3339) + " mymachine.example.com su - ID47 - hi"
+	server.SetTimestampPolicy(TimestampPolicy{MaxFuture: time.Minute, Action: TimestampFlag})
+	server.parser([]byte(future), origin{client: "10.0.0.1:514"})
+	c.Check(handler.LastLogParts["timestamp_suspect"], Equals, true)
+	c.Check(handler.LastLogParts["clock_skew"].(float64) < 0, Equals, true)
+}
+
+func (s *ServerSuite) TestMetadata(c *C) {
+	handler := &handlerCounter{expected: 1, done: make(chan struct{})}
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(handler)
+	server.SetKeepRaw(true)
+	server.ListenUDP("127.0.0.1:0")
+	server.Boot()
+	addr := server.connections[0].LocalAddr().String()
+	conn, err := net.Dial("udp", addr)
+	c.Assert(err, IsNil)
+	before := time.Now()
+	_, err = conn.Write([]byte(exampleSyslog + "\n\x00"))
+	c.Assert(err, IsNil)
+	conn.Close()
+	<-handler.done
+	server.Kill()
+	server.Wait()
+
+	parts := handler.last
+	c.Check(parts["listener"], Equals, "udp://"+addr)
+	c.Check(parts["listener_protocol"], Equals, "udp")
+	c.Check(parts["listener_address"], Equals, addr)
+	c.Check(parts["raw"], Equals, exampleSyslog)
+	c.Check(parts["message_length"], Equals, len(exampleSyslog)+2)
+	receivedAt := parts["received_at"].(time.Time)
+	c.Check(receivedAt.Before(before), Equals, false)
+}
+
+type quarantineMock struct {
+	parts chan format.LogParts
+}
+
+func (q *quarantineMock) Write(parts format.LogParts) {
+	q.parts <- parts
+}
+
+func (s *ServerSuite) TestParseErrorPolicy(c *C) {
+	handler := new(HandlerMock)
+	quarantine := &quarantineMock{parts: make(chan format.LogParts, 1)}
+	server := NewServer()
+	server.SetFormat(&format.CiscoXR{})
+	server.SetHandler(handler)
+	server.SetQuarantineWriter(quarantine)
+	server.SetRecentFailures(2)
+	c.Check(server.GetLastError(), IsNil)
+	c.Assert(server.Listen(ListenerConfig{Protocol: "udp", Address: "127.0.0.1:0", ParseErrorAction: ParseErrorDrop}), IsNil)
+	c.Check(server.connectionInfos[0].parseErrorAction, Equals, ParseErrorDrop)
+	c.Assert(server.Boot(), IsNil)
+	defer server.Kill()
+
+	server.parser([]byte("garbage 1"), origin{client: "10.0.0.1:514"})
+	c.Check(handler.LastLogParts["parse_error"], Equals, format.ErrCiscoXRNoMatch.Error())
+	c.Check(handler.LastError, Equals, format.ErrCiscoXRNoMatch)
+
+	handler.LastLogParts = nil
+	server.parser([]byte("garbage 2"), origin{client: "10.0.0.1:514", listener: ListenerInfo{Name: "drop", parseErrorAction: ParseErrorDrop}})
+	c.Check(handler.LastLogParts, IsNil)
+
+	server.parser([]byte("garbage 3"), origin{client: "10.0.0.2:514", listener: ListenerInfo{Name: "quarantine", parseErrorAction: ParseErrorQuarantine}})
+	c.Check(handler.LastLogParts, IsNil)
+	select {
+	case parts := <-quarantine.parts:
+		c.Check(parts["raw"], Equals, "garbage 3")
+		c.Check(parts["client"], Equals, "10.0.0.2:514")
+	case <-time.After(time.Second):
+		c.Fatal("message not quarantined")
+	}
+
+	failures := server.RecentFailures()
+	c.Assert(failures, HasLen, 2)
+	c.Check(failures[0].Raw, Equals, "garbage 2")
+	c.Check(failures[1].Raw, Equals, "garbage 3")
+	c.Check(failures[1].Listener, Equals, "quarantine")
+	c.Check(failures[1].Format, Equals, "ciscoxr")
+	c.Check(server.GetLastError(), ErrorMatches, "message does not match the CiscoXR pattern")
+
+	rec := httptest.NewRecorder()
+	server.FailuresHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/parse-failures", nil))
+	var served []Failure
+	c.Assert(json.Unmarshal(rec.Body.Bytes(), &served), IsNil)
+	c.Check(served, HasLen, 2)
+}
+
+func (s *ServerSuite) TestParseErrorQuarantineWithoutWriter(c *C) {
+	handler := new(HandlerMock)
+	server := NewServer()
+	server.SetFormat(&format.CiscoXR{})
+	server.SetHandler(handler)
+	server.SetParseErrorPolicy(ParseErrorQuarantine)
+	server.SetRecentFailures(-1)
+	c.Assert(server.Listen(ListenerConfig{Name: "q", Protocol: "udp", Address: "127.0.0.1:0"}), IsNil)
+	defer server.Kill()
+	c.Check(server.Boot(), ErrorMatches, "listener q: quarantine parse error policy without a quarantine writer")
+
+	server.parser([]byte("garbage"), origin{client: "10.0.0.1:514"})
+	c.Check(handler.LastLogParts, IsNil)
+	c.Check(server.RecentFailures(), HasLen, 0)
+	c.Check(server.GetLastError(), Equals, format.ErrCiscoXRNoMatch)
+}
+
+func (s *ServerSuite) TestParseErrorQuarantineFull(c *C) {
+	server := NewServer()
+	server.SetFormat(&format.CiscoXR{})
+	server.SetHandler(new(HandlerMock))
+	server.SetParseErrorPolicy(ParseErrorQuarantine)
+	server.SetQuarantineWriter(&quarantineMock{})
+
+	// nothing drains the queue before Boot
+	for i := 0; i <= quarantineQueueSize; i++ {
+		server.parser([]byte("garbage"), origin{client: "10.0.0.1:514"})
+	}
+	c.Check(server.quarantineQueue, HasLen, quarantineQueueSize)
+}
+
+type handlerCollector struct {
+	parts chan format.LogParts
+}
+
+func (h *handlerCollector) Handle(logParts format.LogParts, msgLen int64, err error) {
+	h.parts <- logParts
+}
+
+func (s *ServerSuite) TestListen(c *C) {
+	handler := &handlerCollector{parts: make(chan format.LogParts, 3)}
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(handler)
+	c.Assert(server.Listen(ListenerConfig{Name: "udp-5424", Protocol: "udp", Address: "127.0.0.1:0", Format: RFC5424, SocketSize: 1 << 20}), IsNil)
+	c.Assert(server.Listen(ListenerConfig{Protocol: "tcp", Address: "127.0.0.1:0", Timeout: time.Second}), IsNil)
+	sock := c.MkDir() + "/syslog.sock"
+	c.Assert(server.Listen(ListenerConfig{Name: "local", Protocol: "unix", Address: sock}), IsNil)
+	c.Check(server.Listen(ListenerConfig{Protocol: "sctp", Address: "127.0.0.1:0"}), ErrorMatches, `unknown listener protocol "sctp"`)
+	c.Check(server.Listen(ListenerConfig{Protocol: "tls", Address: "127.0.0.1:0"}), ErrorMatches, "tls listener without a TLS config")
+	c.Assert(server.Boot(), IsNil)
+
+	send := func(network, addr, msg string) {
+		conn, err := net.Dial(network, addr)
+		c.Assert(err, IsNil)
+		_, err = conn.Write([]byte(msg))
+		c.Assert(err, IsNil)
+		conn.Close()
+	}
+	send("udp", server.connections[0].LocalAddr().String(), exampleRFC5424Syslog)
+	tcpAddr := server.listeners[0].Addr().String()
+	send("tcp", tcpAddr, exampleSyslog+"\n")
+	send("unix", sock, exampleSyslog+"\n")
+
+	byListener := map[string]format.LogParts{}
+	for i := 0; i < 3; i++ {
+		select {
+		case parts := <-handler.parts:
+			byListener[parts["listener"].(string)] = parts
+		case <-time.After(2 * time.Second):
+			c.Fatal("timed out waiting for messages")
+		}
+	}
+	server.Kill()
+	server.Wait()
+
+	c.Assert(byListener["udp-5424"], NotNil)
+	c.Check(byListener["udp-5424"]["app_name"], Equals, "su")
+	c.Assert(byListener["tcp://"+tcpAddr], NotNil)
+	c.Check(byListener["tcp://"+tcpAddr]["tag"], Equals, "tag")
+	c.Check(byListener["tcp://"+tcpAddr]["message_length"], Equals, len(exampleSyslog)+1)
+	c.Assert(byListener["local"], NotNil)
+	c.Check(byListener["local"]["listener_protocol"], Equals, "unix")
+	c.Check(byListener["local"]["content"], Equals, "content")
+}
@@ -0,0 +1,192 @@
+package syslog
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/metajar/metalogger/internal/logger"
+	"github.com/metajar/metalogger/internal/metrics/prometheus"
+)
+
+const defaultSocketStatsInterval = 15 * time.Second
+
+// SocketStats are the kernel's view of a UDP socket. Drops, Overflows and
+// Queued are only available on Linux.
+type SocketStats struct {
+	Listener string
+	// Socket numbers the sockets of a listener with Workers
+	Socket  int
+	Address string
+	// RequestedBuffer is the receive buffer size asked for and ReceiveBuffer
+	// the one the kernel granted, which Linux doubles for its bookkeeping
+	RequestedBuffer int
+	ReceiveBuffer   int
+	// Drops is the datagrams the kernel dropped, from /proc/net/udp
+	Drops uint64
+	// Overflows is the same count as of the last datagram read, from
+	// SO_RXQ_OVFL
+	Overflows uint64
+	// Queued is the bytes waiting to be read
+	Queued uint64
+}
+
+// Clamped reports whether the kernel granted a smaller receive buffer than
+// requested, on Linux because of net.core.rmem_max
+func (st SocketStats) Clamped() bool {
+	return st.ReceiveBuffer > 0 && st.ReceiveBuffer < st.RequestedBuffer*receiveBufferOverhead
+}
+
+// udpSocket tracks a UDP socket for SocketStats
+type udpSocket struct {
+	// overflows is first to be 64 bit aligned for atomic on 32 bit platforms
+	overflows uint64
+	listener  string
+	index     int
+	address   string
+	requested int
+	granted   int
+	inode     uint64
+}
+
+// addUDPSocket asks the kernel for drop counts on the socket and warns if
+// its receive buffer is smaller than requested
+func (s *Server) addUDPSocket(name string, index int, conn *net.UDPConn, requested int) *udpSocket {
+	sock := &udpSocket{listener: name, index: index, address: conn.LocalAddr().String(), requested: requested}
+	if err := enableOverflows(conn); err != nil {
+		logger.SugarLogger.Warnw("could not enable udp drop counts", "listener", name, "error", err)
+	}
+	sock.granted = receiveBuffer(conn)
+	sock.inode = socketInode(conn)
+	if st := sock.stats(); st.Clamped() {
+		logger.SugarLogger.Warnw("udp receive buffer smaller than requested, raise net.core.rmem_max",
+			"listener", name, "address", sock.address, "requested", requested, "granted", sock.granted)
+	}
+	s.udpSockets = append(s.udpSockets, sock)
+	return sock
+}
+
+func (u *udpSocket) stats() SocketStats {
+	return SocketStats{
+		Listener:        u.listener,
+		Socket:          u.index,
+		Address:         u.address,
+		RequestedBuffer: u.requested,
+		ReceiveBuffer:   u.granted,
+		Overflows:       atomic.LoadUint64(&u.overflows),
+	}
+}
+
+// recordOverflows keeps the drop count from the control messages of a read
+func (u *udpSocket) recordOverflows(oob []byte) {
+	if u == nil || len(oob) == 0 {
+		return
+	}
+	if n, ok := parseOverflows(oob); ok {
+		atomic.StoreUint64(&u.overflows, uint64(n))
+	}
+}
+
+// readFrom reads a datagram along with the drop count where the platform
+// supports it
+func readFrom(packetconn net.PacketConn, buf, oob []byte, sock *udpSocket) (int, net.Addr, error) {
+	udp, ok := packetconn.(*net.UDPConn)
+	if sock == nil || oob == nil || !ok {
+		return packetconn.ReadFrom(buf)
+	}
+	n, oobn, _, addr, err := udp.ReadMsgUDP(buf, oob)
+	if err != nil {
+		return n, nil, err
+	}
+	sock.recordOverflows(oob[:oobn])
+	return n, addr, nil
+}
+
+// SetSocketStatsInterval Sets how often the UDP socket statistics are
+// exported to Prometheus, every 15 seconds by default
+func (s *Server) SetSocketStatsInterval(d time.Duration) {
+	s.socketStatsInterval = d
+}
+
+// SocketStats returns the statistics of every UDP socket and exports them
+// to Prometheus
+func (s *Server) SocketStats() []SocketStats {
+	proc := readProcNetUDP()
+	stats := make([]SocketStats, 0, len(s.udpSockets))
+	for _, sock := range s.udpSockets {
+		st := sock.stats()
+		if p, ok := proc[sock.inode]; ok && sock.inode != 0 {
+			st.Drops, st.Queued = p.drops, p.queued
+		}
+		socket := strconv.Itoa(st.Socket)
+		prometheus.UDPReceiveBufferRequested.WithLabelValues(st.Listener, socket).Set(float64(st.RequestedBuffer))
+		prometheus.UDPReceiveBuffer.WithLabelValues(st.Listener, socket).Set(float64(st.ReceiveBuffer))
+		prometheus.UDPDrops.WithLabelValues(st.Listener, socket).Set(float64(st.Drops))
+		prometheus.UDPOverflows.WithLabelValues(st.Listener, socket).Set(float64(st.Overflows))
+		prometheus.UDPQueued.WithLabelValues(st.Listener, socket).Set(float64(st.Queued))
+		stats = append(stats, st)
+	}
+	return stats
+}
+
+func (s *Server) goWatchSockets() {
+	interval := s.socketStatsInterval
+	if interval <= 0 {
+		interval = defaultSocketStatsInterval
+	}
+	if s.doneTcp == nil {
+		s.doneTcp = make(chan bool)
+	}
+	done := s.doneTcp
+	s.SocketStats()
+	s.wait.Add(1)
+	go func() {
+		defer s.wait.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				s.SocketStats()
+			}
+		}
+	}()
+}
+
+// procUDP is a socket's line of /proc/net/udp
+type procUDP struct {
+	drops  uint64
+	queued uint64
+}
+
+// parseProcNetUDP reads /proc/net/udp or udp6 into the sockets by inode:
+//
+//	sl  local_address rem_address   st tx_queue:rx_queue tr:tm->when retrnsmt   uid  timeout inode ref pointer drops
+func parseProcNetUDP(r io.Reader, sockets map[uint64]procUDP) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 13 || fields[0] == "sl" {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			continue
+		}
+		drops, err := strconv.ParseUint(fields[12], 10, 64)
+		if err != nil {
+			continue
+		}
+		var queued uint64
+		if i := strings.IndexByte(fields[4], ':'); i >= 0 {
+			queued, _ = strconv.ParseUint(fields[4][i+1:], 16, 64)
+		}
+		sockets[inode] = procUDP{drops: drops, queued: queued}
+	}
+}
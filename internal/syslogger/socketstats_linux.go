@@ -0,0 +1,89 @@
+package syslog
+
+import (
+	"net"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Linux reports twice the requested buffer size, the extra is for its
+// bookkeeping
+const receiveBufferOverhead = 2
+
+// overflowOOBSize fits the SO_RXQ_OVFL control message
+var overflowOOBSize = unix.CmsgSpace(4)
+
+// enableOverflows makes the kernel attach its drop count to datagrams
+func enableOverflows(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := raw.Control(func(fd uintptr) {
+		sockErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RXQ_OVFL, 1)
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// receiveBuffer is the receive buffer size the kernel granted
+func receiveBuffer(conn *net.UDPConn) int {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	var size int
+	raw.Control(func(fd uintptr) {
+		size, _ = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+	})
+	return size
+}
+
+// socketInode identifies the socket in /proc/net/udp
+func socketInode(conn *net.UDPConn) uint64 {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0
+	}
+	var st unix.Stat_t
+	var statErr error
+	raw.Control(func(fd uintptr) {
+		statErr = unix.Fstat(int(fd), &st)
+	})
+	if statErr != nil {
+		return 0
+	}
+	return st.Ino
+}
+
+// parseOverflows returns the drop count of a SO_RXQ_OVFL control message,
+// which the kernel only sends once it dropped something
+func parseOverflows(oob []byte) (uint32, bool) {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0, false
+	}
+	for _, m := range msgs {
+		if m.Header.Level == unix.SOL_SOCKET && m.Header.Type == unix.SO_RXQ_OVFL && len(m.Data) >= 4 {
+			return *(*uint32)(unsafe.Pointer(&m.Data[0])), true
+		}
+	}
+	return 0, false
+}
+
+func readProcNetUDP() map[uint64]procUDP {
+	sockets := map[uint64]procUDP{}
+	for _, name := range []string{"/proc/net/udp", "/proc/net/udp6"} {
+		f, err := os.Open(name)
+		if err != nil {
+			continue
+		}
+		parseProcNetUDP(f, sockets)
+		f.Close()
+	}
+	return sockets
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+// +build !linux
+
+package syslog
+
+import "net"
+
+const receiveBufferOverhead = 1
+
+// overflowOOBSize is zero as there is no SO_RXQ_OVFL
+var overflowOOBSize = 0
+
+func enableOverflows(conn *net.UDPConn) error {
+	return nil
+}
+
+func receiveBuffer(conn *net.UDPConn) int {
+	return 0
+}
+
+func socketInode(conn *net.UDPConn) uint64 {
+	return 0
+}
+
+func parseOverflows(oob []byte) (uint32, bool) {
+	return 0, false
+}
+
+func readProcNetUDP() map[uint64]procUDP {
+	return nil
+}
@@ -0,0 +1,88 @@
+package syslog
+
+import (
+	"bytes"
+	"net"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestParseProcNetUDP(c *C) {
+	proc := `   sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  283: 0100007F:0202 00000000:0000 07 00000000:00000340 00:00000000 00000000     0        0 41377 2 0000000000000000 17
+  284: 00000000:0044 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 12 2 0000000000000000 0
+  285: garbage
+`
+	sockets := map[uint64]procUDP{}
+	parseProcNetUDP(strings.NewReader(proc), sockets)
+	c.Check(sockets, DeepEquals, map[uint64]procUDP{
+		41377: {drops: 17, queued: 0x340},
+		12:    {},
+	})
+}
+
+func (s *ServerSuite) TestSocketStats(c *C) {
+	if runtime.GOOS != "linux" {
+		c.Skip("socket statistics need linux")
+	}
+	for _, workers := range []int{0, 1} {
+		handler := &handlerCollector{parts: make(chan format.LogParts, 1000)}
+		server := NewServer()
+		server.SetFormat(RFC3164)
+		server.SetHandler(handler)
+		c.Assert(server.Listen(ListenerConfig{Name: "small", Protocol: "udp", Address: "127.0.0.1:0", SocketSize: 4096, Workers: workers}), IsNil)
+		c.Assert(server.Listen(ListenerConfig{Name: "huge", Protocol: "udp", Address: "127.0.0.1:0", SocketSize: 1 << 30}), IsNil)
+
+		stats := server.SocketStats()
+		c.Assert(stats, HasLen, 2)
+		c.Check(stats[0].Listener, Equals, "small")
+		c.Check(stats[0].RequestedBuffer, Equals, 4096)
+		c.Check(stats[0].ReceiveBuffer, Equals, 8192)
+		c.Check(stats[0].Clamped(), Equals, false)
+		c.Check(stats[1].Clamped(), Equals, true, Commentf("granted %d", stats[1].ReceiveBuffer))
+
+		// overflow the small buffer before anything reads it
+		conn, err := net.Dial("udp", stats[0].Address)
+		c.Assert(err, IsNil)
+		msg := append([]byte(exampleSyslog+" "), bytes.Repeat([]byte("x"), 1000)...)
+		for i := 0; i < 100; i++ {
+			conn.Write(msg)
+		}
+		stats = server.SocketStats()
+		c.Check(stats[0].Drops > 0, Equals, true)
+		c.Check(stats[0].Queued > 0, Equals, true)
+		c.Check(stats[0].Overflows, Equals, uint64(0))
+
+		// datagrams queued after the drops carry the count
+		c.Assert(server.Boot(), IsNil)
+		deadline := time.Now().Add(2 * time.Second)
+		for stats[0].Overflows == 0 && time.Now().Before(deadline) {
+			conn.Write(msg)
+			time.Sleep(10 * time.Millisecond)
+			stats = server.SocketStats()
+		}
+		c.Check(stats[0].Overflows, Equals, stats[0].Drops, Commentf("workers %d", workers))
+		conn.Close()
+		server.Kill()
+		server.Wait()
+	}
+}
+
+func (s *ServerSuite) TestSocketStatsUnnamed(c *C) {
+	if runtime.GOOS != "linux" {
+		c.Skip("socket statistics need linux")
+	}
+	for _, workers := range []int{0, 2} {
+		server := NewServer()
+		c.Assert(server.Listen(ListenerConfig{Protocol: "udp", Address: "127.0.0.1:0", Workers: workers}), IsNil)
+		for _, st := range server.SocketStats() {
+			c.Check(st.Listener, Equals, "udp://"+st.Address, Commentf("workers %d", workers))
+		}
+		c.Check(server.SocketStats(), Not(HasLen), 0)
+		server.Kill()
+	}
+}
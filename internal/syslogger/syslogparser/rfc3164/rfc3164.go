@@ -9,15 +9,16 @@ import (
 )
 
 type Parser struct {
-	buff     []byte
-	cursor   int
-	l        int
-	priority syslogparser.Priority
-	version  int
-	header   header
-	message  rfc3164message
-	location *time.Location
-	skipTag  bool
+	buff       []byte
+	cursor     int
+	l          int
+	priority   syslogparser.Priority
+	version    int
+	header     header
+	message    rfc3164message
+	location   *time.Location
+	receivedAt time.Time
+	skipTag    bool
 }
 
 type header struct {
@@ -39,19 +40,39 @@ func NewParser(buff []byte) *Parser {
 	}
 }
 
+// Location sets the zone of timestamps without one, nil means UTC
 func (p *Parser) Location(location *time.Location) {
+	if location == nil {
+		location = time.UTC
+	}
 	p.location = location
 }
 
+// ReceivedAt sets when the message was received, timestamps without a year
+// get the one that puts them closest to it and messages without a priority or
+// a known timestamp are stamped with it. Defaults to the time of parsing.
+func (p *Parser) ReceivedAt(t time.Time) {
+	p.receivedAt = t
+}
+
+// now returns when the message was received, or the current time if that
+// was not set.
+func (p *Parser) now() time.Time {
+	if p.receivedAt.IsZero() {
+		return time.Now()
+	}
+	return p.receivedAt
+}
+
 func (p *Parser) Parse() error {
 	tcursor := p.cursor
 	pri, err := p.parsePriority()
 	if err != nil {
 		// RFC3164 sec 4.3.3
-		p.priority = syslogparser.Priority{13, syslogparser.Facility{Value: 1}, syslogparser.Severity{Value: 5}}
+		p.priority = syslogparser.Priority{P: 13, F: syslogparser.Facility{Value: 1}, S: syslogparser.Severity{Value: 5}}
 		p.cursor = tcursor
 		content, err := p.parseContent()
-		p.header.timestamp = time.Now().Round(time.Second)
+		p.header.timestamp = p.now().Round(time.Second)
 		if err != syslogparser.ErrEOL {
 			return err
 		}
@@ -63,7 +84,7 @@ func (p *Parser) Parse() error {
 	hdr, err := p.parseHeader()
 	if err == syslogparser.ErrTimestampUnknownFormat {
 		// RFC3164 sec 4.3.2.
-		hdr.timestamp = time.Now().Round(time.Second)
+		hdr.timestamp = p.now().Round(time.Second)
 		// No tag processing should be done
 		p.skipTag = true
 		// Reset cursor for content read
@@ -193,7 +214,9 @@ func (p *Parser) parseTimestamp() (time.Time, error) {
 		return ts, syslogparser.ErrTimestampUnknownFormat
 	}
 
-	fixTimestampIfNeeded(&ts)
+	if ts.Year() == 0 {
+		ts = syslogparser.InferYear(ts, p.now())
+	}
 
 	p.cursor += tsFmtLen
 
@@ -276,17 +299,3 @@ func (p *Parser) parseContent() (string, error) {
 
 	return string(content), syslogparser.ErrEOL
 }
-
-func fixTimestampIfNeeded(ts *time.Time) {
-	now := time.Now()
-	y := ts.Year()
-
-	if ts.Year() == 0 {
-		y = now.Year()
-	}
-
-	newTs := time.Date(y, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(),
-		ts.Second(), ts.Nanosecond(), ts.Location())
-
-	*ts = newTs
-}
@@ -14,6 +14,10 @@ func Test(t *testing.T) { TestingT(t) }
 type Rfc3164TestSuite struct {
 }
 
+// receivedAt pins the receive time so timestamps without a year land in the
+// current one.
+var receivedAt = time.Date(time.Now().Year(), time.October, 20, 0, 0, 0, 0, time.UTC)
+
 var (
 	_ = Suite(&Rfc3164TestSuite{})
 
@@ -35,6 +39,7 @@ func (s *Rfc3164TestSuite) TestParser_Valid(c *C) {
 
 	c.Assert(p, DeepEquals, expectedP)
 
+	p.ReceivedAt(receivedAt)
 	err := p.Parse()
 	c.Assert(err, IsNil)
 
@@ -67,6 +72,7 @@ func (s *Rfc3164TestSuite) TestParser_ValidNoTag(c *C) {
 
 	c.Assert(p, DeepEquals, expectedP)
 
+	p.ReceivedAt(receivedAt)
 	err := p.Parse()
 	c.Assert(err, IsNil)
 
@@ -100,19 +106,13 @@ func (s *Rfc3164TestSuite) TestParser_NoTimestamp(c *C) {
 
 	c.Assert(p, DeepEquals, expectedP)
 
+	p.ReceivedAt(receivedAt)
 	err := p.Parse()
 	c.Assert(err, IsNil)
 
-	now := time.Now()
-
 	obtained := p.Dump()
-
-	obtainedTime := obtained["timestamp"].(time.Time)
-	s.assertTimeIsCloseToNow(c, obtainedTime)
-
-	obtained["timestamp"] = now // XXX: Need to mock out time to test this fully
 	expected := syslogparser.LogParts{
-		"timestamp": now,
+		"timestamp": receivedAt,
 		"hostname":  "",
 		"tag":       "",
 		"content":   "INFO     leaving (1) step postscripts",
@@ -138,18 +138,13 @@ func (s *Rfc3164TestSuite) TestParser_NoPriority(c *C) {
 
 	c.Assert(p, DeepEquals, expectedP)
 
+	p.ReceivedAt(receivedAt)
 	err := p.Parse()
 	c.Assert(err, IsNil)
 
-	now := time.Now()
-
 	obtained := p.Dump()
-	obtainedTime := obtained["timestamp"].(time.Time)
-	s.assertTimeIsCloseToNow(c, obtainedTime)
-
-	obtained["timestamp"] = now // XXX: Need to mock out time to test this fully
 	expected := syslogparser.LogParts{
-		"timestamp": now,
+		"timestamp": receivedAt,
 		"hostname":  "",
 		"tag":       "",
 		"content":   "Oct 11 22:14:15 Testing no priority",
@@ -161,6 +156,19 @@ func (s *Rfc3164TestSuite) TestParser_NoPriority(c *C) {
 	c.Assert(obtained, DeepEquals, expected)
 }
 
+// Without a receive time the messages above are stamped with the time of
+// parsing.
+func (s *Rfc3164TestSuite) TestParser_NoReceivedAt(c *C) {
+	for _, buff := range []string{
+		"<14>INFO     leaving (1) step postscripts",
+		"Oct 11 22:14:15 Testing no priority",
+	} {
+		p := NewParser([]byte(buff))
+		c.Assert(p.Parse(), IsNil)
+		s.assertTimeIsCloseToNow(c, p.Dump()["timestamp"].(time.Time))
+	}
+}
+
 func (s *Rfc3164TestSuite) TestParseHeader_Valid(c *C) {
 	buff := []byte("Oct 11 22:14:15 mymachine ")
 	now := time.Now()
@@ -197,6 +205,7 @@ func (s *Rfc3164TestSuite) TestParseHeader_RFC3339Timestamp(c *C) {
 func (s *Rfc3164TestSuite) TestParser_ValidRFC3339Timestamp(c *C) {
 	buff := []byte("<34>2018-01-12T22:14:15+00:00 mymachine app[101]: msg")
 	p := NewParser(buff)
+	p.ReceivedAt(receivedAt)
 	err := p.Parse()
 	c.Assert(err, IsNil)
 	obtained := p.Dump()
@@ -386,6 +395,7 @@ func (s *Rfc3164TestSuite) BenchmarkParsemessage(c *C) {
 
 func (s *Rfc3164TestSuite) assertTimestamp(c *C, ts time.Time, b []byte, expC int, e error) {
 	p := NewParser(b)
+	p.ReceivedAt(receivedAt)
 	obtained, err := p.parseTimestamp()
 	c.Assert(obtained, Equals, ts)
 	c.Assert(p.cursor, Equals, expC)
@@ -402,6 +412,7 @@ func (s *Rfc3164TestSuite) assertTag(c *C, t string, b []byte, expC int, e error
 
 func (s *Rfc3164TestSuite) assertRfc3164Header(c *C, hdr header, b []byte, expC int, e error) {
 	p := NewParser(b)
+	p.ReceivedAt(receivedAt)
 	obtained, err := p.parseHeader()
 	c.Assert(err, Equals, e)
 	c.Assert(obtained, Equals, hdr)
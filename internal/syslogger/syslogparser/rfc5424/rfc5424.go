@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"math"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/metajar/metalogger/internal/syslogger/syslogparser"
@@ -30,14 +31,38 @@ var (
 	ErrInvalidProcId     = &syslogparser.ParserError{ErrorString: "Invalid proc ID"}
 	ErrInvalidMsgId      = &syslogparser.ParserError{ErrorString: "Invalid msg ID"}
 	ErrNoStructuredData  = &syslogparser.ParserError{ErrorString: "No structured data"}
+	ErrInvalidSDID       = &syslogparser.ParserError{ErrorString: "Invalid structured data ID"}
+	ErrInvalidSDParam    = &syslogparser.ParserError{ErrorString: "Invalid structured data param"}
 )
 
+// IANA registered SD-IDs, https://tools.ietf.org/html/rfc5424#section-7
+const (
+	SDTimeQuality = "timeQuality"
+	SDOrigin      = "origin"
+	SDMeta        = "meta"
+)
+
+// StructuredData maps SD-ID -> PARAM-NAME -> PARAM-VALUEs. Values are
+// unescaped and in the order received, a param may be repeated within an
+// element (origin may carry several ip params).
+type StructuredData map[string]map[string][]string
+
+// Get returns the first value of a param
+func (sd StructuredData) Get(id, name string) (string, bool) {
+	values := sd[id][name]
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
 type Parser struct {
 	buff           []byte
 	cursor         int
 	l              int
 	header         header
 	structuredData string
+	sdElements     StructuredData
 	message        string
 }
 
@@ -81,6 +106,10 @@ func (p *Parser) Location(location *time.Location) {
 	// Ignore as RFC5424 syslog always has a timezone
 }
 
+func (p *Parser) ReceivedAt(t time.Time) {
+	// Ignore as RFC5424 syslog always has a year
+}
+
 func (p *Parser) Parse() error {
 	hdr, err := p.parseHeader()
 	if err != nil {
@@ -89,12 +118,25 @@ func (p *Parser) Parse() error {
 
 	p.header = hdr
 
+	from := p.cursor
 	sd, err := p.parseStructuredData()
 	if err != nil {
-		return err
+		// Structured data that does not follow the RFC, such as unquoted
+		// param values, is kept as raw structured_data without sd_elements,
+		// as the parser did before it parsed the elements
+		p.cursor = from
+		raw, err := scanStructuredData(p.buff, &p.cursor, p.l)
+		if err != nil {
+			return err
+		}
+		p.structuredData = raw
+	} else {
+		p.sdElements = sd
+		p.structuredData = "-"
+		if sd != nil {
+			p.structuredData = string(p.buff[from:p.cursor])
+		}
 	}
-
-	p.structuredData = sd
 	p.cursor++
 
 	if p.cursor < p.l {
@@ -116,6 +158,7 @@ func (p *Parser) Dump() syslogparser.LogParts {
 		"proc_id":         p.header.procId,
 		"msg_id":          p.header.msgId,
 		"structured_data": p.structuredData,
+		"sd_elements":     p.sdElements,
 		"message":         p.message,
 	}
 }
@@ -257,8 +300,8 @@ func (p *Parser) parseMsgId() (string, error) {
 	return parseUpToLen(p.buff, &p.cursor, p.l, 32, ErrInvalidMsgId)
 }
 
-func (p *Parser) parseStructuredData() (string, error) {
-	return parseStructuredData(p.buff, &p.cursor, p.l)
+func (p *Parser) parseStructuredData() (StructuredData, error) {
+	return parseSDElements(p.buff, &p.cursor, p.l)
 }
 
 // ----------------------------------------------
@@ -531,10 +574,23 @@ func toNSec(sec float64) (int, error) {
 // https://tools.ietf.org/html/rfc5424#section-6.3
 // ------------------------------------------------
 
+// parseStructuredData returns the raw STRUCTURED-DATA section
 func parseStructuredData(buff []byte, cursor *int, l int) (string, error) {
-	var sdData string
-	var found bool
+	from := *cursor
+	sd, err := parseSDElements(buff, cursor, l)
+	if err != nil {
+		*cursor = from
+		return scanStructuredData(buff, cursor, l)
+	}
+	if sd == nil {
+		return "-", nil
+	}
+	return string(buff[from:*cursor]), nil
+}
 
+// scanStructuredData returns everything up to the first "]" followed by a
+// space or the end of the line, without looking at the elements
+func scanStructuredData(buff []byte, cursor *int, l int) (string, error) {
 	if *cursor >= l {
 		return "-", nil
 	}
@@ -545,35 +601,136 @@ func parseStructuredData(buff []byte, cursor *int, l int) (string, error) {
 	}
 
 	if buff[*cursor] != '[' {
-		return sdData, ErrNoStructuredData
+		return "", ErrNoStructuredData
 	}
 
 	from := *cursor
-	to := from
+	for to := from; to < l; to++ {
+		if buff[to] == ']' && (to+1 == l || buff[to+1] == ' ') {
+			*cursor = to + 1
+			return string(buff[from:*cursor]), nil
+		}
+	}
 
-	for to = from; to < l; to++ {
-		if found {
-			break
+	return "", ErrNoStructuredData
+}
+
+// STRUCTURED-DATA = NILVALUE / 1*SD-ELEMENT
+// SD-ELEMENT      = "[" SD-ID *(SP SD-PARAM) "]"
+// SD-PARAM        = PARAM-NAME "=" %d34 PARAM-VALUE %d34
+//
+// XXX : spaces around "=" and missing spaces between params are tolerated
+func parseSDElements(buff []byte, cursor *int, l int) (StructuredData, error) {
+	if *cursor >= l {
+		return nil, nil
+	}
+
+	if buff[*cursor] == NILVALUE {
+		*cursor++
+		return nil, nil
+	}
+
+	if buff[*cursor] != '[' {
+		return nil, ErrNoStructuredData
+	}
+
+	sd := StructuredData{}
+	c := *cursor
+	for c < l && buff[c] == '[' {
+		c++
+		id := parseSDName(buff, &c, l)
+		if id == "" {
+			return nil, ErrInvalidSDID
+		}
+		params, ok := sd[id]
+		if !ok {
+			params = map[string][]string{}
+			sd[id] = params
 		}
 
-		b := buff[to]
+		for {
+			skipSpaces(buff, &c, l)
+			if c >= l {
+				return nil, ErrNoStructuredData
+			}
+			if buff[c] == ']' {
+				c++
+				break
+			}
+
+			name := parseSDName(buff, &c, l)
+			skipSpaces(buff, &c, l)
+			if name == "" || c >= l || buff[c] != '=' {
+				return nil, ErrInvalidSDParam
+			}
+			c++
+			skipSpaces(buff, &c, l)
 
-		if b == ']' {
-			switch t := to + 1; {
-			case t == l:
-				found = true
-			case t <= l && buff[t] == ' ':
-				found = true
+			value, err := parseParamValue(buff, &c, l)
+			if err != nil {
+				return nil, err
 			}
+			params[name] = append(params[name], value)
 		}
 	}
 
-	if found {
-		*cursor = to
-		return string(buff[from:to]), nil
+	// The last element must be followed by a space or the end of the line
+	if c < l && buff[c] != ' ' {
+		return nil, ErrNoStructuredData
 	}
 
-	return sdData, ErrNoStructuredData
+	*cursor = c
+	return sd, nil
+}
+
+// SD-NAME = 1*32PRINTUSASCII ; except '=', SP, ']', %d34 (")
+func parseSDName(buff []byte, cursor *int, l int) string {
+	from := *cursor
+	for *cursor < l {
+		b := buff[*cursor]
+		if b == '=' || b == ' ' || b == ']' || b == '"' || b < 33 || b > 126 {
+			break
+		}
+		*cursor++
+	}
+	return string(buff[from:*cursor])
+}
+
+// PARAM-VALUE = UTF-8-STRING ; characters '"', '\' and ']' MUST be escaped.
+// A backslash followed by any other character is kept as is.
+func parseParamValue(buff []byte, cursor *int, l int) (string, error) {
+	if *cursor >= l || buff[*cursor] != '"' {
+		return "", ErrInvalidSDParam
+	}
+	*cursor++
+
+	var b strings.Builder
+	for *cursor < l {
+		c := buff[*cursor]
+		switch {
+		case c == '\\' && *cursor+1 < l:
+			next := buff[*cursor+1]
+			if next != '"' && next != '\\' && next != ']' {
+				b.WriteByte(c)
+			}
+			b.WriteByte(next)
+			*cursor += 2
+		case c == '"':
+			*cursor++
+			return b.String(), nil
+		default:
+			b.WriteByte(c)
+			*cursor++
+		}
+	}
+
+	return "", ErrInvalidSDParam
+}
+
+func skipSpaces(buff []byte, cursor *int, l int) {
+	for *cursor < l && buff[*cursor] == ' ' {
+		*cursor++
+	}
 }
 
 func parseUpToLen(buff []byte, cursor *int, l int, maxLen int, e error) (string, error) {
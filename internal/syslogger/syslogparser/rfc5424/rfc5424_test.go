@@ -45,6 +45,7 @@ func (s *Rfc5424TestSuite) TestParser_Valid(c *C) {
 			"proc_id":         "-",
 			"msg_id":          "ID47",
 			"structured_data": "-",
+			"sd_elements":     StructuredData(nil),
 			"message":         "'su root' failed for lonvick on /dev/pts/8",
 		},
 		syslogparser.LogParts{
@@ -58,6 +59,7 @@ func (s *Rfc5424TestSuite) TestParser_Valid(c *C) {
 			"proc_id":         "8710",
 			"msg_id":          "-",
 			"structured_data": "-",
+			"sd_elements":     StructuredData(nil),
 			"message":         "%% It's time to make the do-nuts.",
 		},
 		syslogparser.LogParts{
@@ -71,6 +73,7 @@ func (s *Rfc5424TestSuite) TestParser_Valid(c *C) {
 			"proc_id":         "8710",
 			"msg_id":          "-",
 			"structured_data": "-",
+			"sd_elements":     StructuredData(nil),
 			"message":         "%% It's time to make the do-nuts.",
 		},
 		syslogparser.LogParts{
@@ -85,6 +88,9 @@ func (s *Rfc5424TestSuite) TestParser_Valid(c *C) {
 			"msg_id":          "ID47",
 			"structured_data": `[exampleSDID@32473 iut="3" eventSource="Application" eventID="1011"]`,
 			"message":         "An application event log entry...",
+			"sd_elements": StructuredData{
+				"exampleSDID@32473": {"iut": {"3"}, "eventSource": {"Application"}, "eventID": {"1011"}},
+			},
 		},
 		syslogparser.LogParts{
 			"priority":        165,
@@ -98,6 +104,10 @@ func (s *Rfc5424TestSuite) TestParser_Valid(c *C) {
 			"msg_id":          "ID47",
 			"structured_data": `[exampleSDID@32473 iut="3" eventSource= "Application" eventID="1011"][examplePriority@32473 class="high"]`,
 			"message":         "",
+			"sd_elements": StructuredData{
+				"exampleSDID@32473":     {"iut": {"3"}, "eventSource": {"Application"}, "eventID": {"1011"}},
+				"examplePriority@32473": {"class": {"high"}},
+			},
 		},
 		syslogparser.LogParts{
 			"priority":        165,
@@ -110,6 +120,7 @@ func (s *Rfc5424TestSuite) TestParser_Valid(c *C) {
 			"proc_id":         "-",
 			"msg_id":          "ID47",
 			"structured_data": "-",
+			"sd_elements":     StructuredData(nil),
 			"message":         "",
 		},
 	}
@@ -748,6 +759,87 @@ func (s *Rfc5424TestSuite) TestParseStructuredData_MultipleStructuredDataInvalid
 	s.assertParseSdName(c, a, buff, len(a), nil)
 }
 
+func (s *Rfc5424TestSuite) TestParseSDElements_Escapes(c *C) {
+	buff := []byte(`[exampleSDID@32473 quote="a \"b\"" slash="c:\\d" bracket="[e\]" other="f\g"] msg`)
+	cursor := 0
+	sd, err := parseSDElements(buff, &cursor, len(buff))
+	c.Assert(err, IsNil)
+	c.Assert(sd, DeepEquals, StructuredData{
+		"exampleSDID@32473": {
+			"quote":   {`a "b"`},
+			"slash":   {`c:\d`},
+			"bracket": {"[e]"},
+			"other":   {`f\g`},
+		},
+	})
+	c.Assert(string(buff[cursor:]), Equals, " msg")
+}
+
+func (s *Rfc5424TestSuite) TestParseSDElements_IANA(c *C) {
+	buff := []byte(`[timeQuality tzKnown="1" isSynced="1" syncAccuracy="60000"][origin ip="192.0.2.1" ip="198.51.100.1" software="junos"][meta sequenceId="12" sysUpTime="37"]`)
+	cursor := 0
+	sd, err := parseSDElements(buff, &cursor, len(buff))
+	c.Assert(err, IsNil)
+	c.Assert(sd[SDTimeQuality], DeepEquals, map[string][]string{"tzKnown": {"1"}, "isSynced": {"1"}, "syncAccuracy": {"60000"}})
+	c.Assert(sd[SDOrigin], DeepEquals, map[string][]string{"ip": {"192.0.2.1", "198.51.100.1"}, "software": {"junos"}})
+	c.Assert(sd[SDMeta], DeepEquals, map[string][]string{"sequenceId": {"12"}, "sysUpTime": {"37"}})
+	software, ok := sd.Get(SDOrigin, "software")
+	c.Assert(ok, Equals, true)
+	c.Assert(software, Equals, "junos")
+	_, ok = sd.Get(SDOrigin, "enterpriseId")
+	c.Assert(ok, Equals, false)
+	c.Assert(cursor, Equals, len(buff))
+}
+
+func (s *Rfc5424TestSuite) TestParseSDElements_BracketInValue(c *C) {
+	// An unescaped "] " inside a quoted value must not end the element
+	buff := []byte(`[x@1 a="b] c"] msg`)
+	cursor := 0
+	sd, err := parseSDElements(buff, &cursor, len(buff))
+	c.Assert(err, IsNil)
+	c.Assert(sd, DeepEquals, StructuredData{"x@1": {"a": {"b] c"}}})
+}
+
+func (s *Rfc5424TestSuite) TestParseSDElements_Invalid(c *C) {
+	fixtures := map[string]error{
+		`[ a="b"]`:     ErrInvalidSDID,
+		`[x@1 a=b]`:    ErrInvalidSDParam,
+		`[x@1 a="b]`:   ErrInvalidSDParam,
+		`[x@1 a="b"`:   ErrNoStructuredData,
+		`[x@1 a="b"]x`: ErrNoStructuredData,
+	}
+	for f, e := range fixtures {
+		cursor := 0
+		_, err := parseSDElements([]byte(f), &cursor, len(f))
+		c.Check(err, Equals, e, Commentf("fixture %s", f))
+	}
+}
+
+func (s *Rfc5424TestSuite) TestParseSDElements_RepeatedWithCommas(c *C) {
+	buff := []byte(`[x@1 tag="a,b" tag="c"]`)
+	cursor := 0
+	sd, err := parseSDElements(buff, &cursor, len(buff))
+	c.Assert(err, IsNil)
+	c.Assert(sd, DeepEquals, StructuredData{"x@1": {"tag": {"a,b", "c"}}})
+}
+
+func (s *Rfc5424TestSuite) TestParser_LenientStructuredData(c *C) {
+	// unquoted param values are not RFC5424, they are kept raw
+	buff := []byte(`<165>1 2003-10-11T22:14:15.003Z host app - ID47 [x@1 a=b] message`)
+	p := NewParser(buff)
+	c.Assert(p.Parse(), IsNil)
+	parts := p.Dump()
+	c.Check(parts["structured_data"], Equals, "[x@1 a=b]")
+	c.Check(parts["sd_elements"], DeepEquals, StructuredData(nil))
+	c.Check(parts["message"], Equals, "message")
+
+	cursor := 0
+	sd, err := parseStructuredData([]byte("[x@1 a=b] message"), &cursor, 17)
+	c.Check(err, IsNil)
+	c.Check(sd, Equals, "[x@1 a=b]")
+	c.Check(cursor, Equals, 9)
+}
+
 // -------------
 
 func (s *Rfc5424TestSuite) BenchmarkParseTimestamp(c *C) {
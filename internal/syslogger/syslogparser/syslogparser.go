@@ -36,6 +36,7 @@ type LogParser interface {
 	Parse() error
 	Dump() LogParts
 	Location(*time.Location)
+	ReceivedAt(time.Time)
 }
 
 type ParserError struct {
@@ -202,6 +203,29 @@ func ParseHostname(buff []byte, cursor *int, l int) (string, error) {
 	return string(hostname), nil
 }
 
+// InferYear gives a timestamp without a year the year that puts it closest to
+// now, so a December message received just after New Year lands in the
+// previous year and a device clock slightly ahead does not end up a year back.
+func InferYear(ts time.Time, now time.Time) time.Time {
+	now = now.In(ts.Location())
+	var best time.Time
+	for _, y := range []int{now.Year(), now.Year() - 1, now.Year() + 1} {
+		c := time.Date(y, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(),
+			ts.Second(), ts.Nanosecond(), ts.Location())
+		if best.IsZero() || abs(c.Sub(now)) < abs(best.Sub(now)) {
+			best = c
+		}
+	}
+	return best
+}
+
+func abs(d time.Duration) time.Duration {
+	if d < 0 {
+		return -d
+	}
+	return d
+}
+
 func ShowCursorPos(buff []byte, cursor int) {
 	fmt.Println(string(buff))
 	padding := strings.Repeat("-", cursor)
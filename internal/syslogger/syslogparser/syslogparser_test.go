@@ -2,6 +2,7 @@ package syslogparser
 
 import (
 	"testing"
+	"time"
 
 	. "gopkg.in/check.v1"
 )
@@ -171,3 +172,30 @@ func (s *CommonTestSuite) assertHostname(c *C, h string, b []byte, cursor int, e
 	c.Assert(cursor, Equals, expC)
 	c.Assert(err, Equals, e)
 }
+
+func (s *CommonTestSuite) TestInferYear(c *C) {
+	fixtures := []struct {
+		ts, now, expected time.Time
+	}{
+		// Received just after New Year.
+		{
+			time.Date(0, time.December, 31, 23, 59, 58, 0, time.UTC),
+			time.Date(2023, time.January, 1, 0, 0, 1, 0, time.UTC),
+			time.Date(2022, time.December, 31, 23, 59, 58, 0, time.UTC),
+		},
+		// Device clock a little ahead at New Year.
+		{
+			time.Date(0, time.January, 1, 0, 0, 5, 0, time.UTC),
+			time.Date(2022, time.December, 31, 23, 59, 59, 0, time.UTC),
+			time.Date(2023, time.January, 1, 0, 0, 5, 0, time.UTC),
+		},
+		{
+			time.Date(0, time.June, 15, 12, 0, 0, 0, time.UTC),
+			time.Date(2022, time.June, 16, 0, 0, 0, 0, time.UTC),
+			time.Date(2022, time.June, 15, 12, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, f := range fixtures {
+		c.Check(InferYear(f.ts, f.now), Equals, f.expected)
+	}
+}
@@ -0,0 +1,183 @@
+package syslog
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// ClientIdentity is the verified identity of a TLS client
+type ClientIdentity struct {
+	Subject string
+	SANs    []string
+	// SPKI is the hex SHA-256 of the certificate's SubjectPublicKeyInfo
+	SPKI   string
+	Tenant string
+	// Verified is set if the certificate chains to a client CA. Unverified
+	// certificates only match rules pinning their SPKI.
+	Verified bool
+}
+
+// identityOf returns the identity of the client certificate, ok is false if
+// the client did not present one
+func identityOf(state tls.ConnectionState) (id ClientIdentity, ok bool) {
+	if len(state.PeerCertificates) == 0 {
+		return id, false
+	}
+	id.Verified = len(state.VerifiedChains) > 0
+	cert := state.PeerCertificates[0]
+	id.Subject = cert.Subject.String()
+	id.SANs = certSANs(cert)
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	id.SPKI = hex.EncodeToString(sum[:])
+	return id, true
+}
+
+func certSANs(cert *x509.Certificate) []string {
+	sans := append([]string(nil), cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
+// TLSRule authorizes the client certificates it matches. All the criteria
+// that are set must match.
+type TLSRule struct {
+	Name string `json:"name"`
+	// Subject is a regular expression for the subject, e.g.
+	// "CN=core1.example.net,O=Example"
+	Subject string `json:"subject"`
+	// SAN is a regular expression any DNS, email, IP or URI SAN must match
+	SAN string `json:"san"`
+	// SPKI pins the hex SHA-256 of the SubjectPublicKeyInfo
+	SPKI string `json:"spki"`
+	// Sources restricts the networks the client may connect from
+	Sources []string `json:"sources"`
+	// Tenant is attached to the messages of the clients the rule matches
+	Tenant string `json:"tenant"`
+}
+
+type tlsRule struct {
+	TLSRule
+	subject *regexp.Regexp
+	san     *regexp.Regexp
+	sources []*net.IPNet
+}
+
+// TLSAuthorizer decides which client certificates may send to a TLS
+// listener. Clients no rule matches are rejected.
+type TLSAuthorizer struct {
+	rules []tlsRule
+}
+
+// NewTLSAuthorizer returns an error if a rule is invalid
+func NewTLSAuthorizer(rules []TLSRule) (*TLSAuthorizer, error) {
+	a := &TLSAuthorizer{}
+	for i, r := range rules {
+		name := r.Name
+		if name == "" {
+			name = fmt.Sprint(i)
+		}
+		rule := tlsRule{TLSRule: r}
+		rule.SPKI = strings.ToLower(strings.ReplaceAll(r.SPKI, ":", ""))
+		var err error
+		if r.Subject != "" {
+			if rule.subject, err = regexp.Compile(r.Subject); err != nil {
+				return nil, fmt.Errorf("rule %s: subject: %w", name, err)
+			}
+		}
+		if r.SAN != "" {
+			if rule.san, err = regexp.Compile(r.SAN); err != nil {
+				return nil, fmt.Errorf("rule %s: san: %w", name, err)
+			}
+		}
+		for _, cidr := range r.Sources {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("rule %s: %w", name, err)
+			}
+			rule.sources = append(rule.sources, n)
+		}
+		a.rules = append(a.rules, rule)
+	}
+	return a, nil
+}
+
+// LoadTLSRules reads a JSON list of rules
+func LoadTLSRules(path string) ([]TLSRule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []TLSRule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return rules, nil
+}
+
+// Authorize returns the first rule matching the client, ip is the address it
+// connected from
+func (a *TLSAuthorizer) Authorize(id ClientIdentity, ip string) (TLSRule, bool) {
+	addr := net.ParseIP(ip)
+	for _, r := range a.rules {
+		if r.matches(id, addr) {
+			return r.TLSRule, true
+		}
+	}
+	return TLSRule{}, false
+}
+
+func (r tlsRule) matches(id ClientIdentity, addr net.IP) bool {
+	// Anyone can present a certificate with any subject, only the pin
+	// identifies a certificate nobody vouched for.
+	if !id.Verified && r.SPKI == "" {
+		return false
+	}
+	if r.subject != nil && !r.subject.MatchString(id.Subject) {
+		return false
+	}
+	if r.SPKI != "" && r.SPKI != id.SPKI {
+		return false
+	}
+	if r.san != nil {
+		found := false
+		for _, san := range id.SANs {
+			if r.san.MatchString(san) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.sources) > 0 {
+		if addr == nil {
+			return false
+		}
+		found := false
+		for _, n := range r.sources {
+			if n.Contains(addr) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,144 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/metajar/metalogger/internal/syslogger/format"
+	. "gopkg.in/check.v1"
+)
+
+func (s *ServerSuite) TestTLSAuthorizer(c *C) {
+	ca := newTestCert(c, nil, "ca")
+	core := newTestCert(c, ca, "core1", "core1.example.net")
+	chain := []*x509.Certificate{core.cert, ca.cert}
+	id, ok := identityOf(tls.ConnectionState{PeerCertificates: chain[:1], VerifiedChains: [][]*x509.Certificate{chain}})
+	c.Assert(ok, Equals, true)
+	c.Check(id.Verified, Equals, true)
+	c.Check(id.Subject, Equals, "CN=core1,O=Example")
+	c.Check(id.SANs, DeepEquals, []string{"core1.example.net"})
+	c.Check(id.SPKI, HasLen, 64)
+	_, ok = identityOf(tls.ConnectionState{})
+	c.Check(ok, Equals, false)
+
+	a, err := NewTLSAuthorizer([]TLSRule{
+		{Name: "pinned", SPKI: "00:11", Tenant: "nobody"},
+		{Name: "lab", SAN: `\.lab\.example\.net$`, Tenant: "lab"},
+		{Name: "core", Subject: `^CN=core\d+,`, Sources: []string{"10.0.0.0/8"}, Tenant: "backbone"},
+	})
+	c.Assert(err, IsNil)
+	rule, ok := a.Authorize(id, "10.1.2.3")
+	c.Check(ok, Equals, true)
+	c.Check(rule.Tenant, Equals, "backbone")
+	_, ok = a.Authorize(id, "192.0.2.1")
+	c.Check(ok, Equals, false)
+
+	// without a verified chain only a pin matches
+	unverified, ok := identityOf(tls.ConnectionState{PeerCertificates: chain[:1]})
+	c.Assert(ok, Equals, true)
+	c.Check(unverified.Verified, Equals, false)
+	_, ok = a.Authorize(unverified, "10.1.2.3")
+	c.Check(ok, Equals, false)
+
+	a, err = NewTLSAuthorizer([]TLSRule{{SPKI: id.SPKI, Tenant: "pinned"}})
+	c.Assert(err, IsNil)
+	rule, ok = a.Authorize(id, "")
+	c.Check(ok, Equals, true)
+	c.Check(rule.Tenant, Equals, "pinned")
+	rule, ok = a.Authorize(unverified, "")
+	c.Check(ok, Equals, true)
+	c.Check(rule.Tenant, Equals, "pinned")
+
+	_, err = NewTLSAuthorizer([]TLSRule{{Name: "bad", Subject: "("}})
+	c.Check(err, ErrorMatches, "rule bad: subject: .*")
+	_, err = NewTLSAuthorizer([]TLSRule{{Sources: []string{"10.0.0.0/33"}}})
+	c.Check(err, ErrorMatches, "rule 0: invalid CIDR address: 10.0.0.0/33")
+}
+
+func (s *ServerSuite) TestLoadTLSRules(c *C) {
+	path := filepath.Join(c.MkDir(), "rules.json")
+	c.Assert(os.WriteFile(path, []byte(`[{"name": "core", "san": "^core", "sources": ["10.0.0.0/8"], "tenant": "backbone"}]`), 0644), IsNil)
+	rules, err := LoadTLSRules(path)
+	c.Assert(err, IsNil)
+	c.Check(rules, DeepEquals, []TLSRule{{Name: "core", SAN: "^core", Sources: []string{"10.0.0.0/8"}, Tenant: "backbone"}})
+}
+
+func (s *ServerSuite) TestAuthorizerNeedsClientCerts(c *C) {
+	ca := newTestCert(c, nil, "ca")
+	files := writeTLSFiles(c, c.MkDir(), newTestCert(c, ca, "syslog", "syslog.example.net"), ca)
+	authorizer, err := NewTLSAuthorizer([]TLSRule{{SAN: `^core\d+\.`}})
+	c.Assert(err, IsNil)
+	noClientCA := files
+	noClientCA.ClientCAFile = ""
+
+	server := NewServer()
+	c.Check(server.Listen(ListenerConfig{Protocol: "tls", Address: "127.0.0.1:0", TLSFiles: &noClientCA, Authorizer: authorizer}),
+		ErrorMatches, "tls listener with an authorizer but without client certificates.*")
+	c.Check(server.Listen(ListenerConfig{Protocol: "tls", Address: "127.0.0.1:0", TLSConfig: &tls.Config{}, Authorizer: authorizer}),
+		ErrorMatches, "tls listener with an authorizer but without client certificates.*")
+	c.Check(server.Listen(ListenerConfig{Protocol: "tcp", Address: "127.0.0.1:0", Authorizer: authorizer}),
+		ErrorMatches, "authorizer on a listener without TLS")
+	c.Check(server.listeners, HasLen, 0)
+
+	pinning := &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	c.Assert(server.Listen(ListenerConfig{Protocol: "tls", Address: "127.0.0.1:0", TLSFiles: &noClientCA, TLSConfig: pinning, Authorizer: authorizer}), IsNil)
+	c.Assert(server.Listen(ListenerConfig{Protocol: "tls", Address: "127.0.0.1:0", TLSFiles: &files, Authorizer: authorizer}), IsNil)
+	server.Kill()
+}
+
+func (s *ServerSuite) TestMutualTLS(c *C) {
+	ca := newTestCert(c, nil, "ca")
+	files := writeTLSFiles(c, c.MkDir(), newTestCert(c, ca, "syslog", "syslog.example.net"), ca)
+	authorizer, err := NewTLSAuthorizer([]TLSRule{{SAN: `^core\d+\.`, Sources: []string{"127.0.0.0/8"}, Tenant: "backbone"}})
+	c.Assert(err, IsNil)
+
+	handler := &handlerCollector{parts: make(chan format.LogParts, 2)}
+	server := NewServer()
+	server.SetFormat(RFC3164)
+	server.SetHandler(handler)
+	c.Assert(server.Listen(ListenerConfig{Protocol: "tls", Address: "127.0.0.1:0", TLSFiles: &files, Authorizer: authorizer}), IsNil)
+	c.Assert(server.Boot(), IsNil)
+	addr := server.listeners[0].Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	send := func(client *testCert) {
+		config := &tls.Config{RootCAs: roots, ServerName: "syslog.example.net"}
+		if client != nil {
+			config.Certificates = []tls.Certificate{client.tlsCertificate(c)}
+		}
+		conn, err := tls.Dial("tcp", addr, config)
+		if err != nil {
+			return
+		}
+		io.WriteString(conn, exampleSyslog+"\n")
+		conn.Close()
+	}
+	send(nil)
+	send(newTestCert(c, ca, "edge1", "edge1.example.net"))
+	send(newTestCert(c, newTestCert(c, nil, "rogue"), "core2", "core2.example.net"))
+	core := newTestCert(c, ca, "core1", "core1.example.net")
+	send(core)
+
+	select {
+	case parts := <-handler.parts:
+		c.Check(parts["tls_tenant"], Equals, "backbone")
+		c.Check(parts["tls_subject"], Equals, "CN=core1,O=Example")
+		c.Check(parts["tls_san"], DeepEquals, []string{"core1.example.net"})
+		c.Check(parts["tls_peer"], Equals, "core1")
+		c.Check(parts["content"], Equals, "content")
+	case <-time.After(2 * time.Second):
+		c.Fatal("timed out waiting for the authorized client")
+	}
+	select {
+	case parts := <-handler.parts:
+		c.Errorf("unexpected message from %v", parts["tls_subject"])
+	case <-time.After(100 * time.Millisecond):
+	}
+	server.Kill()
+	server.Wait()
+}
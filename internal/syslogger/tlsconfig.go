@@ -0,0 +1,167 @@
+package syslog
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/metajar/metalogger/internal/metrics/prometheus"
+)
+
+// TLSFiles configures a TLS listener from PEM files, which are reloaded when
+// they change
+type TLSFiles struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile enables mutual TLS, clients must present a certificate
+	// signed by one of these CAs
+	ClientCAFile string
+	// ReloadInterval is how often the files are checked for changes,
+	// defaults to a minute
+	ReloadInterval time.Duration
+}
+
+// CertReloader serves the certificate and client CAs of TLSFiles, picking up
+// rotated files without a restart. A failed reload keeps the previous ones.
+type CertReloader struct {
+	files TLSFiles
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+
+	watcher *fileWatcher
+}
+
+// NewCertReloader loads the files, it returns an error if they are not valid
+func NewCertReloader(files TLSFiles) (*CertReloader, error) {
+	if files.CertFile == "" || files.KeyFile == "" {
+		return nil, errors.New("tls files without a certificate or key")
+	}
+	if files.ReloadInterval == 0 {
+		files.ReloadInterval = time.Minute
+	}
+	r := &CertReloader{files: files}
+	r.watcher = newFileWatcher("tls files", r.paths(), r.read, prometheus.TLSReloads)
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload reads the files again
+func (r *CertReloader) Reload() error {
+	return r.watcher.load()
+}
+
+func (r *CertReloader) read() error {
+	cert, err := tls.LoadX509KeyPair(r.files.CertFile, r.files.KeyFile)
+	if err != nil {
+		return err
+	}
+	var pool *x509.CertPool
+	if r.files.ClientCAFile != "" {
+		b, err := os.ReadFile(r.files.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return fmt.Errorf("no certificates in %s", r.files.ClientCAFile)
+		}
+	}
+
+	r.mu.Lock()
+	r.cert, r.pool = &cert, pool
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *CertReloader) paths() []string {
+	paths := []string{r.files.CertFile, r.files.KeyFile}
+	if r.files.ClientCAFile != "" {
+		paths = append(paths, r.files.ClientCAFile)
+	}
+	return paths
+}
+
+// Watch checks the files for changes every ReloadInterval until Stop
+func (r *CertReloader) Watch() {
+	r.watcher.watch(r.files.ReloadInterval)
+}
+
+// Stop stops Watch
+func (r *CertReloader) Stop() {
+	r.watcher.Stop()
+}
+
+// Config returns a tls.Config based on base, which may be nil, that always
+// uses the current certificate and client CAs
+func (r *CertReloader) Config(base *tls.Config) *tls.Config {
+	if base == nil {
+		base = &tls.Config{}
+	}
+	config := base.Clone()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mu.RLock()
+		defer r.mu.RUnlock()
+		c := base.Clone()
+		c.Certificates = []tls.Certificate{*r.cert}
+		if r.pool != nil {
+			c.ClientCAs = r.pool
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+		return c, nil
+	}
+	return config
+}
+
+// TLSPolicy is a preset of TLS versions and cipher suites
+type TLSPolicy int
+
+const (
+	// TLSPolicyDefault leaves the TLS config as it is
+	TLSPolicyDefault TLSPolicy = iota
+	// TLSPolicyModern only accepts TLS 1.3
+	TLSPolicyModern
+	// TLSPolicyIntermediate accepts TLS 1.2 with forward secret AEAD cipher
+	// suites, and TLS 1.3
+	TLSPolicyIntermediate
+	// TLSPolicyCompatible is TLSPolicyIntermediate plus
+	// TLS_RSA_WITH_AES_128_CBC_SHA, which RFC5425 makes mandatory to
+	// implement, for older devices
+	TLSPolicyCompatible
+)
+
+var intermediateCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// Apply returns a copy of config, which may be nil, restricted by the policy
+func (p TLSPolicy) Apply(config *tls.Config) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+	switch p {
+	case TLSPolicyModern:
+		config.MinVersion = tls.VersionTLS13
+	case TLSPolicyIntermediate:
+		config.MinVersion = tls.VersionTLS12
+		config.CipherSuites = intermediateCipherSuites
+	case TLSPolicyCompatible:
+		config.MinVersion = tls.VersionTLS12
+		config.CipherSuites = append(append([]uint16(nil), intermediateCipherSuites...), tls.TLS_RSA_WITH_AES_128_CBC_SHA)
+	}
+	return config
+}
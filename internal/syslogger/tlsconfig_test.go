@@ -0,0 +1,148 @@
+package syslog
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+// newTestCert issues a certificate signed by ca, or a self signed CA if ca is
+// nil
+func newTestCert(c *C, ca *testCert, cn string, sans ...string) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	c.Assert(err, IsNil)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Example"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     sans,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	parent, signer := tmpl, key
+	if ca == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	c.Assert(err, IsNil)
+	cert, err := x509.ParseCertificate(der)
+	c.Assert(err, IsNil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	c.Assert(err, IsNil)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (t *testCert) tlsCertificate(c *C) tls.Certificate {
+	cert, err := tls.X509KeyPair(t.certPEM, t.keyPEM)
+	c.Assert(err, IsNil)
+	return cert
+}
+
+// writeTLSFiles writes the server certificate and client CA to dir
+func writeTLSFiles(c *C, dir string, server, ca *testCert) TLSFiles {
+	files := TLSFiles{
+		CertFile:     filepath.Join(dir, "server.pem"),
+		KeyFile:      filepath.Join(dir, "server.key"),
+		ClientCAFile: filepath.Join(dir, "ca.pem"),
+	}
+	c.Assert(os.WriteFile(files.CertFile, server.certPEM, 0600), IsNil)
+	c.Assert(os.WriteFile(files.KeyFile, server.keyPEM, 0600), IsNil)
+	c.Assert(os.WriteFile(files.ClientCAFile, ca.certPEM, 0600), IsNil)
+	return files
+}
+
+func (s *ServerSuite) TestCertReloader(c *C) {
+	ca := newTestCert(c, nil, "ca")
+	dir := c.MkDir()
+	files := writeTLSFiles(c, dir, newTestCert(c, ca, "server1", "server1"), ca)
+	files.ReloadInterval = 10 * time.Millisecond
+
+	reloader, err := NewCertReloader(files)
+	c.Assert(err, IsNil)
+	config := reloader.Config(&tls.Config{MinVersion: tls.VersionTLS12})
+	current := func() *tls.Config {
+		conf, err := config.GetConfigForClient(nil)
+		c.Assert(err, IsNil)
+		return conf
+	}
+	c.Check(current().MinVersion, Equals, uint16(tls.VersionTLS12))
+	c.Check(current().ClientAuth, Equals, tls.RequireAndVerifyClientCert)
+	leaf, err := x509.ParseCertificate(current().Certificates[0].Certificate[0])
+	c.Assert(err, IsNil)
+	c.Check(leaf.Subject.CommonName, Equals, "server1")
+
+	reloader.Watch()
+	defer reloader.Stop()
+	server2 := newTestCert(c, ca, "server2", "server2")
+	c.Assert(os.WriteFile(files.KeyFile, server2.keyPEM, 0600), IsNil)
+	c.Assert(os.WriteFile(files.CertFile, server2.certPEM, 0600), IsNil)
+	later := time.Now().Add(time.Second)
+	c.Assert(os.Chtimes(files.CertFile, later, later), IsNil)
+	for i := 0; i < 100; i++ {
+		leaf, err = x509.ParseCertificate(current().Certificates[0].Certificate[0])
+		c.Assert(err, IsNil)
+		if leaf.Subject.CommonName == "server2" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.Check(leaf.Subject.CommonName, Equals, "server2")
+
+	// a broken rotation keeps the previous certificate
+	c.Assert(os.WriteFile(files.CertFile, []byte("garbage"), 0600), IsNil)
+	c.Check(reloader.Reload(), NotNil)
+	leaf, err = x509.ParseCertificate(current().Certificates[0].Certificate[0])
+	c.Assert(err, IsNil)
+	c.Check(leaf.Subject.CommonName, Equals, "server2")
+
+	_, err = NewCertReloader(TLSFiles{CertFile: files.CertFile})
+	c.Check(err, ErrorMatches, "tls files without a certificate or key")
+}
+
+func hasCipherSuite(suites []uint16, id uint16) bool {
+	for _, suite := range suites {
+		if suite == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *ServerSuite) TestTLSPolicy(c *C) {
+	c.Check(TLSPolicyDefault.Apply(nil).MinVersion, Equals, uint16(0))
+	c.Check(TLSPolicyModern.Apply(nil).MinVersion, Equals, uint16(tls.VersionTLS13))
+	base := &tls.Config{ServerName: "syslog"}
+	config := TLSPolicyIntermediate.Apply(base)
+	c.Check(config.ServerName, Equals, "syslog")
+	c.Check(config.MinVersion, Equals, uint16(tls.VersionTLS12))
+	c.Check(hasCipherSuite(config.CipherSuites, tls.TLS_RSA_WITH_AES_128_CBC_SHA), Equals, false)
+	c.Check(base.MinVersion, Equals, uint16(0))
+	c.Check(hasCipherSuite(TLSPolicyCompatible.Apply(nil).CipherSuites, tls.TLS_RSA_WITH_AES_128_CBC_SHA), Equals, true)
+}
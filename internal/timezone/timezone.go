@@ -0,0 +1,132 @@
+// Package timezone decides which time zone a device logs in, for formats
+// such as RFC3164 and CiscoXR whose timestamps carry no usable offset. The
+// Resolver is set on the syslog.Server, which hands the location to the
+// parser before parsing.
+package timezone
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/metajar/metalogger/internal/source"
+)
+
+// Resolver picks a location by hostname, then by the longest matching source
+// network, then through the lookup function, falling back to the default.
+type Resolver struct {
+	def     *time.Location
+	sources *source.Matcher
+	cidrs   map[string]*time.Location
+	lookup  func(ip, hostname string) *time.Location
+}
+
+type Option func(*Resolver)
+
+// WithDefault sets the location of devices nothing else matches. Defaults to
+// UTC.
+func WithDefault(loc *time.Location) Option {
+	return func(r *Resolver) {
+		r.def = loc
+	}
+}
+
+// WithHostname sets the location of a device by the hostname in its
+// messages. Matching is case insensitive and a short name also matches the
+// fully qualified one.
+func WithHostname(hostname string, loc *time.Location) Option {
+	return func(r *Resolver) {
+		r.sources.AddHostname(hostname, loc)
+	}
+}
+
+// WithCIDR sets the location of devices sending from a network.
+func WithCIDR(cidr string, loc *time.Location) Option {
+	return func(r *Resolver) {
+		r.cidrs[cidr] = loc
+	}
+}
+
+// WithLookup consults f, e.g. an inventory, for devices not configured by
+// hostname or network. f returns nil if it does not know the device.
+func WithLookup(f func(ip, hostname string) *time.Location) Option {
+	return func(r *Resolver) {
+		r.lookup = f
+	}
+}
+
+// New returns an error if a network is invalid.
+func New(opts ...Option) (*Resolver, error) {
+	r := &Resolver{
+		def:     time.UTC,
+		sources: source.NewMatcher(),
+		cidrs:   map[string]*time.Location{},
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	for cidr, loc := range r.cidrs {
+		if err := r.sources.AddCIDR(cidr, loc); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// File is the JSON layout read by LoadFile, locations are IANA names such as
+// Europe/Berlin.
+type File struct {
+	Default   string            `json:"default"`
+	Hostnames map[string]string `json:"hostnames"`
+	CIDRs     map[string]string `json:"cidrs"`
+}
+
+// LoadFile builds a Resolver from a JSON File, opts are applied after it.
+func LoadFile(path string, opts ...Option) (*Resolver, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f File
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	var fileOpts []Option
+	if f.Default != "" {
+		loc, err := time.LoadLocation(f.Default)
+		if err != nil {
+			return nil, fmt.Errorf("default: %w", err)
+		}
+		fileOpts = append(fileOpts, WithDefault(loc))
+	}
+	for host, name := range f.Hostnames {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("hostname %s: %w", host, err)
+		}
+		fileOpts = append(fileOpts, WithHostname(host, loc))
+	}
+	for cidr, name := range f.CIDRs {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			return nil, fmt.Errorf("cidr %s: %w", cidr, err)
+		}
+		fileOpts = append(fileOpts, WithCIDR(cidr, loc))
+	}
+	return New(append(fileOpts, opts...)...)
+}
+
+// Resolve returns the location of the device at ip, hostname may be empty if
+// it is not known yet.
+func (r *Resolver) Resolve(ip, hostname string) *time.Location {
+	if loc, ok := r.sources.Match(ip, hostname); ok {
+		return loc.(*time.Location)
+	}
+	if r.lookup != nil {
+		if loc := r.lookup(ip, hostname); loc != nil {
+			return loc
+		}
+	}
+	return r.def
+}
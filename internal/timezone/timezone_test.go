@@ -0,0 +1,69 @@
+package timezone
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type TimezoneSuite struct{}
+
+var _ = Suite(&TimezoneSuite{})
+
+func load(c *C, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	c.Assert(err, IsNil)
+	return loc
+}
+
+func (s *TimezoneSuite) TestResolve(c *C) {
+	berlin := load(c, "Europe/Berlin")
+	ny := load(c, "America/New_York")
+	tokyo := load(c, "Asia/Tokyo")
+	r, err := New(
+		WithDefault(ny),
+		WithHostname("Core1", berlin),
+		WithCIDR("10.0.0.0/8", tokyo),
+		WithCIDR("10.1.0.0/16", berlin),
+		WithLookup(func(ip, hostname string) *time.Location {
+			if hostname == "inventory1" {
+				return time.UTC
+			}
+			return nil
+		}),
+	)
+	c.Assert(err, IsNil)
+	c.Check(r.Resolve("192.0.2.1", "core1.example.net"), Equals, berlin)
+	c.Check(r.Resolve("10.1.2.3", ""), Equals, berlin)
+	c.Check(r.Resolve("10.2.2.3", ""), Equals, tokyo)
+	c.Check(r.Resolve("10.2.2.3", "CORE1"), Equals, berlin)
+	c.Check(r.Resolve("192.0.2.1", "inventory1"), Equals, time.UTC)
+	c.Check(r.Resolve("192.0.2.1", "other"), Equals, ny)
+	c.Check(r.Resolve("not an ip", ""), Equals, ny)
+
+	_, err = New(WithCIDR("10.0.0.0/33", tokyo))
+	c.Check(err, ErrorMatches, "invalid CIDR address: 10.0.0.0/33")
+}
+
+func (s *TimezoneSuite) TestLoadFile(c *C) {
+	path := filepath.Join(c.MkDir(), "tz.json")
+	c.Assert(os.WriteFile(path, []byte(`{
+  "default": "UTC",
+  "hostnames": {"edge1": "Asia/Tokyo"},
+  "cidrs": {"2001:db8::/32": "Europe/Berlin"}
+}`), 0644), IsNil)
+	r, err := LoadFile(path)
+	c.Assert(err, IsNil)
+	c.Check(r.Resolve("", "edge1").String(), Equals, "Asia/Tokyo")
+	c.Check(r.Resolve("2001:db8::1", "").String(), Equals, "Europe/Berlin")
+	c.Check(r.Resolve("192.0.2.1", ""), Equals, time.UTC)
+
+	c.Assert(os.WriteFile(path, []byte(`{"hostnames": {"edge1": "Mars/Olympus"}}`), 0644), IsNil)
+	_, err = LoadFile(path)
+	c.Check(err, ErrorMatches, "hostname edge1: .*")
+}